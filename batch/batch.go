@@ -0,0 +1,100 @@
+package batch
+
+import (
+	"net"
+
+	packet "github.com/stormentt/packetloss/packet"
+	"golang.org/x/net/ipv4"
+)
+
+// Conn reads & writes UDP packets in batches, with one recvmmsg or sendmmsg call per batch on Linux.
+// Other platforms read & write a packet at a time behind the same interface.
+// Its buffers are reused from batch to batch, so reads & writes don't allocate. Reading and writing can be done from
+// different goroutines, but only one goroutine may read and one may write at a time
+type Conn struct {
+	pc *ipv4.PacketConn
+
+	in  []ipv4.Message
+	out []ipv4.Message
+
+	// queued is how many messages in out are waiting to be written
+	queued int
+}
+
+// NewConn wraps conn to read & write up to size packets at a time
+func NewConn(conn *net.UDPConn, size int) *Conn {
+	if size < 1 {
+		size = 1
+	}
+
+	c := &Conn{
+		pc:  ipv4.NewPacketConn(conn),
+		in:  make([]ipv4.Message, size),
+		out: make([]ipv4.Message, size),
+	}
+
+	for i := range c.in {
+		c.in[i].Buffers = [][]byte{make([]byte, packet.MaxPacketSize)}
+		c.out[i].Buffers = [][]byte{nil}
+	}
+
+	return c
+}
+
+// Read waits for packets and returns up to a batch of them. Each message's packet is Buffers[0][:N], from Addr.
+// The messages are only valid until the next Read
+func (c *Conn) Read() ([]ipv4.Message, error) {
+	n, err := c.pc.ReadBatch(c.in, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.in[:n], nil
+}
+
+// Queue copies data into the batch to be written to addr, nil for a connected socket.
+// A full batch is written straight away, otherwise the batch is written by Flush
+func (c *Conn) Queue(data []byte, addr net.Addr) error {
+	msg := &c.out[c.queued]
+	msg.Buffers[0] = append(msg.Buffers[0][:0], data...)
+	msg.Addr = addr
+
+	c.queued++
+	if c.queued == len(c.out) {
+		return c.Flush()
+	}
+
+	return nil
+}
+
+// Flush writes every queued packet. If writing fails the rest of the batch is dropped
+func (c *Conn) Flush() error {
+	msgs := c.out[:c.queued]
+	c.queued = 0
+
+	for len(msgs) > 0 {
+		n, err := c.pc.WriteBatch(msgs, 0)
+		if err != nil {
+			return err
+		}
+
+		if n == 0 {
+			return nil
+		}
+
+		msgs = msgs[n:]
+	}
+
+	return nil
+}
+
+// SetBuffers asks for conn's receive & send buffers to be size bytes, so bursts of packets aren't dropped while they
+// wait to be read. The OS may cap the size, on Linux at net.core.rmem_max & net.core.wmem_max
+func SetBuffers(conn *net.UDPConn, size int) error {
+	err := conn.SetReadBuffer(size)
+	if err != nil {
+		return err
+	}
+
+	return conn.SetWriteBuffer(size)
+}
@@ -0,0 +1,133 @@
+package batch
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+// readAll reads batches from c until want packets have arrived, returning them as strings
+func readAll(t *testing.T, conn *net.UDPConn, c *Conn, want int) []string {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var got []string
+	for len(got) < want {
+		msgs, err := c.Read()
+		if err != nil {
+			t.Fatalf("read %d of %d packets: %v", len(got), want, err)
+		}
+
+		for _, msg := range msgs {
+			got = append(got, string(msg.Buffers[0][:msg.N]))
+		}
+	}
+
+	return got
+}
+
+func TestQueueFlush(t *testing.T) {
+	src := listen(t)
+	dst := listen(t)
+
+	out := NewConn(src, 4)
+	in := NewConn(dst, 4)
+
+	// 10 packets make two full batches, written as they fill, and a partial one left for Flush
+	want := make([]string, 10)
+	for i := range want {
+		want[i] = fmt.Sprintf("packet %d", i)
+
+		err := out.Queue([]byte(want[i]), dst.LocalAddr())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if out.queued != 2 {
+		t.Fatalf("%d packets queued, expected 2 left after two full batches", out.queued)
+	}
+
+	err := out.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := readAll(t, dst, in, len(want))
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("packet %d is %q, expected %q", i, got[i], want[i])
+		}
+	}
+}
+
+// TestQueueCopies checks Queue copies the packet, so the caller can reuse its buffer straight away
+func TestQueueCopies(t *testing.T) {
+	src := listen(t)
+	dst := listen(t)
+
+	out := NewConn(src, 8)
+	in := NewConn(dst, 8)
+
+	buf := []byte("first")
+	out.Queue(buf, dst.LocalAddr())
+	copy(buf, "XXXXX")
+	out.Queue([]byte("second"), dst.LocalAddr())
+
+	err := out.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := readAll(t, dst, in, 2)
+	if got[0] != "first" || got[1] != "second" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestReadAddr(t *testing.T) {
+	src := listen(t)
+	dst := listen(t)
+
+	in := NewConn(dst, 1)
+
+	_, err := src.WriteTo([]byte("hello"), dst.LocalAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	msgs, err := in.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(msgs) != 1 || string(msgs[0].Buffers[0][:msgs[0].N]) != "hello" {
+		t.Fatalf("read %v", msgs)
+	}
+
+	if msgs[0].Addr.String() != src.LocalAddr().String() {
+		t.Errorf("packet from %v, expected %v", msgs[0].Addr, src.LocalAddr())
+	}
+}
+
+func TestFlushEmpty(t *testing.T) {
+	out := NewConn(listen(t), 4)
+
+	err := out.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,6 +1,9 @@
 package client
 
 import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
 	"time"
@@ -8,58 +11,212 @@ import (
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"github.com/stormentt/packetloss/keyring"
+	"github.com/stormentt/packetloss/metrics"
 	packet "github.com/stormentt/packetloss/packet"
+	"github.com/stormentt/packetloss/report"
 	wrapper "github.com/stormentt/packetloss/wrapper"
 )
 
 // wrapSerial
 type wrapSerial struct {
+	// Target is the index of the target the packet was sent to or received from
+	Target int
+
 	Serial uint64
 	Type   packet.PacketType
 
 	Timestamp time.Time
+
+	// ServerRecvTime, ServerSendTime, ServerReceived, & ReceivedSize are only set on acknowledgements
+	ServerRecvTime time.Time
+	ServerSendTime time.Time
+	ServerReceived uint64
+	ReceivedSize   uint32
+
+	// Size & Bucket are only set on sent packets, see PacketRecord
+	Size   int
+	Bucket int
+}
+
+// Summary is the summary of a finished run for one target
+type Summary struct {
+	Target *Target
+	Stats  *ClientStats
+}
+
+// targetRun is a target being probed, along with its connection & records
+type targetRun struct {
+	*Target
+
+	conn    PacketConn
+	io      *batchIO
+	session uint64
+
+	record  *ClientRecord
+	summary *ClientStats
+
+	// started is set by the event loop once conn is open & the server has been said hello to
+	started bool
+}
+
+// targetEvent tells the event loop a target has been started, couldn't be started, or has finished sending
+type targetEvent struct {
+	Target int
+
+	// Started is set once the target is running, Err if it couldn't be started. Neither is set once it has finished sending
+	Started bool
+	Err     error
 }
 
-// Start sends UDP packets to raddr and keeps track of sent packets & acknowledgements.
-// hkey is used to create message authentication codes
-func Start(hkey []byte, raddr *net.UDPAddr) error {
-	conn, err := net.DialUDP("udp", nil, raddr)
+// Options changes how StartWith runs its targets
+type Options struct {
+	// Dial opens each target's PacketConn, DialUDP if it is nil
+	Dial Dialer
+
+	// Retry keeps trying to start targets that can't be started every RetryInterval, instead of skipping them
+	Retry         bool
+	RetryInterval time.Duration
+
+	// Sinks are published every report, the sinks in the config are used if it is nil
+	Sinks []metrics.Sink
+}
+
+// Start sends UDP packets to every target and keeps track of sent packets & acknowledgements.
+// Every target has its own sender & receiver, but they share one event loop and one output stream.
+// Targets that can't be started are logged and skipped, Start only fails if none of them can be.
+// If the run is limited by count or duration, Start returns once the last acks have been drained,
+// along with a summary of the whole run for each target
+func Start(targets []*Target) ([]Summary, error) {
+	return StartWith(targets, Options{})
+}
+
+// StartWith is Start, changed by opts
+func StartWith(targets []*Target, opts Options) ([]Summary, error) {
+	out, err := report.FromConfig()
 	if err != nil {
-		return nil
+		return nil, err
+	}
+
+	sinks := opts.Sinks
+	if sinks == nil {
+		sinks, err = metrics.FromConfig()
+		if err != nil {
+			return nil, err
+		}
+
+		defer metrics.Close(sinks)
+	}
+
+	if opts.Dial == nil {
+		opts.Dial = DialUDP
 	}
 
-	defer conn.Close()
+	runs := make([]*targetRun, len(targets))
+	for i, t := range targets {
+		runs[i] = &targetRun{
+			Target:  t,
+			record:  NewClientRecord(viper.GetDuration("ack_timeout")),
+			summary: NewClientStats(),
+		}
+	}
+
+	defer func() {
+		for _, run := range runs {
+			if run.started {
+				run.conn.Close()
+			}
+		}
+	}()
 
-	cr := NewClientRecord()
 	lastRemediation := time.Now()
 
-	ch := make(chan wrapSerial, 10)
+	ch := make(chan wrapSerial, 1024*len(runs))
 
-	go recvPackets(conn, hkey, ch)
-	go sendPackets(conn, hkey, ch)
+	// every target sends at most two events, so they never block once the loop has stopped
+	events := make(chan targetEvent, 2*len(runs))
 
-	for ws := range ch {
-		switch ws.Type {
-		case packet.PacketType_REQPACKET:
-			cr.Send(ws.Serial, ws.Timestamp)
-		case packet.PacketType_ACKPACKET:
-			if ws.Serial > cr.LastSent {
-				log.WithFields(log.Fields{
-					"Serial":   ws.Serial,
-					"LastSent": cr.LastSent,
-				}).Warn("received an ack for a packet we haven't sent yet")
+	for i, run := range runs {
+		go run.start(i, opts, ch, events)
+	}
+
+	// pending counts the targets that haven't finished sending or failed to start
+	pending := len(runs)
+	failed := 0
+	var drained <-chan time.Time
+
+	for {
+		var ws wrapSerial
+
+		select {
+		case ws = <-ch:
+		case ev := <-events:
+			if ev.Started {
+				runs[ev.Target].started = true
 				continue
 			}
 
-			if ws.Serial <= cr.LastAck {
+			if ev.Err != nil {
+				if len(runs) == 1 {
+					return nil, ev.Err
+				}
+
 				log.WithFields(log.Fields{
-					"Serial":  ws.Serial,
-					"LastAck": cr.LastAck,
-				}).Warn("received an ack for an old packet")
+					"Error":         ev.Err,
+					"Target":        runs[ev.Target].Name,
+					"RemoteAddress": runs[ev.Target].Remote,
+				}).Error("could not start target, skipping it")
+
+				failed++
+				if failed == len(runs) {
+					return nil, fmt.Errorf("could not start any of the %d targets", len(runs))
+				}
+			}
+
+			pending--
+			if pending > 0 {
 				continue
 			}
 
-			cr.Ack(ws.Serial, ws.Timestamp)
+			log.WithFields(log.Fields{
+				"Drain": viper.GetDuration("drain"),
+			}).Info("finished sending, waiting for the last acks")
+
+			drained = time.After(viper.GetDuration("drain"))
+			continue
+		case <-drained:
+			// anything still unacked is lost, the run is over
+			summaries := make([]Summary, 0, len(runs))
+			for _, run := range runs {
+				if !run.started {
+					continue
+				}
+
+				now := time.Now().Add(run.record.AckTimeout)
+				stats := run.record.Remediate(now)
+				run.record.Reset(now)
+
+				run.summary.Merge(stats)
+
+				outputStats(out, sinks, stats, run.Target, false)
+				outputStats(out, sinks, run.summary, run.Target, true)
+
+				summaries = append(summaries, Summary{
+					Target: run.Target,
+					Stats:  run.summary,
+				})
+			}
+
+			return summaries, nil
+		}
+
+		cr := runs[ws.Target].record
+
+		switch ws.Type {
+		case packet.PacketType_REQPACKET:
+			cr.Send(ws.Serial, ws.Timestamp, ws.Size, ws.Bucket)
+		case packet.PacketType_ACKPACKET:
+			cr.Ack(ws.Serial, ws.Timestamp, ws.ServerRecvTime, ws.ServerSendTime, ws.ServerReceived, ws.ReceivedSize)
 
 		default:
 			log.WithFields(log.Fields{
@@ -70,41 +227,207 @@ func Start(hkey []byte, raddr *net.UDPAddr) error {
 		}
 
 		if time.Since(lastRemediation) > viper.GetDuration("update-time") {
-			stats := cr.Remediate()
-			cr.Reset()
+			now := time.Now()
+			for _, run := range runs {
+				if !run.started {
+					continue
+				}
 
-			log.WithFields(log.Fields{
-				"Total":        stats.Total,
-				"Sent":         stats.TotalSent,
-				"Acked":        stats.TotalAcked,
-				"SentAndAcked": stats.SentAndAcked,
-				"SentNotAcked": stats.SentNotAcked,
-				"AckedNotSent": stats.AckedNotSent,
-			}).Info("Totals")
-
-			log.WithFields(log.Fields{
-				"SentAndAcked": fmt.Sprintf("%.2f", stats.SAAPercent),
-				"SentNotAcked": fmt.Sprintf("%.2f", stats.SNAPercent),
-				"AckedNotSent": fmt.Sprintf("%.2f", stats.ANSPercent),
-			}).Info("Percents")
+				stats := run.record.Remediate(now)
+				run.record.Reset(now)
 
-			log.WithFields(log.Fields{
-				"Avg": stats.AvgRTT,
-				"Min": stats.MinRTT,
-				"Max": stats.MaxRTT,
-			}).Info("RTT")
+				run.summary.Merge(stats)
+				outputStats(out, sinks, stats, run.Target, false)
+			}
 
 			lastRemediation = time.Now()
 		}
 	}
+}
 
-	return nil
+// start connects run's target, retrying every opts.RetryInterval if opts.Retry is set, then sends packets to it
+// and receives its acks until it has finished sending. The event loop is told about each step over events
+func (run *targetRun) start(index int, opts Options, ch chan<- wrapSerial, events chan<- targetEvent) {
+	for {
+		conn, session, err := connectTarget(run.Target, opts.Dial)
+		if err == nil {
+			run.conn = conn
+			run.io = newBatchIO(conn, viper.GetInt("batch"))
+			run.session = session
+			break
+		}
+
+		if !opts.Retry {
+			events <- targetEvent{Target: index, Err: err}
+			return
+		}
+
+		log.WithFields(log.Fields{
+			"Error":         err,
+			"Target":        run.Name,
+			"RemoteAddress": run.Remote,
+			"RetryInterval": opts.RetryInterval,
+		}).Warn("could not start target, retrying")
+
+		time.Sleep(opts.RetryInterval)
+	}
+
+	events <- targetEvent{Target: index, Started: true}
+
+	go recvPackets(run, index, ch)
+	sendPackets(run, index, ch)
+
+	events <- targetEvent{Target: index}
 }
 
-// sendPackets sends packets to conn
-// hkey is used to create message authentication codes for these packets
-// sent packets have their serial numbers sent over ch, to be used for recordkeeping
-func sendPackets(conn *net.UDPConn, hkey []byte, ch chan<- wrapSerial) {
+// connectTarget opens t's PacketConn with dial, says hello to its server, and resets the client's stats there.
+// It returns the open conn and the session started on the server
+func connectTarget(t *Target, dial Dialer) (PacketConn, uint64, error) {
+	conn, err := dial(t)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	session, err := newSession()
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+
+	if sc, ok := conn.(SessionConn); ok {
+		sc.SetSession(session)
+	}
+
+	var serverVersion uint32
+	if !viper.GetBool("no_hello") {
+		hello, err := handshake(conn, t, session)
+		if err != nil {
+			conn.Close()
+			return nil, 0, err
+		}
+
+		serverVersion = hello.ProtocolVersion
+	}
+
+	err = resetSession(conn, t, session, serverVersion >= packet.ResetAckVersion)
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+
+	return conn, session, nil
+}
+
+// outputStats writes t's stats to out as a report, or logs them if out is nil
+// the report is also published to every metrics sink
+func outputStats(out *report.Writer, sinks []metrics.Sink, stats *ClientStats, t *Target, summary bool) {
+	r := stats.Report(t.ClientID, t.Remote.String(), summary)
+	r.Target = t.Name
+	r.Client.Schedule = t.schedule.Report()
+
+	metrics.Publish(sinks, r)
+
+	if out == nil {
+		printStats(stats, t.Name, summary)
+		return
+	}
+
+	err := out.Write(r)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Error": err,
+		}).Error("could not write report")
+	}
+}
+
+// printStats logs stats, every line is tagged with the target's name if it has one, and summary stats are marked as such
+func printStats(stats *ClientStats, target string, summary bool) {
+	entry := log.WithFields(log.Fields{})
+	if target != "" {
+		entry = entry.WithField("Target", target)
+	}
+
+	if summary {
+		entry = entry.WithField("Summary", true)
+	}
+
+	entry.WithFields(log.Fields{
+		"Total":        stats.Total,
+		"Sent":         stats.TotalSent,
+		"Acked":        stats.TotalAcked,
+		"SentAndAcked": stats.SentAndAcked,
+		"SentNotAcked": stats.SentNotAcked,
+		"AckedNotSent": stats.AckedNotSent,
+		"Late":         stats.Late,
+		"LateAcks":     stats.LateAcks,
+		"Upstream":     stats.UpstreamLost,
+		"Downstream":   stats.DownstreamLost,
+		"ClockOffset":  stats.ClockOffset,
+		"ClockDrift":   fmt.Sprintf("%.3fppm", stats.ClockDrift),
+	}).Info("Totals")
+
+	entry.WithFields(log.Fields{
+		"SentAndAcked": fmt.Sprintf("%.2f", stats.SAAPercent),
+		"SentNotAcked": fmt.Sprintf("%.2f", stats.SNAPercent),
+		"AckedNotSent": fmt.Sprintf("%.2f", stats.ANSPercent),
+		"Late":         fmt.Sprintf("%.2f", stats.LatePercent),
+		"Upstream":     fmt.Sprintf("%.2f", stats.UpstreamPercent),
+		"Downstream":   fmt.Sprintf("%.2f", stats.DownstreamPercent),
+	}).Info("Percents")
+
+	entry.WithFields(log.Fields{
+		"Avg":    stats.AvgRTT,
+		"Min":    stats.MinRTT,
+		"Max":    stats.MaxRTT,
+		"StdDev": stats.StdDevRTT,
+		"P50":    stats.P50RTT,
+		"P90":    stats.P90RTT,
+		"P95":    stats.P95RTT,
+		"P99":    stats.P99RTT,
+		"P99.9":  stats.P999RTT,
+	}).Info("RTT")
+
+	entry.WithFields(log.Fields{
+		"ForwardAvg": stats.AvgForward,
+		"ForwardMin": stats.MinForward,
+		"ForwardMax": stats.MaxForward,
+		"ReverseAvg": stats.AvgReverse,
+		"ReverseMin": stats.MinReverse,
+		"ReverseMax": stats.MaxReverse,
+	}).Info("OneWay")
+
+	entry.WithFields(log.Fields{
+		"Jitter":      stats.Jitter,
+		"IPDVMin":     stats.IPDVMin,
+		"IPDVMax":     stats.IPDVMax,
+		"IPDVMeanAbs": stats.IPDVMeanAbs,
+	}).Info("Jitter")
+
+	entry.WithFields(log.Fields{
+		"Runs":          stats.LossRuns,
+		"LongestRun":    stats.LongestLossRun,
+		"LongestOutage": stats.LongestOutage,
+		"RunLengths":    fmt.Sprintf("%v", stats.LossRunLengths),
+		"GEP":           fmt.Sprintf("%.4f", stats.GilbertElliott.P),
+		"GER":           fmt.Sprintf("%.4f", stats.GilbertElliott.R),
+		"GELossInBad":   fmt.Sprintf("%.4f", stats.GilbertElliott.LossInBad),
+		"GESimple":      stats.GilbertElliott.Simple,
+	}).Info("LossRuns")
+
+	for _, bucket := range stats.Sizes {
+		entry.WithFields(log.Fields{
+			"Size":        bucket.Size,
+			"Sent":        bucket.Sent,
+			"Acked":       bucket.Acked,
+			"Lost":        bucket.Lost,
+			"Late":        bucket.Late,
+			"LossPercent": fmt.Sprintf("%.2f", bucket.LossPercent),
+		}).Info("Size")
+	}
+}
+
+// clientIDFromConfig returns the configured ClientID, or a random one if none is configured
+func clientIDFromConfig() (string, error) {
 	clientID := viper.GetString("client_id")
 	if len(clientID) == 0 {
 		log.Debug("no client ID specified, generating random")
@@ -112,56 +435,148 @@ func sendPackets(conn *net.UDPConn, hkey []byte, ch chan<- wrapSerial) {
 	}
 
 	if len(clientID) > 64 {
-		log.WithFields(log.Fields{
-			"ClientID": clientID,
-			"Length":   len(clientID),
-		}).Fatal("clientID length too long, max length 64")
-
-		return
+		return "", fmt.Errorf("clientID length too long, max length 64 (length %d)", len(clientID))
 	}
 
-	var serial uint64 = 1
+	return clientID, nil
+}
+
+// newSession picks a random, non zero session for this run of the client
+func newSession() (uint64, error) {
+	var buf [8]byte
+
+	for {
+		_, err := rand.Read(buf[:])
+		if err != nil {
+			return 0, err
+		}
 
-	resetP := &packet.Packet{
-		PacketType: packet.PacketType_RESETPACKET,
-		Serial:     serial,
-		ClientID:   clientID,
+		if session := binary.BigEndian.Uint64(buf[:]); session != 0 {
+			return session, nil
+		}
 	}
+}
+
+// sendPackets sends packets to run's server when run's schedule says, identifying itself as run's ClientID in run's session.
+// Packets are due at times set by the schedule from when sending started, so time spent sending doesn't add up
+// run's key is used to create message authentication codes for these packets, and each packet is padded to the size
+// picked by run's sizes. Sent packets have their serial numbers sent over ch tagged with index, to be used for recordkeeping
+// sendPackets returns once it has sent count packets or run for duration
+func sendPackets(run *targetRun, index int, ch chan<- wrapSerial) {
+	var serial uint64 = 1
 
-	err := sendPacket(conn, hkey, resetP)
+	// every packet is the same apart from its serial, send time, & size, so they are encoded from a template
+	tmpl, err := wrapper.NewTemplate(&packet.Packet{
+		PacketType: packet.PacketType_REQPACKET,
+		ClientID:   run.ClientID,
+		Session:    run.session,
+		EchoSize:   run.echoSize && run.sizes != nil,
+	}, run.Key, run.Mode)
 	if err != nil {
+		log.WithFields(log.Fields{
+			"Error":  err,
+			"Target": run.Name,
+		}).Error("unable to encode packets")
+
 		return
 	}
 
+	buff := make([]byte, 0, packet.MaxPacketSize)
+
+	count := viper.GetUint64("count")
+	duration := viper.GetDuration("duration")
+	start := time.Now()
+
+	due := start
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	// packets that are due together are queued up and written in one batch before waiting for the next
+	defer flushPackets(run)
+
 	for {
-		time.Sleep(viper.GetDuration("packet_time"))
+		var behind time.Duration
+		due, behind = run.schedule.after(due, time.Now())
+		if behind > 0 {
+			log.WithFields(log.Fields{
+				"Behind": behind,
+				"Target": run.Name,
+			}).Warn("fell behind the send schedule, skipping ahead")
+		}
 
-		p := &packet.Packet{
-			PacketType: packet.PacketType_REQPACKET,
-			Serial:     serial,
-			ClientID:   clientID,
+		if wait := time.Until(due); wait > 0 {
+			flushPackets(run)
+
+			timer.Reset(wait)
+			<-timer.C
+		}
+
+		if count != 0 && serial > count {
+			return
 		}
 
-		err = sendPacket(conn, hkey, p)
+		if duration != 0 && time.Since(start) >= duration {
+			return
+		}
+
+		ts := time.Now()
+		size := run.sizes.Next()
+
+		data, err := tmpl.AppendEncode(buff[:0], serial, ts.UnixNano(), size)
 		if err != nil {
+			log.WithFields(log.Fields{
+				"Error": err,
+			}).Error("unable to encode packet")
+
 			continue
 		}
 
+		// record the packet before sending it, so its ack can't beat it to the record keeper
 		ws := wrapSerial{
+			Target:    index,
 			Serial:    serial,
 			Type:      packet.PacketType_REQPACKET,
-			Timestamp: time.Now(),
+			Timestamp: ts,
+			Size:      len(data),
+			Bucket:    run.sizes.Bucket(size),
 		}
 
 		ch <- ws
 
+		err = run.io.Queue(data)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Error": err,
+			}).Error("unable to send packet")
+
+			continue
+		}
+
 		serial++
+	}
+}
 
+// flushPackets writes the packets queued for run
+func flushPackets(run *targetRun) {
+	err := run.io.Flush()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Error": err,
+		}).Error("unable to send packets")
 	}
 }
 
-func sendPacket(conn *net.UDPConn, hkey []byte, p *packet.Packet) error {
-	data, err := wrapper.EncodePacket(p, hkey)
+// modeFromConfig returns how packets should be protected, encrypted if encrypt is set
+func modeFromConfig() wrapper.Mode {
+	if viper.GetBool("encrypt") {
+		return wrapper.ModeAEAD
+	}
+
+	return wrapper.ModeHMAC
+}
+
+func sendPacket(conn PacketConn, key keyring.Key, mode wrapper.Mode, p *packet.Packet) error {
+	data, err := wrapper.Encode(p, key, mode)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"Error": err,
@@ -182,13 +597,18 @@ func sendPacket(conn *net.UDPConn, hkey []byte, p *packet.Packet) error {
 	return nil
 }
 
-// recvPackets receives UDP packets from conn
-// hkey is used to validate incoming message authentication codes
-// received acknowledgements have their serial numbers sent over ch to be used for recordkeeping
-func recvPackets(conn *net.UDPConn, hkey []byte, ch chan<- wrapSerial) {
+// recvPackets receives UDP packets from run's server
+// run's key is used to validate incoming message authentication codes
+// received acknowledgements have their serial numbers sent over ch tagged with index, to be used for recordkeeping
+// acks for any session other than run's session are dropped, they are left over from an earlier run or replayed
+// if packets are being encrypted, unencrypted acks are dropped too
+func recvPackets(run *targetRun, index int, ch chan<- wrapSerial) {
 	for {
-		buff := make([]byte, 1024)
-		n, addr, err := conn.ReadFromUDP(buff)
+		packets, err := run.io.Read()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+
 		if err != nil {
 			log.WithFields(log.Fields{
 				"error": err,
@@ -198,33 +618,81 @@ func recvPackets(conn *net.UDPConn, hkey []byte, ch chan<- wrapSerial) {
 
 		ts := time.Now()
 
+		// decoded packets don't keep any reference to the read's buffers, so they can be reused
+		for _, data := range packets {
+			recvPacket(run, index, ch, data, ts)
+		}
+	}
+}
+
+// recvPacket handles a packet from run's server, received at ts. See recvPackets
+func recvPacket(run *targetRun, index int, ch chan<- wrapSerial, data []byte, ts time.Time) {
+	if log.IsLevelEnabled(log.DebugLevel) {
 		log.WithFields(log.Fields{
-			"n":    n,
-			"addr": addr,
+			"n":    len(data),
+			"addr": run.Remote,
 		}).Debug("received packet")
+	}
 
-		p := &packet.Packet{}
-		err = wrapper.DecodePacket(buff, n, hkey, p)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"error": err,
-			}).Error("could not decode packet")
-			continue
-		}
+	p := &packet.Packet{}
+	ackMode, err := wrapper.DecodePacket(data, len(data), run.Key, p)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("could not decode packet")
+		return
+	}
 
-		if p.PacketType != packet.PacketType_ACKPACKET {
-			log.WithFields(log.Fields{
-				"type": p.PacketType.String(),
-			}).Warn("received a non-ACKPACKET type packet")
-			continue
-		}
+	if run.Mode == wrapper.ModeAEAD && ackMode != wrapper.ModeAEAD {
+		log.WithFields(log.Fields{
+			"Serial": p.Serial,
+		}).Warn("received an unencrypted ack")
+		return
+	}
 
-		ws := wrapSerial{
-			Serial:    p.Serial,
-			Type:      p.PacketType,
-			Timestamp: ts,
-		}
+	if p.PacketType == packet.PacketType_HELLOACKPACKET || p.PacketType == packet.PacketType_RESETACKPACKET {
+		// answers to a hello or reset that was resent, the first answer was already handled
+		log.WithFields(log.Fields{
+			"type": p.PacketType.String(),
+		}).Debug("received a late handshake answer")
+		return
+	}
 
-		ch <- ws
+	if p.PacketType != packet.PacketType_ACKPACKET {
+		log.WithFields(log.Fields{
+			"type": p.PacketType.String(),
+		}).Warn("received a non-ACKPACKET type packet")
+		return
+	}
+
+	if p.Session != run.session {
+		log.WithFields(log.Fields{
+			"Serial":  p.Serial,
+			"Session": p.Session,
+		}).Warn("received an ack for another session")
+		return
+	}
+
+	ws := wrapSerial{
+		Target:         index,
+		Serial:         p.Serial,
+		Type:           p.PacketType,
+		Timestamp:      ts,
+		ServerRecvTime: unixNano(p.ServerRecvTime),
+		ServerSendTime: unixNano(p.ServerSendTime),
+		ServerReceived: p.ServerReceived,
+		ReceivedSize:   p.ReceivedSize,
+	}
+
+	ch <- ws
+}
+
+// unixNano converts a packet timestamp into a time.Time
+// unset (zero) timestamps are converted into the zero time.Time
+func unixNano(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
 	}
+
+	return time.Unix(0, ns)
 }
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"time"
+)
+
+// clockFilterSize is how many recent samples the clock filter picks the minimum delay sample from, same as NTP
+const clockFilterSize = 8
+
+// clockHistorySize is how many filtered samples are kept for estimating drift
+const clockHistorySize = 64
+
+type clockSample struct {
+	// Time is the local time the sample was taken
+	Time time.Time
+
+	Offset time.Duration
+	Delay  time.Duration
+}
+
+// ClockEstimator estimates the offset and drift of the server clock relative to the client clock.
+// It uses the NTP on-wire calculations on the four timestamps of each REQPACKET/ACKPACKET exchange,
+// trusting only the minimum delay samples since they are the least affected by queueing
+type ClockEstimator struct {
+	recent []clockSample
+	next   int
+
+	history []clockSample
+}
+
+// NewClockEstimator creates a new ClockEstimator object
+func NewClockEstimator() *ClockEstimator {
+	return &ClockEstimator{
+		recent:  make([]clockSample, 0, clockFilterSize),
+		history: make([]clockSample, 0, clockHistorySize),
+	}
+}
+
+// Add records an exchange.
+// t1 is when the client sent the request, t2 when the server received it,
+// t3 when the server sent the ack, and t4 when the client received the ack
+func (ce *ClockEstimator) Add(t1, t2, t3, t4 time.Time) {
+	sample := clockSample{
+		Time:   t4,
+		Offset: (t2.Sub(t1) + t3.Sub(t4)) / 2,
+		Delay:  t4.Sub(t1) - t3.Sub(t2),
+	}
+
+	if len(ce.recent) < clockFilterSize {
+		ce.recent = append(ce.recent, sample)
+	} else {
+		ce.recent[ce.next] = sample
+		ce.next = (ce.next + 1) % clockFilterSize
+	}
+
+	best := ce.recent[0]
+	for _, s := range ce.recent[1:] {
+		if s.Delay < best.Delay {
+			best = s
+		}
+	}
+
+	// the filter keeps returning the same sample until a better or newer one replaces it, only record it once
+	if len(ce.history) > 0 && ce.history[len(ce.history)-1].Time.Equal(best.Time) {
+		return
+	}
+
+	if len(ce.history) == clockHistorySize {
+		copy(ce.history, ce.history[1:])
+		ce.history = ce.history[:clockHistorySize-1]
+	}
+
+	ce.history = append(ce.history, best)
+}
+
+// Valid returns true if at least one sample has been recorded
+func (ce *ClockEstimator) Valid() bool {
+	return len(ce.history) > 0
+}
+
+// Drift returns the estimated drift of the server clock relative to the client clock in parts per million.
+// It is the least squares slope of the filtered offsets over time
+func (ce *ClockEstimator) Drift() float64 {
+	if len(ce.history) < 2 {
+		return 0
+	}
+
+	start := ce.history[0].Time
+
+	var n, sumX, sumY, sumXX, sumXY float64
+	for _, s := range ce.history {
+		x := s.Time.Sub(start).Seconds()
+		y := s.Offset.Seconds()
+
+		n++
+		sumX += x
+		sumY += y
+		sumXX += x * x
+		sumXY += x * y
+	}
+
+	denom := n*sumXX - sumX*sumX
+	if denom == 0 {
+		return 0
+	}
+
+	return (n*sumXY - sumX*sumY) / denom * 1e6
+}
+
+// Offset returns the estimated offset of the server clock relative to the client clock at time t.
+// A positive offset means the server clock is ahead of the client clock
+func (ce *ClockEstimator) Offset(t time.Time) time.Duration {
+	if len(ce.history) == 0 {
+		return 0
+	}
+
+	last := ce.history[len(ce.history)-1]
+	correction := float64(t.Sub(last.Time)) * ce.Drift() / 1e6
+
+	return last.Offset + time.Duration(correction)
+}
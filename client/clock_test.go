@@ -0,0 +1,85 @@
+package client
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var clockStart = time.Unix(1000000, 0)
+
+// exchange adds an exchange sent at t1 to a server offset ahead of the client, taking out to reach the server and back
+// to return, with the server taking a millisecond to answer
+func exchange(ce *ClockEstimator, t1 time.Time, offset, out, back time.Duration) {
+	t2 := t1.Add(out + offset)
+	t3 := t2.Add(time.Millisecond)
+	t4 := t3.Add(back - offset)
+
+	ce.Add(t1, t2, t3, t4)
+}
+
+func TestClockOffsetSign(t *testing.T) {
+	for _, offset := range []time.Duration{5 * time.Millisecond, -5 * time.Millisecond} {
+		ce := NewClockEstimator()
+		if ce.Valid() || ce.Offset(clockStart) != 0 {
+			t.Fatal("estimator valid before any exchange")
+		}
+
+		exchange(ce, clockStart, offset, 10*time.Millisecond, 10*time.Millisecond)
+
+		if !ce.Valid() || ce.Offset(clockStart) != offset {
+			t.Errorf("offset %v, expected %v", ce.Offset(clockStart), offset)
+		}
+	}
+}
+
+// TestClockMinDelay checks the estimate comes from the exchange with the least delay, since queueing on one way
+// of the path skews the offset by half the extra delay
+func TestClockMinDelay(t *testing.T) {
+	ce := NewClockEstimator()
+	offset := 3 * time.Millisecond
+
+	exchange(ce, clockStart, offset, 10*time.Millisecond, 10*time.Millisecond)
+	for i := 1; i < clockFilterSize; i++ {
+		queued := time.Duration(i) * time.Millisecond
+		exchange(ce, clockStart.Add(time.Duration(i)*time.Second), offset, 10*time.Millisecond+queued, 10*time.Millisecond)
+	}
+
+	now := clockStart.Add(clockFilterSize * time.Second)
+	if ce.Offset(now) != offset {
+		t.Errorf("offset %v, expected %v from the exchange without queueing", ce.Offset(now), offset)
+	}
+
+	// once the best exchange leaves the filter the best of the rest is used, queued 1ms on the way out
+	exchange(ce, now, offset, 15*time.Millisecond, 10*time.Millisecond)
+	if got, expected := ce.history[len(ce.history)-1].Offset, offset+time.Millisecond/2; got != expected {
+		t.Errorf("offset %v, expected %v", got, expected)
+	}
+}
+
+// TestClockDrift checks a server clock gaining 50µs a second is estimated at 50ppm, and the offset extrapolated
+func TestClockDrift(t *testing.T) {
+	ce := NewClockEstimator()
+	base := -2 * time.Millisecond
+
+	var last time.Time
+	for i := 0; i < 200; i++ {
+		last = clockStart.Add(time.Duration(i) * time.Second)
+		offset := base + time.Duration(i)*50*time.Microsecond
+
+		exchange(ce, last, offset, 10*time.Millisecond, 10*time.Millisecond)
+	}
+
+	if drift := ce.Drift(); math.Abs(drift-50) > 0.01 {
+		t.Errorf("drift %vppm, expected 50ppm", drift)
+	}
+
+	later := last.Add(100 * time.Second)
+	expected := base + 299*50*time.Microsecond
+
+	// samples are dated when the ack arrives, 21ms after the request was sent, which is 1.05µs of drift
+	diff := ce.Offset(later) - expected
+	if diff < -2*time.Microsecond || diff > 2*time.Microsecond {
+		t.Errorf("offset %v, expected %v", ce.Offset(later), expected)
+	}
+}
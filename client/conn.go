@@ -0,0 +1,121 @@
+package client
+
+import (
+	"net"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/stormentt/packetloss/batch"
+	packet "github.com/stormentt/packetloss/packet"
+)
+
+// PacketConn is what a target's packets are sent & received through. Reads return one packet from the target's
+// server at a time, and fail with os.ErrDeadlineExceeded once the read deadline passes or net.ErrClosed once closed.
+// A UDP socket connected to the server is one
+type PacketConn interface {
+	Read(b []byte) (int, error)
+	Write(b []byte) (int, error)
+	SetReadDeadline(t time.Time) error
+	Close() error
+}
+
+// SessionConn is a PacketConn that is told the session it is used for, before anything is sent on it
+type SessionConn interface {
+	PacketConn
+	SetSession(session uint64)
+}
+
+// Dialer opens the PacketConn for a target
+type Dialer func(t *Target) (PacketConn, error)
+
+// DialUDP is the default Dialer, it opens a UDP socket connected to t's remote with socket_buffer byte buffers
+func DialUDP(t *Target) (PacketConn, error) {
+	conn, err := net.DialUDP("udp", nil, t.Remote)
+	if err != nil {
+		return nil, err
+	}
+
+	if size := viper.GetInt("socket_buffer"); size > 0 {
+		err = batch.SetBuffers(conn, size)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Error":  err,
+				"Target": t.Name,
+			}).Warn("could not set socket buffer size")
+		}
+	}
+
+	return conn, nil
+}
+
+// batchIO reads & writes a PacketConn's packets a batch at a time if it is a UDP socket, and one at a time otherwise.
+// One goroutine may read while another writes
+type batchIO struct {
+	conn PacketConn
+	bc   *batch.Conn
+
+	// buff is used by reads that aren't batched, packets is reused to return each read's packets
+	buff    []byte
+	packets [][]byte
+}
+
+// newBatchIO wraps conn to read & write up to size packets at a time
+func newBatchIO(conn PacketConn, size int) *batchIO {
+	bio := &batchIO{
+		conn: conn,
+	}
+
+	if udp, ok := conn.(*net.UDPConn); ok && size > 1 {
+		bio.bc = batch.NewConn(udp, size)
+	} else {
+		bio.buff = make([]byte, packet.MaxPacketSize)
+	}
+
+	return bio
+}
+
+// Read waits for packets and returns up to a batch of them. They are only valid until the next Read
+func (bio *batchIO) Read() ([][]byte, error) {
+	bio.packets = bio.packets[:0]
+
+	if bio.bc == nil {
+		n, err := bio.conn.Read(bio.buff)
+		if err != nil {
+			return nil, err
+		}
+
+		bio.packets = append(bio.packets, bio.buff[:n])
+		return bio.packets, nil
+	}
+
+	msgs, err := bio.bc.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, msg := range msgs {
+		bio.packets = append(bio.packets, msg.Buffers[0][:msg.N])
+	}
+
+	return bio.packets, nil
+}
+
+// Queue writes data, or copies it into the batch to be written by Flush or once the batch is full
+func (bio *batchIO) Queue(data []byte) error {
+	if bio.bc == nil {
+		_, err := bio.conn.Write(data)
+		return err
+	}
+
+	return bio.bc.Queue(data, nil)
+}
+
+// Flush writes every queued packet
+func (bio *batchIO) Flush() error {
+	if bio.bc == nil {
+		return nil
+	}
+
+	return bio.bc.Flush()
+}
@@ -0,0 +1,50 @@
+//go:build linux
+
+package client
+
+import (
+	"net"
+	"syscall"
+)
+
+// setDontFragment sets the DF bit on packets sent from conn. The kernel's cached path MTU is ignored too, so packets
+// larger than it are still sent and every probe tests the path itself
+func setDontFragment(conn *net.UDPConn, ipv4 bool) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if ipv4 {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_PROBE)
+		} else {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_PROBE)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return sockErr
+}
+
+// clearSocketError reads and so clears the error pending on conn, such as an EMSGSIZE from an ICMP "fragmentation
+// needed" for an earlier packet
+func clearSocketError(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		_, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_ERROR)
+	})
+	if err != nil {
+		return err
+	}
+
+	return sockErr
+}
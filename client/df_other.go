@@ -0,0 +1,18 @@
+//go:build !linux
+
+package client
+
+import (
+	"errors"
+	"net"
+)
+
+// setDontFragment is only supported on Linux
+func setDontFragment(conn *net.UDPConn, ipv4 bool) error {
+	return errors.New("setting the DF bit is only supported on Linux")
+}
+
+// clearSocketError does nothing, the DF bit is never set so probes aren't rejected as too big
+func clearSocketError(conn *net.UDPConn) error {
+	return nil
+}
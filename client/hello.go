@@ -0,0 +1,233 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/stormentt/packetloss/keyring"
+	packet "github.com/stormentt/packetloss/packet"
+	wrapper "github.com/stormentt/packetloss/wrapper"
+)
+
+// IncompatibleError is returned when the server can't be used by this client, Reason says why
+type IncompatibleError struct {
+	Reason string
+}
+
+func (e *IncompatibleError) Error() string {
+	return fmt.Sprintf("incompatible server: %s", e.Reason)
+}
+
+// clientFeatures returns the features the client is going to use with t
+func clientFeatures(t *Target) []packet.Feature {
+	features := []packet.Feature{
+		packet.Feature_FEATURE_TIMESTAMPS,
+		packet.Feature_FEATURE_SESSIONS,
+	}
+
+	if t.Mode == wrapper.ModeAEAD {
+		features = append(features, packet.Feature_FEATURE_ENCRYPTION)
+	}
+
+	if t.Key.ID != 0 {
+		features = append(features, packet.Feature_FEATURE_KEY_IDS)
+	}
+
+	if t.sizes != nil {
+		features = append(features, packet.Feature_FEATURE_PAYLOAD_SIZES)
+	}
+
+	return features
+}
+
+// handshake sends HELLOPACKETs to t's server until it answers, up to hello_retries times, waiting hello_timeout for each answer.
+// It returns the server's answer, or an IncompatibleError if the answer shows the client can't use the server with t,
+// for example because it can't receive packets as large as t's sizes.
+// It must be called before anything else reads from conn
+func handshake(conn PacketConn, t *Target, session uint64) (*packet.Packet, error) {
+	retries := viper.GetInt("hello_retries")
+	timeout := viper.GetDuration("hello_timeout")
+
+	defer conn.SetReadDeadline(time.Time{})
+
+	// sent holds when each attempt was sent, an answer to any of them will do
+	sent := make([]time.Time, 0, retries)
+
+	for attempt := 1; attempt <= retries; attempt++ {
+		sent = append(sent, time.Now())
+		hello := &packet.Packet{
+			PacketType:      packet.PacketType_HELLOPACKET,
+			Serial:          uint64(attempt),
+			ClientID:        t.ClientID,
+			ClientSendTime:  sent[attempt-1].UnixNano(),
+			Session:         session,
+			ProtocolVersion: packet.ProtocolVersion,
+			Features:        clientFeatures(t),
+		}
+
+		err := sendPacket(conn, t.Key, t.Mode, hello)
+		if err != nil {
+			return nil, err
+		}
+
+		ack, received, err := waitAck(conn, t.Key, packet.PacketType_HELLOACKPACKET, session, 1, uint64(attempt), sent[attempt-1].Add(timeout))
+		if err != nil {
+			return nil, err
+		}
+
+		if ack == nil {
+			log.WithFields(log.Fields{
+				"Attempt":       attempt,
+				"Retries":       retries,
+				"RemoteAddress": t.Remote,
+			}).Warn("no answer to hello")
+
+			continue
+		}
+
+		err = checkServer(ack, t, sent[ack.Serial-1], received)
+		if err != nil {
+			return nil, err
+		}
+
+		log.WithFields(log.Fields{
+			"RemoteAddress":   t.Remote,
+			"ProtocolVersion": ack.ProtocolVersion,
+			"Features":        ack.Features,
+			"Required":        ack.RequiredFeatures,
+		}).Info("server said hello")
+
+		return ack, nil
+	}
+
+	return nil, &IncompatibleError{
+		Reason: fmt.Sprintf("no answer to %d hellos. The server may be down, unreachable, rejecting our key, "+
+			"or older than protocol version %d (use --no-hello for old servers, and --legacy-keys for servers from before HKDF)", retries, packet.MinProtocolVersion),
+	}
+}
+
+// waitAck reads from conn until the answer of type t to a serial from first to last arrives or deadline passes, ignoring
+// anything else. Retries can accept the answer to an earlier attempt, which may only arrive once the next has been sent.
+// It returns a nil packet if the deadline passed first. A packet too big for the path is returned as a syscall.EMSGSIZE
+// error straight away, since waiting won't help
+func waitAck(conn PacketConn, key keyring.Key, t packet.PacketType, session, first, last uint64, deadline time.Time) (*packet.Packet, time.Time, error) {
+	err := conn.SetReadDeadline(deadline)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	buff := make([]byte, packet.MaxPacketSize)
+
+	for {
+		n, err := conn.Read(buff)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil, time.Time{}, nil
+		}
+
+		if errors.Is(err, syscall.EMSGSIZE) {
+			return nil, time.Time{}, err
+		}
+
+		if err != nil {
+			// ICMP errors like connection refused show up here, keep waiting in case the server comes up
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Debug("could not read ack")
+
+			if time.Now().After(deadline) {
+				return nil, time.Time{}, nil
+			}
+
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+
+		received := time.Now()
+
+		p := &packet.Packet{}
+		_, err = wrapper.DecodePacket(buff, n, key, p)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("could not decode packet")
+			continue
+		}
+
+		if p.PacketType != t || p.Session != session || p.Serial < first || p.Serial > last {
+			continue
+		}
+
+		return p, received, nil
+	}
+}
+
+// checkServer returns an IncompatibleError explaining why the client can't use the server that sent ack with t.
+// sent & received are when the hello was sent and its ack received, used to check the clocks are close enough
+func checkServer(ack *packet.Packet, t *Target, sent, received time.Time) error {
+	if ack.ProtocolVersion < packet.MinProtocolVersion {
+		return &IncompatibleError{
+			Reason: fmt.Sprintf("server speaks protocol version %d, this client needs at least version %d", ack.ProtocolVersion, packet.MinProtocolVersion),
+		}
+	}
+
+	if t.Mode == wrapper.ModeAEAD && !packet.HasFeature(ack.Features, packet.Feature_FEATURE_ENCRYPTION) {
+		return &IncompatibleError{
+			Reason: "server does not support encryption, upgrade the server or run without --encrypt",
+		}
+	}
+
+	if t.Key.ID != 0 && !packet.HasFeature(ack.Features, packet.Feature_FEATURE_KEY_IDS) {
+		return &IncompatibleError{
+			Reason: "server does not support key IDs, upgrade the server or use a key without a key_id",
+		}
+	}
+
+	if t.sizes != nil && !packet.HasFeature(ack.Features, packet.Feature_FEATURE_PAYLOAD_SIZES) {
+		return &IncompatibleError{
+			Reason: "server does not support padded packets, upgrade the server or run without --size",
+		}
+	}
+
+	if max := t.sizes.Max(); ack.MaxPacketSize != 0 && max > int(ack.MaxPacketSize) {
+		return &IncompatibleError{
+			Reason: fmt.Sprintf("server accepts packets of up to %d bytes, but --size goes up to %d", ack.MaxPacketSize, max),
+		}
+	}
+
+	features := clientFeatures(t)
+	for _, f := range ack.RequiredFeatures {
+		if packet.HasFeature(features, f) {
+			continue
+		}
+
+		reason := fmt.Sprintf("server requires %s, which this client does not support", f)
+		if f == packet.Feature_FEATURE_ENCRYPTION {
+			reason = "server requires encryption, run with --encrypt"
+		}
+
+		return &IncompatibleError{
+			Reason: reason,
+		}
+	}
+
+	if ack.MaxClockSkew > 0 && ack.ServerRecvTime != 0 && ack.ServerSendTime != 0 {
+		offset := (unixNano(ack.ServerRecvTime).Sub(sent) + unixNano(ack.ServerSendTime).Sub(received)) / 2
+		if offset < 0 {
+			offset = -offset
+		}
+
+		if maxSkew := time.Duration(ack.MaxClockSkew); offset > maxSkew {
+			return &IncompatibleError{
+				Reason: fmt.Sprintf("our clock is %v away from the server's, the server rejects packets more than %v away. "+
+					"Sync the clocks or raise the server's --max-skew", offset, maxSkew),
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package client
+
+import (
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stormentt/packetloss/keyring"
+	packet "github.com/stormentt/packetloss/packet"
+	wrapper "github.com/stormentt/packetloss/wrapper"
+)
+
+// slowConn is a PacketConn to a server that answers every packet after delay, as if the round trip took that long
+type slowConn struct {
+	key   keyring.Key
+	delay time.Duration
+
+	// answer returns the server's answer to p
+	answer func(p *packet.Packet) *packet.Packet
+
+	in chan []byte
+
+	mu       sync.Mutex
+	deadline time.Time
+	sent     []uint64
+}
+
+func newSlowConn(key keyring.Key, delay time.Duration, answer func(p *packet.Packet) *packet.Packet) *slowConn {
+	return &slowConn{
+		key:    key,
+		delay:  delay,
+		answer: answer,
+		in:     make(chan []byte, 16),
+	}
+}
+
+func (sc *slowConn) Write(b []byte) (int, error) {
+	p := &packet.Packet{}
+	_, err := wrapper.DecodePacket(b, len(b), sc.key, p)
+	if err != nil {
+		return 0, err
+	}
+
+	sc.mu.Lock()
+	sc.sent = append(sc.sent, p.Serial)
+	sc.mu.Unlock()
+
+	data, err := wrapper.Encode(sc.answer(p), sc.key, wrapper.ModeHMAC)
+	if err != nil {
+		return 0, err
+	}
+
+	time.AfterFunc(sc.delay, func() { sc.in <- data })
+
+	return len(b), nil
+}
+
+func (sc *slowConn) Read(b []byte) (int, error) {
+	sc.mu.Lock()
+	deadline := sc.deadline
+	sc.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case data := <-sc.in:
+		return copy(b, data), nil
+	case <-timer.C:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+func (sc *slowConn) SetReadDeadline(t time.Time) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.deadline = t
+	return nil
+}
+
+func (sc *slowConn) Close() error {
+	return nil
+}
+
+// attempts returns how many packets were sent
+func (sc *slowConn) attempts() int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	return len(sc.sent)
+}
+
+func testTarget(key keyring.Key) *Target {
+	return &Target{
+		Name:     "test",
+		Remote:   &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6666},
+		ClientID: "client",
+		Key:      key,
+		Mode:     wrapper.ModeHMAC,
+	}
+}
+
+// TestHandshakeSlowPath answers every hello after longer than hello_timeout, so each answer arrives while the next
+// attempt is waiting
+func TestHandshakeSlowPath(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	viper.Set("hello_retries", 5)
+	viper.Set("hello_timeout", 40*time.Millisecond)
+
+	key := keyring.NewKey("secret", 0)
+	conn := newSlowConn(key, 60*time.Millisecond, func(p *packet.Packet) *packet.Packet {
+		return &packet.Packet{
+			PacketType:      packet.PacketType_HELLOACKPACKET,
+			Serial:          p.Serial,
+			ClientID:        p.ClientID,
+			Session:         p.Session,
+			ClientSendTime:  p.ClientSendTime,
+			ProtocolVersion: packet.ProtocolVersion,
+		}
+	})
+
+	ack, err := handshake(conn, testTarget(key), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ack.Serial != 1 || conn.attempts() != 2 {
+		t.Errorf("accepted the answer to attempt %d after %d attempts, expected the answer to 1 after 2", ack.Serial, conn.attempts())
+	}
+}
+
+// TestHandshakeIgnoresOtherSessions answers with another session, which can't be accepted however long it waits
+func TestHandshakeIgnoresOtherSessions(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	viper.Set("hello_retries", 2)
+	viper.Set("hello_timeout", 20*time.Millisecond)
+
+	key := keyring.NewKey("secret", 0)
+	conn := newSlowConn(key, time.Millisecond, func(p *packet.Packet) *packet.Packet {
+		return &packet.Packet{
+			PacketType:      packet.PacketType_HELLOACKPACKET,
+			Serial:          p.Serial,
+			Session:         p.Session + 1,
+			ProtocolVersion: packet.ProtocolVersion,
+		}
+	})
+
+	_, err := handshake(conn, testTarget(key), 7)
+	if err == nil {
+		t.Fatal("accepted an answer for another session")
+	}
+}
+
+// TestResetSlowPath acknowledges every reset after longer than hello_timeout, so the first acknowledgement arrives
+// while the second attempt is waiting
+func TestResetSlowPath(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	viper.Set("hello_retries", 5)
+	viper.Set("hello_timeout", 40*time.Millisecond)
+
+	key := keyring.NewKey("secret", 0)
+	conn := newSlowConn(key, 60*time.Millisecond, func(p *packet.Packet) *packet.Packet {
+		return &packet.Packet{
+			PacketType: packet.PacketType_RESETACKPACKET,
+			Serial:     p.Serial,
+			ClientID:   p.ClientID,
+			Session:    p.Session,
+		}
+	})
+
+	err := resetSession(conn, testTarget(key), 7, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if conn.attempts() != 2 {
+		t.Errorf("reset took %d attempts, expected the first acknowledgement to be accepted during the second", conn.attempts())
+	}
+}
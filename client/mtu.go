@@ -0,0 +1,254 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	packet "github.com/stormentt/packetloss/packet"
+	wrapper "github.com/stormentt/packetloss/wrapper"
+)
+
+// MTUResult is the outcome of path MTU discovery
+type MTUResult struct {
+	// Payload is the largest UDP payload that reached the server, PathMTU is the size of the IP packet carrying it
+	Payload int
+	PathMTU int
+
+	// TooBig is the smallest payload that didn't reach the server, 0 if the largest size tried did.
+	// Rejected is set if TooBig was refused with an error, either by the local interface or by an ICMP "fragmentation
+	// needed" from the path. BlackHole is set if it was dropped silently instead, which breaks path MTU discovery for
+	// anything else using the path
+	TooBig    int
+	Rejected  bool
+	BlackHole bool
+
+	// Probes is how many probes were sent
+	Probes int
+}
+
+// prober sends path MTU probes to the server and waits for their answers. Probes are HELLOPACKETs padded to the size
+// being tested, which the server answers with the size they arrived at
+type prober struct {
+	conn    *net.UDPConn
+	target  *Target
+	session uint64
+
+	retries int
+	timeout time.Duration
+
+	// serial carries on from the handshake's serials, so a late answer to the handshake isn't taken for a probe's
+	serial uint64
+	probes int
+}
+
+// DiscoverMTU binary searches for the largest UDP payload that gets from here to t's server, between mtu_min and
+// mtu_max bytes. Probes are sent with the DF bit set where that's supported. Each size is tried probe_retries times,
+// waiting probe_timeout for each answer, before it counts as too big
+func DiscoverMTU(t *Target) (*MTUResult, error) {
+	conn, err := net.DialUDP("udp", nil, t.Remote)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	ipv4 := t.Remote.IP.To4() != nil
+
+	err = setDontFragment(conn, ipv4)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Error": err,
+		}).Warn("could not set the DF bit, probes may be fragmented and the result too high")
+	}
+
+	session, err := newSession()
+	if err != nil {
+		return nil, err
+	}
+
+	hello, err := handshake(conn, t, session)
+	if err != nil {
+		return nil, err
+	}
+
+	if !packet.HasFeature(hello.Features, packet.Feature_FEATURE_PROBES) || hello.ProtocolVersion < 4 {
+		return nil, &IncompatibleError{
+			Reason: "server does not answer path MTU probes, upgrade the server",
+		}
+	}
+
+	min := viper.GetInt("mtu_min")
+	max := viper.GetInt("mtu_max")
+	if hello.MaxPacketSize != 0 && max > int(hello.MaxPacketSize) {
+		max = int(hello.MaxPacketSize)
+	}
+
+	if min < 0 || min > max {
+		return nil, fmt.Errorf("invalid probe sizes, --min %d must be between 0 and --max %d", min, max)
+	}
+
+	pr := &prober{
+		conn:    conn,
+		target:  t,
+		session: session,
+		retries: viper.GetInt("probe_retries"),
+		timeout: viper.GetDuration("probe_timeout"),
+		serial:  uint64(viper.GetInt("hello_retries")),
+	}
+
+	res := &MTUResult{}
+
+	// the smallest probe may be larger than min, since probes can't be smaller than an unpadded packet
+	smallest, ok, _, err := pr.probe(min)
+	res.Probes = pr.probes
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("no probes reached the server, not even %d byte ones", smallest)
+	}
+
+	// sizes up to good reached the server, sizes from bad on didn't
+	good := smallest
+	bad := max + 1
+
+	for good+1 < bad {
+		mid := good + (bad-good)/2
+
+		_, ok, rejected, err := pr.probe(mid)
+		res.Probes = pr.probes
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			good = mid
+		} else {
+			bad = mid
+			res.Rejected = rejected
+		}
+	}
+
+	res.Payload = good
+	res.PathMTU = good + udpHeaderSize(ipv4)
+
+	if bad <= max {
+		res.TooBig = bad
+		res.BlackHole = !res.Rejected
+	}
+
+	return res, nil
+}
+
+// udpHeaderSize returns the size of the IP & UDP headers in front of a UDP payload
+func udpHeaderSize(ipv4 bool) int {
+	if ipv4 {
+		return 20 + 8
+	}
+
+	return 40 + 8
+}
+
+// probe sends probes padded to size until one is answered or every retry has been used.
+// It returns the size the probes actually were, whether one reached the server intact,
+// and whether they were rejected as too big rather than going unanswered.
+// An ICMP "fragmentation needed" for an earlier, larger probe can turn up late and look like it was for this one, so
+// errors left on the socket are cleared before each probe and a probe that is answered isn't rejected, whatever errors
+// came before its answer
+func (pr *prober) probe(size int) (int, bool, bool, error) {
+	actual := size
+	rejected := false
+
+	for attempt := 1; attempt <= pr.retries; attempt++ {
+		pr.serial++
+		pr.probes++
+
+		err := clearSocketError(pr.conn)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Error": err,
+			}).Debug("could not clear socket errors")
+		}
+
+		p := &packet.Packet{
+			PacketType:      packet.PacketType_HELLOPACKET,
+			Serial:          pr.serial,
+			ClientID:        pr.target.ClientID,
+			ClientSendTime:  time.Now().UnixNano(),
+			Session:         pr.session,
+			ProtocolVersion: packet.ProtocolVersion,
+			Features:        clientFeatures(pr.target),
+		}
+
+		data, err := wrapper.EncodePadded(p, pr.target.Key, pr.target.Mode, size)
+		if err != nil {
+			return actual, false, false, err
+		}
+
+		actual = len(data)
+		sent := time.Now()
+
+		_, err = pr.conn.Write(data)
+		if errors.Is(err, syscall.EMSGSIZE) {
+			// refused by the local interface, which is always about this packet
+			pr.logProbe(actual, "rejected")
+			return actual, false, true, nil
+		}
+
+		if err != nil {
+			return actual, false, false, err
+		}
+
+		var ack *packet.Packet
+		for {
+			ack, _, err = waitAck(pr.conn, pr.target.Key, packet.PacketType_HELLOACKPACKET, pr.session, pr.serial, pr.serial, sent.Add(pr.timeout))
+			if !errors.Is(err, syscall.EMSGSIZE) {
+				break
+			}
+
+			// only counts if the probe goes unanswered, keep waiting in case it was about an earlier probe
+			rejected = true
+		}
+
+		if err != nil {
+			return actual, false, false, err
+		}
+
+		if ack == nil {
+			continue
+		}
+
+		if int(ack.ReceivedSize) != actual {
+			log.WithFields(log.Fields{
+				"Size":         actual,
+				"ReceivedSize": ack.ReceivedSize,
+			}).Warn("server received a probe at a different size than we sent")
+
+			continue
+		}
+
+		pr.logProbe(actual, "ok")
+		return actual, true, false, nil
+	}
+
+	if rejected {
+		pr.logProbe(actual, "rejected")
+		return actual, false, true, nil
+	}
+
+	pr.logProbe(actual, "no answer")
+	return actual, false, false, nil
+}
+
+func (pr *prober) logProbe(size int, result string) {
+	log.WithFields(log.Fields{
+		"Size":   size,
+		"Result": result,
+	}).Info("probe")
+}
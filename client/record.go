@@ -1,11 +1,16 @@
 package client
 
 import (
+	"sort"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/stormentt/packetloss/netstats"
 )
 
+// lateAckHorizon is how long after it was sent a lost packet's ack is still counted as late, rather than ignored
+const lateAckHorizon = time.Minute
+
 type PacketRecord struct {
 	Serial uint64
 
@@ -14,6 +19,18 @@ type PacketRecord struct {
 
 	Acked     bool
 	AckedTime time.Time
+
+	// ServerRecvTime & ServerSendTime are the server's timestamps from the acknowledgement
+	// they are zero if the server did not report them
+	ServerRecvTime time.Time
+	ServerSendTime time.Time
+
+	// ServerReceived is how many packets the server had received when it sent the ack, 0 if not reported
+	ServerReceived uint64
+
+	// Size is how many bytes the packet was on the wire, Bucket is the size its loss is reported under, 0 if unpadded
+	Size   int
+	Bucket int
 }
 
 // ClientRecord is a collection of stats for clients
@@ -22,17 +39,40 @@ type ClientRecord struct {
 
 	LastSent uint64
 	LastAck  uint64
+
+	// AckTimeout is how long to wait for an ack before a packet is counted as lost
+	// packets still waiting for their ack are carried over by Reset into the next interval
+	AckTimeout time.Duration
+
+	// lost holds the packets counted as lost by earlier intervals, so an ack that arrives for one later on can be
+	// told apart from a duplicate. lateAcks are the packets from lost that were acked during this interval
+	lost     map[uint64]*PacketRecord
+	lateAcks []*PacketRecord
+
+	// Clock & Jitter are not cleared by Reset, they are running estimates over the life of the client
+	Clock  *ClockEstimator
+	Jitter netstats.Jitter
+
+	// anchorSerial & anchorReceived are the serial and server received count of the newest ack that
+	// reported a count. Loss after the anchor can be split into upstream and downstream loss once a newer ack arrives
+	anchorSerial   uint64
+	anchorReceived uint64
 }
 
 // NewClientRecord creates a new ClientRecord object
-func NewClientRecord() *ClientRecord {
+// ackTimeout is how long to wait for an ack before a packet is counted as lost
+func NewClientRecord(ackTimeout time.Duration) *ClientRecord {
 	return &ClientRecord{
-		Packets: make(map[uint64]*PacketRecord),
+		Packets:    make(map[uint64]*PacketRecord),
+		AckTimeout: ackTimeout,
+		lost:       make(map[uint64]*PacketRecord),
+		Clock:      NewClockEstimator(),
 	}
 }
 
 // Send records that the serial number was sent
-func (cr *ClientRecord) Send(serial uint64, ts time.Time) {
+// size is how many bytes it was on the wire, bucket is the size its loss is reported under
+func (cr *ClientRecord) Send(serial uint64, ts time.Time, size, bucket int) {
 	log.WithFields(log.Fields{
 		"Serial":    serial,
 		"Timestamp": ts,
@@ -44,36 +84,105 @@ func (cr *ClientRecord) Send(serial uint64, ts time.Time) {
 		SentTime:  ts,
 		Acked:     false,
 		AckedTime: time.Time{},
+		Size:      size,
+		Bucket:    bucket,
 	}
 
 	cr.LastSent = serial
 }
 
 // Ack records that the serial number was acknowledged
-func (cr *ClientRecord) Ack(serial uint64, ts time.Time) {
+// serverRecv & serverSend are the timestamps the server reported in its acknowledgement
+// serverReceived is how many packets the server reported receiving, receivedSize is how large it says the packet was
+func (cr *ClientRecord) Ack(serial uint64, ts, serverRecv, serverSend time.Time, serverReceived uint64, receivedSize uint32) {
 	log.WithFields(log.Fields{
 		"Serial":    serial,
 		"Timestamp": ts,
 	}).Debug("ack")
 
 	if pr, ok := cr.Packets[serial]; ok {
+		if pr.Acked {
+			log.WithFields(log.Fields{
+				"Serial": serial,
+			}).Warn("received a duplicate ack")
+
+			return
+		}
+
 		pr.Acked = true
 		pr.AckedTime = ts
+		pr.ServerRecvTime = serverRecv
+		pr.ServerSendTime = serverSend
+		pr.ServerReceived = serverReceived
+
+		if pr.Sent && receivedSize != 0 && int(receivedSize) != pr.Size {
+			log.WithFields(log.Fields{
+				"Serial":       serial,
+				"Size":         pr.Size,
+				"ReceivedSize": receivedSize,
+			}).Warn("server received a different size than we sent")
+		}
+
+		if pr.Sent {
+			cr.Jitter.Add(ts.Sub(pr.SentTime))
+		}
+
+		if pr.Sent && !serverRecv.IsZero() && !serverSend.IsZero() {
+			cr.Clock.Add(pr.SentTime, serverRecv, serverSend, ts)
+		}
+	} else if pr, ok := cr.lost[serial]; ok {
+		// the packet's interval closed without it, so it was counted as lost there
+		log.WithFields(log.Fields{
+			"Serial": serial,
+		}).Debug("late ack")
+
+		delete(cr.lost, serial)
+		cr.lateAcks = append(cr.lateAcks, pr)
+
+		return
+	} else if serial <= cr.LastSent {
+		log.WithFields(log.Fields{
+			"Serial": serial,
+		}).Debug("ack for a packet that was already acked or is too old to be recorded")
+
+		return
 	} else {
+		log.WithFields(log.Fields{
+			"Serial":   serial,
+			"LastSent": cr.LastSent,
+		}).Warn("received an ack for a packet we haven't sent yet")
+
 		cr.Packets[serial] = &PacketRecord{
-			Serial:    serial,
-			Sent:      false,
-			SentTime:  time.Time{},
-			Acked:     true,
-			AckedTime: ts,
+			Serial:         serial,
+			Sent:           false,
+			SentTime:       time.Time{},
+			Acked:          true,
+			AckedTime:      ts,
+			ServerRecvTime: serverRecv,
+			ServerSendTime: serverSend,
+			ServerReceived: serverReceived,
 		}
 	}
 
-	cr.LastAck = serial
+	if serial > cr.LastAck {
+		cr.LastAck = serial
+	}
+}
+
+// pending returns true if pr is still waiting for its ack at time now
+func (cr *ClientRecord) pending(pr *PacketRecord, now time.Time) bool {
+	return pr.Sent && !pr.Acked && now.Sub(pr.SentTime) < cr.AckTimeout
+}
+
+// late returns true if pr was acked after the ack timeout
+func (cr *ClientRecord) late(pr *PacketRecord) bool {
+	return pr.Sent && pr.Acked && pr.AckedTime.Sub(pr.SentTime) > cr.AckTimeout
 }
 
-// Remediate returns sums for various stats
-func (cr *ClientRecord) Remediate() *ClientStats {
+// Remediate returns sums for various stats as of now
+// packets still waiting for their ack are left out, they are counted once they are acked or time out.
+// it also advances the anchor used to split loss into upstream and downstream loss
+func (cr *ClientRecord) Remediate(now time.Time) *ClientStats {
 	var Total uint64
 
 	var TotalSent uint64
@@ -82,10 +191,13 @@ func (cr *ClientRecord) Remediate() *ClientStats {
 	var SentAndAcked uint64
 	var SentNotAcked uint64
 	var AckedNotSent uint64
+	var Late uint64
 
-	var TotalRTT time.Duration
-	var MinRTT time.Duration
-	var MaxRTT time.Duration
+	RTT := netstats.NewHistogram()
+	var Forward durationStats
+	var Reverse durationStats
+
+	Sizes := make(map[int]*SizeStats)
 
 	for _, pr := range cr.Packets {
 		if !pr.Sent && !pr.Acked {
@@ -97,6 +209,10 @@ func (cr *ClientRecord) Remediate() *ClientStats {
 			continue
 		}
 
+		if cr.pending(pr, now) {
+			continue
+		}
+
 		Total++
 
 		if pr.Sent {
@@ -107,18 +223,18 @@ func (cr *ClientRecord) Remediate() *ClientStats {
 			TotalAcked++
 		}
 
-		if pr.Sent && pr.Acked {
+		if cr.late(pr) {
+			Late++
+		} else if pr.Sent && pr.Acked {
 			SentAndAcked++
 
-			RTT := pr.AckedTime.Sub(pr.SentTime)
-			TotalRTT += RTT
+			RTT.Record(pr.AckedTime.Sub(pr.SentTime))
 
-			if RTT < MinRTT || MinRTT == time.Duration(0) {
-				MinRTT = RTT
-			}
+			if !pr.ServerRecvTime.IsZero() && !pr.ServerSendTime.IsZero() {
+				offset := cr.Clock.Offset(pr.SentTime)
 
-			if RTT > MaxRTT || MaxRTT == time.Duration(0) {
-				MaxRTT = RTT
+				Forward.add(pr.ServerRecvTime.Sub(pr.SentTime) - offset)
+				Reverse.add(pr.AckedTime.Sub(pr.ServerSendTime) + offset)
 			}
 		}
 
@@ -129,58 +245,183 @@ func (cr *ClientRecord) Remediate() *ClientStats {
 		if !pr.Sent && pr.Acked {
 			AckedNotSent++
 		}
+
+		if pr.Sent && pr.Bucket != 0 {
+			bucket, ok := Sizes[pr.Bucket]
+			if !ok {
+				bucket = &SizeStats{Size: pr.Bucket}
+				Sizes[pr.Bucket] = bucket
+			}
+
+			bucket.Sent++
+			if cr.late(pr) {
+				bucket.Late++
+			} else if pr.Acked {
+				bucket.Acked++
+			} else {
+				bucket.Lost++
+			}
+		}
 	}
 
-	var AvgRTT time.Duration
-	if SentAndAcked != 0 {
-		AvgRTT = TotalRTT / time.Duration(SentAndAcked)
+	// late acks for packets from earlier intervals correct those intervals' loss when they're merged
+	LateSizes := make(map[int]uint64)
+	for _, pr := range cr.lateAcks {
+		if pr.Bucket != 0 {
+			LateSizes[pr.Bucket]++
+		}
 	}
 
-	SAAPercent := float64(SentAndAcked) / float64(Total) * 100.0
-	SNAPercent := float64(SentNotAcked) / float64(Total) * 100.0
-	ANSPercent := float64(AckedNotSent) / float64(Total) * 100.0
+	UpstreamLost, DownstreamLost, splitSent, splitReceived := cr.splitLoss()
 
-	return &ClientStats{
-		Total,
-		TotalSent,
-		TotalAcked,
+	// delay variation & loss runs are only meaningful between consecutive packets, so walk them in serial order
+	var IPDV netstats.IPDV
+	var Runs netstats.LossRuns
+	var lastSerial uint64
+	for _, serial := range cr.sortedSerials() {
+		pr := cr.Packets[serial]
+		if cr.pending(pr, now) {
+			continue
+		}
+
+		// late acks count as lost, an ack that arrives after the timeout is no use to a real time application
+		timely := pr.Sent && pr.Acked && !cr.late(pr)
+
+		if timely {
+			Runs.Received(pr.SentTime)
+		} else if pr.Sent {
+			Runs.Lost(1)
+		}
 
-		SentAndAcked,
-		SAAPercent,
+		if serial != lastSerial+1 || !timely {
+			IPDV.Break()
+		}
 
-		SentNotAcked,
-		SNAPercent,
+		if timely {
+			IPDV.Add(pr.AckedTime.Sub(pr.SentTime))
+		}
 
-		AckedNotSent,
-		ANSPercent,
+		lastSerial = serial
+	}
 
-		AvgRTT,
-		MinRTT,
-		MaxRTT,
+	stats := &ClientStats{
+		Total:      Total,
+		TotalSent:  TotalSent,
+		TotalAcked: TotalAcked,
+
+		SentAndAcked: SentAndAcked,
+		SentNotAcked: SentNotAcked,
+		AckedNotSent: AckedNotSent,
+		Late:         Late,
+		LateAcks:     uint64(len(cr.lateAcks)),
+
+		UpstreamLost:   UpstreamLost,
+		DownstreamLost: DownstreamLost,
+
+		RTTHistogram: RTT,
+
+		ClockOffset: cr.Clock.Offset(now),
+		ClockDrift:  cr.Clock.Drift(),
+		Jitter:      cr.Jitter.Value(),
+
+		forward:       Forward,
+		reverse:       Reverse,
+		ipdv:          IPDV,
+		runs:          Runs,
+		splitSent:     splitSent,
+		splitReceived: splitReceived,
+		sizes:         Sizes,
+		lateSizes:     LateSizes,
 	}
+
+	stats.calculate()
+
+	return stats
 }
 
-// Reset resets the Sent and Ack counters
-func (cr *ClientRecord) Reset() {
-	cr.Packets = make(map[uint64]*PacketRecord)
+// sortedSerials returns the serial numbers of every recorded packet in ascending order
+func (cr *ClientRecord) sortedSerials() []uint64 {
+	serials := make([]uint64, 0, len(cr.Packets))
+	for serial := range cr.Packets {
+		serials = append(serials, serial)
+	}
+
+	sort.Slice(serials, func(i, j int) bool {
+		return serials[i] < serials[j]
+	})
+
+	return serials
 }
 
-type ClientStats struct {
-	Total uint64
+// splitLoss uses the server's received counts to work out how many packets between the anchor and the newest
+// counted ack were lost on the way to the server and how many acks were lost on the way back.
+// It returns the upstream & downstream losses, and how many packets were sent and received in that range
+func (cr *ClientRecord) splitLoss() (uint64, uint64, uint64, uint64) {
+	var newest *PacketRecord
+	for _, pr := range cr.Packets {
+		if pr.Acked && pr.ServerReceived != 0 && pr.Serial > cr.anchorSerial {
+			if newest == nil || pr.Serial > newest.Serial {
+				newest = pr
+			}
+		}
+	}
+
+	if newest == nil {
+		return 0, 0, 0, 0
+	}
+
+	if newest.ServerReceived < cr.anchorReceived {
+		// the server's counts were reset, start again from this ack
+		cr.anchorSerial = newest.Serial
+		cr.anchorReceived = newest.ServerReceived
+
+		return 0, 0, 0, 0
+	}
+
+	var acked uint64
+	for _, pr := range cr.Packets {
+		if pr.Acked && pr.Serial > cr.anchorSerial && pr.Serial <= newest.Serial {
+			acked++
+		}
+	}
 
-	TotalSent  uint64
-	TotalAcked uint64
+	sent := newest.Serial - cr.anchorSerial
+	received := newest.ServerReceived - cr.anchorReceived
 
-	SentAndAcked uint64
-	SAAPercent   float64
+	cr.anchorSerial = newest.Serial
+	cr.anchorReceived = newest.ServerReceived
 
-	SentNotAcked uint64
-	SNAPercent   float64
+	var upstream, downstream uint64
+	if sent > received {
+		upstream = sent - received
+	}
 
-	AckedNotSent uint64
-	ANSPercent   float64
+	if received > acked {
+		downstream = received - acked
+	}
+
+	return upstream, downstream, sent, received
+}
+
+// Reset resets the Sent and Ack counters
+// packets still waiting for their ack at time now are kept for the next interval, and lost packets are remembered for
+// lateAckHorizon in case their ack turns up late
+func (cr *ClientRecord) Reset(now time.Time) {
+	packets := make(map[uint64]*PacketRecord)
+	for serial, pr := range cr.Packets {
+		if cr.pending(pr, now) {
+			packets[serial] = pr
+		} else if pr.Sent && !pr.Acked {
+			cr.lost[serial] = pr
+		}
+	}
+
+	for serial, pr := range cr.lost {
+		if now.Sub(pr.SentTime) > lateAckHorizon {
+			delete(cr.lost, serial)
+		}
+	}
 
-	AvgRTT time.Duration
-	MinRTT time.Duration
-	MaxRTT time.Duration
+	cr.Packets = packets
+	cr.lateAcks = cr.lateAcks[:0]
 }
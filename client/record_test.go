@@ -0,0 +1,68 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLateAckCountedOnce(t *testing.T) {
+	start := time.Now()
+	cr := NewClientRecord(time.Second)
+	summary := NewClientStats()
+
+	cr.Send(1, start, 100, 0)
+	cr.Send(2, start, 100, 0)
+	cr.Ack(2, start.Add(10*time.Millisecond), time.Time{}, time.Time{}, 0, 0)
+
+	// the first interval closes with packet 1 lost
+	now := start.Add(2 * time.Second)
+	first := cr.Remediate(now)
+	cr.Reset(now)
+	summary.Merge(first)
+
+	if first.Total != 2 || first.SentNotAcked != 1 || first.SentAndAcked != 1 {
+		t.Fatalf("first interval: got total %d, lost %d, acked %d, want 2, 1, 1", first.Total, first.SentNotAcked, first.SentAndAcked)
+	}
+
+	// packet 1's ack turns up during the next interval
+	cr.Ack(1, now.Add(time.Second), time.Time{}, time.Time{}, 0, 0)
+
+	now = now.Add(2 * time.Second)
+	second := cr.Remediate(now)
+	cr.Reset(now)
+
+	if second.Total != 0 || second.LateAcks != 1 || second.AckedNotSent != 0 {
+		t.Fatalf("second interval: got total %d, late acks %d, acked not sent %d, want 0, 1, 0", second.Total, second.LateAcks, second.AckedNotSent)
+	}
+
+	if second.SNAPercent != 0 {
+		t.Errorf("second interval: got loss %v%%, want 0%%", second.SNAPercent)
+	}
+
+	summary.Merge(second)
+
+	if summary.Total != 2 || summary.SentNotAcked != 0 || summary.Late != 1 || summary.SentAndAcked != 1 {
+		t.Errorf("summary: got total %d, lost %d, late %d, acked %d, want 2, 0, 1, 1", summary.Total, summary.SentNotAcked, summary.Late, summary.SentAndAcked)
+	}
+
+	// a second ack for the same packet isn't counted again
+	cr.Ack(1, now, time.Time{}, time.Time{}, 0, 0)
+
+	third := cr.Remediate(now)
+	if third.LateAcks != 0 || third.AckedNotSent != 0 {
+		t.Errorf("duplicate late ack: got late acks %d, acked not sent %d, want 0, 0", third.LateAcks, third.AckedNotSent)
+	}
+}
+
+func TestAckedNotSent(t *testing.T) {
+	start := time.Now()
+	cr := NewClientRecord(time.Second)
+
+	cr.Send(1, start, 100, 0)
+	cr.Ack(5, start, time.Time{}, time.Time{}, 0, 0)
+
+	stats := cr.Remediate(start.Add(2 * time.Second))
+	if stats.AckedNotSent != 1 {
+		t.Errorf("got acked not sent %d, want 1", stats.AckedNotSent)
+	}
+}
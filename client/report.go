@@ -0,0 +1,83 @@
+package client
+
+import (
+	"github.com/stormentt/packetloss/report"
+)
+
+// Report converts the stats into a report for clientID's packets to remote
+func (cs *ClientStats) Report(clientID, remote string, summary bool) *report.Report {
+	r := report.New(report.RoleClient, clientID)
+	r.Remote = remote
+	r.Summary = summary
+
+	r.Client = &report.ClientReport{
+		Total:        cs.Total,
+		Sent:         cs.TotalSent,
+		Acked:        cs.TotalAcked,
+		SentAndAcked: cs.SentAndAcked,
+		SentNotAcked: cs.SentNotAcked,
+		AckedNotSent: cs.AckedNotSent,
+		Late:         cs.Late,
+		LateAcks:     cs.LateAcks,
+
+		LossPercent: cs.SNAPercent,
+		LatePercent: cs.LatePercent,
+
+		Upstream: report.Direction{
+			Lost:    cs.UpstreamLost,
+			Percent: cs.UpstreamPercent,
+		},
+		Downstream: report.Direction{
+			Lost:    cs.DownstreamLost,
+			Percent: cs.DownstreamPercent,
+		},
+
+		RTT: report.Latency{
+			Avg:    int64(cs.AvgRTT),
+			Min:    int64(cs.MinRTT),
+			Max:    int64(cs.MaxRTT),
+			StdDev: int64(cs.StdDevRTT),
+			P50:    int64(cs.P50RTT),
+			P90:    int64(cs.P90RTT),
+			P95:    int64(cs.P95RTT),
+			P99:    int64(cs.P99RTT),
+			P999:   int64(cs.P999RTT),
+
+			Histogram: cs.RTTHistogram,
+		},
+		Forward: report.Delay{
+			Avg: int64(cs.AvgForward),
+			Min: int64(cs.MinForward),
+			Max: int64(cs.MaxForward),
+		},
+		Reverse: report.Delay{
+			Avg: int64(cs.AvgReverse),
+			Min: int64(cs.MinReverse),
+			Max: int64(cs.MaxReverse),
+		},
+
+		ClockOffset: int64(cs.ClockOffset),
+		ClockDrift:  cs.ClockDrift,
+
+		Variation: report.Variation{
+			Jitter:      int64(cs.Jitter),
+			IPDVMin:     int64(cs.IPDVMin),
+			IPDVMax:     int64(cs.IPDVMax),
+			IPDVMeanAbs: int64(cs.IPDVMeanAbs),
+		},
+		LossRuns: report.NewLossRuns(&cs.runs),
+	}
+
+	for _, bucket := range cs.Sizes {
+		r.Client.Sizes = append(r.Client.Sizes, report.SizeLoss{
+			Size:        bucket.Size,
+			Sent:        bucket.Sent,
+			Acked:       bucket.Acked,
+			Lost:        bucket.Lost,
+			Late:        bucket.Late,
+			LossPercent: bucket.LossPercent,
+		})
+	}
+
+	return r
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	packet "github.com/stormentt/packetloss/packet"
+)
+
+// resetSession tells t's server to start a new session for t's ClientID, resetting its stats.
+// If reliable is set the RESETPACKET is resent every hello_timeout until the server acknowledges any of them, up to
+// hello_retries times. Servers older than packet.ResetAckVersion never acknowledge it, so for them it is only sent once.
+// It must be called before anything else reads from conn
+func resetSession(conn PacketConn, t *Target, session uint64, reliable bool) error {
+	retries := viper.GetInt("hello_retries")
+	timeout := viper.GetDuration("hello_timeout")
+
+	defer conn.SetReadDeadline(time.Time{})
+
+	for attempt := 1; attempt <= retries; attempt++ {
+		sent := time.Now()
+		resetP := &packet.Packet{
+			PacketType:     packet.PacketType_RESETPACKET,
+			Serial:         uint64(attempt),
+			ClientID:       t.ClientID,
+			ClientSendTime: sent.UnixNano(),
+			Session:        session,
+		}
+
+		err := sendPacket(conn, t.Key, t.Mode, resetP)
+		if err != nil {
+			return err
+		}
+
+		if !reliable {
+			return nil
+		}
+
+		ack, _, err := waitAck(conn, t.Key, packet.PacketType_RESETACKPACKET, session, 1, uint64(attempt), sent.Add(timeout))
+		if err != nil {
+			return err
+		}
+
+		if ack != nil {
+			log.WithFields(log.Fields{
+				"Attempt": attempt,
+			}).Debug("server acknowledged reset")
+
+			return nil
+		}
+
+		log.WithFields(log.Fields{
+			"Attempt":       attempt,
+			"Retries":       retries,
+			"RemoteAddress": t.Remote,
+		}).Warn("no answer to reset")
+	}
+
+	return fmt.Errorf("server did not acknowledge our reset after %d attempts", retries)
+}
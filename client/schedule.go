@@ -0,0 +1,164 @@
+package client
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stormentt/packetloss/report"
+)
+
+const (
+	scheduleFixed   = "fixed"
+	schedulePoisson = "poisson"
+	scheduleBurst   = "burst"
+	scheduleRamp    = "ramp"
+)
+
+// maxScheduleLag is how far sending can fall behind its schedule before the schedule is restarted from now,
+// instead of catching up with a flood of packets
+const maxScheduleLag = time.Second
+
+// schedule decides when the client sends each packet: every interval, at Poisson distributed times averaging interval
+// apart, in bursts of back-to-back packets every interval, or at a rate ramping from one every interval to one every
+// rampTo over rampTime
+type schedule struct {
+	kind     string
+	interval time.Duration
+
+	burst int
+
+	rampTo   time.Duration
+	rampTime time.Duration
+
+	// sent is how many packets have been scheduled, elapsed is the time from the first packet by the schedule
+	sent    int
+	elapsed time.Duration
+}
+
+// parseSchedule parses a schedule spec: "fixed" (or "") for a fixed interval, "poisson" for exponentially distributed
+// gaps (RFC 2330), "burst:10" for bursts of 10 packets, or "ramp:1ms/60s" to speed up to one packet every 1ms over 60s.
+// interval is the packet_time the spec is relative to
+func parseSchedule(spec string, interval time.Duration) (*schedule, error) {
+	kind, args, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	if kind == "" {
+		kind = scheduleFixed
+	}
+
+	s := &schedule{
+		kind:     kind,
+		interval: interval,
+	}
+
+	switch kind {
+	case scheduleFixed, schedulePoisson:
+		if args != "" {
+			return nil, fmt.Errorf("schedule %q takes no arguments", kind)
+		}
+	case scheduleBurst:
+		burst, err := strconv.Atoi(args)
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("invalid burst size in schedule %q, expected burst:<packets>", spec)
+		}
+
+		s.burst = burst
+	case scheduleRamp:
+		to, over, ok := strings.Cut(args, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid schedule %q, expected ramp:<packet time>/<ramp time>", spec)
+		}
+
+		var err error
+		s.rampTo, err = time.ParseDuration(to)
+		if err != nil || s.rampTo <= 0 {
+			return nil, fmt.Errorf("invalid packet time to ramp to in schedule %q", spec)
+		}
+
+		s.rampTime, err = time.ParseDuration(over)
+		if err != nil || s.rampTime <= 0 {
+			return nil, fmt.Errorf("invalid ramp time in schedule %q", spec)
+		}
+
+		if interval <= 0 {
+			return nil, fmt.Errorf("schedule %q needs a packet time to ramp from", spec)
+		}
+	default:
+		return nil, fmt.Errorf("unknown schedule %q, expected fixed, poisson, burst:<packets>, or ramp:<packet time>/<ramp time>", kind)
+	}
+
+	return s, nil
+}
+
+// Next returns how long after the previous packet the next one is due. The first packet is due one gap after starting
+func (s *schedule) Next() time.Duration {
+	var gap time.Duration
+
+	switch s.kind {
+	case schedulePoisson:
+		gap = time.Duration(rand.ExpFloat64() * float64(s.interval))
+	case scheduleBurst:
+		// bursts start every interval, the rest of a burst follows its first packet straight away
+		if s.sent%s.burst == 0 {
+			gap = s.interval
+		}
+	case scheduleRamp:
+		progress := float64(s.elapsed) / float64(s.rampTime)
+		if progress > 1 {
+			progress = 1
+		}
+
+		from := 1 / s.interval.Seconds()
+		to := 1 / s.rampTo.Seconds()
+		rate := from + (to-from)*progress
+
+		gap = time.Duration(float64(time.Second) / rate)
+	default:
+		gap = s.interval
+	}
+
+	s.sent++
+	s.elapsed += gap
+
+	return gap
+}
+
+// after returns when the packet after one due at due is due, given it is now. If sending has fallen more than
+// maxScheduleLag behind the schedule, it is restarted from now and behind is how far behind it was
+func (s *schedule) after(due, now time.Time) (next time.Time, behind time.Duration) {
+	next = due.Add(s.Next())
+
+	if behind = now.Sub(next); behind > maxScheduleLag && !s.unlimited() {
+		return now, behind
+	}
+
+	return next, 0
+}
+
+// unlimited returns true if packets are sent as fast as they can be, because there's no time between them
+func (s *schedule) unlimited() bool {
+	return s.interval == 0 && s.kind != scheduleRamp
+}
+
+// Report returns the schedule in its report form
+func (s *schedule) Report() *report.Schedule {
+	return &report.Schedule{
+		Type:      s.kind,
+		Interval:  int64(s.interval),
+		BurstSize: s.burst,
+		RampTo:    int64(s.rampTo),
+		RampTime:  int64(s.rampTime),
+	}
+}
+
+func (s *schedule) String() string {
+	switch s.kind {
+	case scheduleBurst:
+		return fmt.Sprintf("%s:%d every %v", s.kind, s.burst, s.interval)
+	case scheduleRamp:
+		return fmt.Sprintf("%s:%v to %v over %v", s.kind, s.interval, s.rampTo, s.rampTime)
+	default:
+		return fmt.Sprintf("%s every %v", s.kind, s.interval)
+	}
+}
@@ -0,0 +1,191 @@
+package client
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	cases := []struct {
+		spec     string
+		interval time.Duration
+
+		expected string
+	}{
+		{"", 10 * time.Millisecond, "fixed every 10ms"},
+		{"fixed", 10 * time.Millisecond, "fixed every 10ms"},
+		{" poisson ", 10 * time.Millisecond, "poisson every 10ms"},
+		{"burst:5", 10 * time.Millisecond, "burst:5 every 10ms"},
+		{"ramp:1ms/60s", 10 * time.Millisecond, "ramp:10ms to 1ms over 1m0s"},
+	}
+
+	for _, tc := range cases {
+		s, err := parseSchedule(tc.spec, tc.interval)
+		if err != nil {
+			t.Errorf("%q: %v", tc.spec, err)
+			continue
+		}
+
+		if s.String() != tc.expected {
+			t.Errorf("%q parsed as %q, expected %q", tc.spec, s, tc.expected)
+		}
+	}
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	cases := []struct {
+		spec     string
+		interval time.Duration
+	}{
+		{"fixed:1", time.Millisecond},
+		{"poisson:3", time.Millisecond},
+		{"burst", time.Millisecond},
+		{"burst:0", time.Millisecond},
+		{"burst:x", time.Millisecond},
+		{"ramp:1ms", time.Millisecond},
+		{"ramp:x/1s", time.Millisecond},
+		{"ramp:-1ms/1s", time.Millisecond},
+		{"ramp:1ms/0s", time.Millisecond},
+		{"ramp:1ms/1s", 0},
+		{"zigzag", time.Millisecond},
+	}
+
+	for _, tc := range cases {
+		_, err := parseSchedule(tc.spec, tc.interval)
+		if err == nil {
+			t.Errorf("%q with packet time %v parsed", tc.spec, tc.interval)
+		}
+	}
+}
+
+func gaps(s *schedule, n int) []time.Duration {
+	gaps := make([]time.Duration, n)
+	for i := range gaps {
+		gaps[i] = s.Next()
+	}
+
+	return gaps
+}
+
+func TestBurstSchedule(t *testing.T) {
+	s, err := parseSchedule("burst:3", 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []time.Duration{10 * time.Millisecond, 0, 0, 10 * time.Millisecond, 0, 0, 10 * time.Millisecond}
+	for i, gap := range gaps(s, len(expected)) {
+		if gap != expected[i] {
+			t.Errorf("gap %d is %v, expected %v", i, gap, expected[i])
+		}
+	}
+}
+
+// TestRampSchedule checks the rate ramps from one packet every packet time to one every ramp to, then stays there
+func TestRampSchedule(t *testing.T) {
+	s, err := parseSchedule("ramp:1ms/1s", 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := s.Next()
+	if first != 10*time.Millisecond {
+		t.Errorf("first gap %v, expected 10ms", first)
+	}
+
+	last := first
+	for s.elapsed <= s.rampTime {
+		gap := s.Next()
+		if gap > last {
+			t.Fatalf("gap grew from %v to %v", last, gap)
+		}
+
+		last = gap
+	}
+
+	for i, gap := range gaps(s, 10) {
+		if gap != time.Millisecond {
+			t.Errorf("gap %d after the ramp is %v, expected 1ms", i, gap)
+		}
+	}
+}
+
+// TestPoissonSchedule checks Poisson gaps are exponentially distributed with the packet time as their mean,
+// so their standard deviation is the mean too
+func TestPoissonSchedule(t *testing.T) {
+	interval := 10 * time.Millisecond
+
+	s, err := parseSchedule("poisson", interval)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 100000
+
+	var sum, sumSquares float64
+	for _, gap := range gaps(s, n) {
+		if gap < 0 {
+			t.Fatalf("negative gap %v", gap)
+		}
+
+		sum += float64(gap)
+		sumSquares += float64(gap) * float64(gap)
+	}
+
+	mean := sum / n
+	stdDev := math.Sqrt(sumSquares/n - mean*mean)
+
+	if math.Abs(mean-float64(interval)) > 0.02*float64(interval) {
+		t.Errorf("mean gap %v, expected %v", time.Duration(mean), interval)
+	}
+
+	if math.Abs(stdDev-float64(interval)) > 0.05*float64(interval) {
+		t.Errorf("gap standard deviation %v, expected %v", time.Duration(stdDev), interval)
+	}
+}
+
+// TestScheduleLag checks a client that stalls only catches up on maxScheduleLag of packets, then carries on from now
+func TestScheduleLag(t *testing.T) {
+	interval := time.Millisecond
+
+	s, err := parseSchedule("fixed", interval)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(1000, 0)
+	now := start.Add(10 * time.Second)
+
+	// the packet after one due 10s ago is too far behind to send, the schedule restarts from now
+	due, behind := s.after(start, now)
+	if due != now || behind != 10*time.Second-interval {
+		t.Fatalf("next packet due %v & %v behind, expected now & %v behind", due.Sub(start), behind, 10*time.Second-interval)
+	}
+
+	// a packet a second behind is within the lag, so the packets after it are sent until the schedule catches up
+	var caughtUp int
+	for due = start.Add(9 * time.Second); ; caughtUp++ {
+		due, behind = s.after(due, now)
+		if behind > 0 {
+			t.Fatalf("skipped ahead %v behind, within %v", behind, maxScheduleLag)
+		}
+
+		if due.After(now) {
+			break
+		}
+	}
+
+	if expected := int(maxScheduleLag / interval); caughtUp != expected {
+		t.Errorf("caught up %d packets, expected %d", caughtUp, expected)
+	}
+
+	unlimited, err := parseSchedule("fixed", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, behind := unlimited.after(start, now); behind != 0 {
+		t.Errorf("unlimited schedule skipped ahead %v behind", behind)
+	}
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	packet "github.com/stormentt/packetloss/packet"
+)
+
+// rangeBuckets is how many equal width buckets loss is reported in for a range of sizes
+const rangeBuckets = 8
+
+// sizes picks the size of each packet the client sends: a fixed size, a list of sizes to rotate through,
+// or a random size in a range. A nil sizes sends unpadded packets
+type sizes struct {
+	list []int
+	next int
+
+	// min & max are only set for ranges
+	min int
+	max int
+}
+
+// parseSizes parses a size spec: "1400" for a fixed size, "64,512,1400" to rotate through a list, or "64-1400" for a
+// random size in a range. An empty spec returns nil
+func parseSizes(spec string) (*sizes, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+
+	if lo, hi, ok := strings.Cut(spec, "-"); ok {
+		min, err := parseSize(lo)
+		if err != nil {
+			return nil, err
+		}
+
+		max, err := parseSize(hi)
+		if err != nil {
+			return nil, err
+		}
+
+		if min > max {
+			return nil, fmt.Errorf("size range %q is backwards", spec)
+		}
+
+		return &sizes{
+			min: min,
+			max: max,
+		}, nil
+	}
+
+	s := &sizes{}
+	for _, field := range strings.Split(spec, ",") {
+		size, err := parseSize(field)
+		if err != nil {
+			return nil, err
+		}
+
+		s.list = append(s.list, size)
+	}
+
+	return s, nil
+}
+
+// parseSize parses a single packet size in bytes
+func parseSize(field string) (int, error) {
+	size, err := strconv.Atoi(strings.TrimSpace(field))
+	if err != nil {
+		return 0, fmt.Errorf("invalid packet size %q", field)
+	}
+
+	if size < 1 || size > packet.MaxPacketSize {
+		return 0, fmt.Errorf("packet size %d must be between 1 and %d", size, packet.MaxPacketSize)
+	}
+
+	return size, nil
+}
+
+// Next returns the size of the next packet to send, 0 for an unpadded packet
+func (s *sizes) Next() int {
+	if s == nil {
+		return 0
+	}
+
+	if s.list == nil {
+		return s.min + rand.Intn(s.max-s.min+1)
+	}
+
+	size := s.list[s.next]
+	s.next = (s.next + 1) % len(s.list)
+
+	return size
+}
+
+// Max returns the largest size that will be sent
+func (s *sizes) Max() int {
+	if s == nil {
+		return 0
+	}
+
+	if s.list == nil {
+		return s.max
+	}
+
+	max := 0
+	for _, size := range s.list {
+		if size > max {
+			max = size
+		}
+	}
+
+	return max
+}
+
+// Bucket returns the bucket a packet of size bytes is reported in, the smallest size in the bucket.
+// Sizes from a list are each their own bucket, a range is split into rangeBuckets buckets
+func (s *sizes) Bucket(size int) int {
+	if s == nil || s.list != nil {
+		return size
+	}
+
+	width := (s.max - s.min + rangeBuckets) / rangeBuckets
+	if size < s.min {
+		return s.min
+	}
+
+	return s.min + (size-s.min)/width*width
+}
@@ -0,0 +1,297 @@
+package client
+
+import (
+	"sort"
+	"time"
+
+	"github.com/stormentt/packetloss/netstats"
+)
+
+// ClientStats is a summary of the packets recorded over one or more intervals
+type ClientStats struct {
+	Total uint64
+
+	TotalSent  uint64
+	TotalAcked uint64
+
+	SentAndAcked uint64
+	SAAPercent   float64
+
+	SentNotAcked uint64
+	SNAPercent   float64
+
+	AckedNotSent uint64
+	ANSPercent   float64
+
+	// Late packets were acked, but only after the ack timeout
+	Late        uint64
+	LatePercent float64
+
+	// LateAcks are acks for packets counted as lost by an earlier interval. They aren't part of this interval's Total,
+	// and merging moves them from the earlier interval's SentNotAcked to Late
+	LateAcks uint64
+
+	// UpstreamLost are packets that never reached the server, DownstreamLost are acks that never reached the client
+	UpstreamLost    uint64
+	UpstreamPercent float64
+
+	DownstreamLost    uint64
+	DownstreamPercent float64
+
+	AvgRTT    time.Duration
+	MinRTT    time.Duration
+	MaxRTT    time.Duration
+	StdDevRTT time.Duration
+	P50RTT    time.Duration
+	P90RTT    time.Duration
+	P95RTT    time.Duration
+	P99RTT    time.Duration
+	P999RTT   time.Duration
+
+	// RTTHistogram holds every RTT from the interval, histograms from several intervals can be merged
+	RTTHistogram *netstats.Histogram
+
+	// Forward is the client to server delay, Reverse is the server to client delay
+	// both are corrected by the estimated clock offset between client and server
+	AvgForward time.Duration
+	MinForward time.Duration
+	MaxForward time.Duration
+
+	AvgReverse time.Duration
+	MinReverse time.Duration
+	MaxReverse time.Duration
+
+	// ClockOffset is how far ahead the server clock is of the client clock, ClockDrift is in parts per million
+	ClockOffset time.Duration
+	ClockDrift  float64
+
+	// Jitter is the RFC 3550 interarrival jitter of acks, IPDV is the RFC 5481 delay variation between consecutive round trips
+	Jitter      time.Duration
+	IPDVMin     time.Duration
+	IPDVMax     time.Duration
+	IPDVMeanAbs time.Duration
+
+	// LossRuns is how many runs of consecutive lost packets there were, LongestOutage is the time between
+	// the packets either side of the worst outage. GilbertElliott is a loss model fitted to the interval
+	LossRuns       uint64
+	LongestLossRun uint64
+	LongestOutage  time.Duration
+	LossRunLengths []netstats.RunBucket
+	GilbertElliott netstats.GilbertElliott
+
+	// Sizes is the loss for each size bucket in ascending order of size, empty unless packets are padded
+	Sizes []SizeStats
+
+	// the raw measurements the summary fields are calculated from, kept so stats can be merged
+	forward       durationStats
+	reverse       durationStats
+	ipdv          netstats.IPDV
+	runs          netstats.LossRuns
+	splitSent     uint64
+	splitReceived uint64
+	sizes         map[int]*SizeStats
+	lateSizes     map[int]uint64
+}
+
+// SizeStats is the loss of packets in one size bucket. Size is the smallest size in the bucket
+type SizeStats struct {
+	Size int
+
+	Sent  uint64
+	Acked uint64
+	Lost  uint64
+	Late  uint64
+
+	LossPercent float64
+}
+
+// NewClientStats returns an empty ClientStats object, ready to have other stats merged into it
+func NewClientStats() *ClientStats {
+	return &ClientStats{
+		RTTHistogram: netstats.NewHistogram(),
+		sizes:        make(map[int]*SizeStats),
+	}
+}
+
+// Merge adds the packets summarised by other into cs, as if they had been recorded in a single interval.
+// Clock estimates are taken from other, and Jitter is the highest of the two since it is a running estimate
+func (cs *ClientStats) Merge(other *ClientStats) {
+	cs.Total += other.Total
+	cs.TotalSent += other.TotalSent
+	cs.TotalAcked += other.TotalAcked
+
+	cs.SentAndAcked += other.SentAndAcked
+	cs.SentNotAcked += other.SentNotAcked
+	cs.AckedNotSent += other.AckedNotSent
+	cs.Late += other.Late
+
+	cs.UpstreamLost += other.UpstreamLost
+	cs.DownstreamLost += other.DownstreamLost
+	cs.splitSent += other.splitSent
+	cs.splitReceived += other.splitReceived
+
+	cs.RTTHistogram.Merge(other.RTTHistogram)
+
+	cs.forward.merge(&other.forward)
+	cs.reverse.merge(&other.reverse)
+
+	cs.ClockOffset = other.ClockOffset
+	cs.ClockDrift = other.ClockDrift
+
+	if other.Jitter > cs.Jitter {
+		cs.Jitter = other.Jitter
+	}
+
+	cs.ipdv.Merge(&other.ipdv)
+	cs.runs.Merge(&other.runs)
+
+	for size, bucket := range other.sizes {
+		mine, ok := cs.sizes[size]
+		if !ok {
+			mine = &SizeStats{Size: size}
+			cs.sizes[size] = mine
+		}
+
+		mine.Sent += bucket.Sent
+		mine.Acked += bucket.Acked
+		mine.Lost += bucket.Lost
+		mine.Late += bucket.Late
+	}
+
+	// late acks correct the intervals their packets were sent in, which have already been merged into cs
+	late := other.LateAcks
+	if late > cs.SentNotAcked {
+		late = cs.SentNotAcked
+	}
+
+	cs.SentNotAcked -= late
+	cs.Late += late
+
+	for size, n := range other.lateSizes {
+		mine, ok := cs.sizes[size]
+		if !ok {
+			continue
+		}
+
+		if n > mine.Lost {
+			n = mine.Lost
+		}
+
+		mine.Lost -= n
+		mine.Late += n
+	}
+
+	cs.calculate()
+}
+
+// calculate fills in the percentages & summary fields from the counts and raw measurements
+func (cs *ClientStats) calculate() {
+	// an interval with nothing sent or acked has no loss rather than NaN
+	cs.SAAPercent, cs.SNAPercent, cs.ANSPercent, cs.LatePercent = 0, 0, 0, 0
+	if cs.Total != 0 {
+		cs.SAAPercent = float64(cs.SentAndAcked) / float64(cs.Total) * 100.0
+		cs.SNAPercent = float64(cs.SentNotAcked) / float64(cs.Total) * 100.0
+		cs.ANSPercent = float64(cs.AckedNotSent) / float64(cs.Total) * 100.0
+		cs.LatePercent = float64(cs.Late) / float64(cs.Total) * 100.0
+	}
+
+	// upstream loss is out of packets sent, downstream loss is out of acks the server sent
+	cs.UpstreamPercent = 0
+	if cs.splitSent != 0 {
+		cs.UpstreamPercent = float64(cs.UpstreamLost) / float64(cs.splitSent) * 100.0
+	}
+
+	cs.DownstreamPercent = 0
+	if cs.splitReceived != 0 {
+		cs.DownstreamPercent = float64(cs.DownstreamLost) / float64(cs.splitReceived) * 100.0
+	}
+
+	cs.AvgRTT = cs.RTTHistogram.Mean()
+	cs.MinRTT = cs.RTTHistogram.Min()
+	cs.MaxRTT = cs.RTTHistogram.Max()
+	cs.StdDevRTT = cs.RTTHistogram.StdDev()
+	cs.P50RTT = cs.RTTHistogram.Quantile(0.50)
+	cs.P90RTT = cs.RTTHistogram.Quantile(0.90)
+	cs.P95RTT = cs.RTTHistogram.Quantile(0.95)
+	cs.P99RTT = cs.RTTHistogram.Quantile(0.99)
+	cs.P999RTT = cs.RTTHistogram.Quantile(0.999)
+
+	cs.AvgForward = cs.forward.avg()
+	cs.MinForward = cs.forward.min
+	cs.MaxForward = cs.forward.max
+
+	cs.AvgReverse = cs.reverse.avg()
+	cs.MinReverse = cs.reverse.min
+	cs.MaxReverse = cs.reverse.max
+
+	cs.IPDVMin = cs.ipdv.Min()
+	cs.IPDVMax = cs.ipdv.Max()
+	cs.IPDVMeanAbs = cs.ipdv.MeanAbs()
+
+	cs.LossRuns = cs.runs.Runs()
+	cs.LongestLossRun = cs.runs.LongestRun()
+	cs.LongestOutage = cs.runs.LongestOutage()
+	cs.LossRunLengths = cs.runs.Distribution()
+	cs.GilbertElliott = cs.runs.GilbertElliott()
+
+	cs.Sizes = cs.Sizes[:0]
+	for _, bucket := range cs.sizes {
+		bucket.LossPercent = 0
+		if bucket.Sent != 0 {
+			bucket.LossPercent = float64(bucket.Lost) / float64(bucket.Sent) * 100.0
+		}
+
+		cs.Sizes = append(cs.Sizes, *bucket)
+	}
+
+	sort.Slice(cs.Sizes, func(i, j int) bool {
+		return cs.Sizes[i].Size < cs.Sizes[j].Size
+	})
+}
+
+// durationStats keeps a running total, minimum, and maximum of a set of durations
+type durationStats struct {
+	count uint64
+	total time.Duration
+
+	min time.Duration
+	max time.Duration
+}
+
+func (ds *durationStats) add(d time.Duration) {
+	if ds.count == 0 || d < ds.min {
+		ds.min = d
+	}
+
+	if ds.count == 0 || d > ds.max {
+		ds.max = d
+	}
+
+	ds.count++
+	ds.total += d
+}
+
+func (ds *durationStats) merge(other *durationStats) {
+	if other.count == 0 {
+		return
+	}
+
+	if ds.count == 0 || other.min < ds.min {
+		ds.min = other.min
+	}
+
+	if ds.count == 0 || other.max > ds.max {
+		ds.max = other.max
+	}
+
+	ds.count += other.count
+	ds.total += other.total
+}
+
+func (ds *durationStats) avg() time.Duration {
+	if ds.count == 0 {
+		return 0
+	}
+
+	return ds.total / time.Duration(ds.count)
+}
@@ -0,0 +1,209 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stormentt/packetloss/keyring"
+	wrapper "github.com/stormentt/packetloss/wrapper"
+)
+
+// Target is a server the client probes, with its own identity, key, rate, and packet sizes
+type Target struct {
+	// Name tags the target's reports, it is empty when the client only has the one target from the top level config
+	Name string
+
+	Remote   *net.UDPAddr
+	ClientID string
+	Key      keyring.Key
+
+	// PacketTime is the time between packets, or what the schedule bases it on. Mode is how packets are protected
+	PacketTime time.Duration
+	Mode       wrapper.Mode
+
+	schedule *schedule
+	sizes    *sizes
+	echoSize bool
+}
+
+// TargetConfig is a targets entry in the config file. Anything left out is taken from the top level config
+type TargetConfig struct {
+	Name       string        `mapstructure:"name"`
+	Remote     string        `mapstructure:"remote"`
+	ClientID   string        `mapstructure:"client_id"`
+	Key        string        `mapstructure:"key"`
+	KeyID      uint32        `mapstructure:"key_id"`
+	PacketTime time.Duration `mapstructure:"packet_time"`
+	Schedule   *string       `mapstructure:"schedule"`
+	Encrypt    *bool         `mapstructure:"encrypt"`
+	Size       *string       `mapstructure:"size"`
+	EchoSize   *bool         `mapstructure:"echo_size"`
+}
+
+// Schedule describes when packets are sent to t
+func (t *Target) Schedule() string {
+	return t.schedule.String()
+}
+
+// TargetFromConfig returns the target described by the top level config: remote, client_id, key, and so on
+func TargetFromConfig() (*Target, error) {
+	remote := viper.GetString("remote")
+	raddr, err := net.ResolveUDPAddr("udp", remote)
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve remote %q: %w", remote, err)
+	}
+
+	key, err := keyring.ClientFromConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	clientID, err := clientIDFromConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	sizes, err := parseSizes(viper.GetString("size"))
+	if err != nil {
+		return nil, err
+	}
+
+	packetTime := viper.GetDuration("packet_time")
+	schedule, err := parseSchedule(viper.GetString("schedule"), packetTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Target{
+		Remote:     raddr,
+		ClientID:   clientID,
+		Key:        key,
+		PacketTime: packetTime,
+		Mode:       modeFromConfig(),
+		schedule:   schedule,
+		sizes:      sizes,
+		echoSize:   viper.GetBool("echo_size"),
+	}, nil
+}
+
+// TargetsFromConfig returns every target in the targets list of the config file,
+// or just the target from the top level config if there is no list
+func TargetsFromConfig() ([]*Target, error) {
+	var configs []TargetConfig
+	err := viper.UnmarshalKey("targets", &configs)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(configs) == 0 {
+		t, err := TargetFromConfig()
+		if err != nil {
+			return nil, err
+		}
+
+		return []*Target{t}, nil
+	}
+
+	names := make(map[string]bool)
+	targets := make([]*Target, 0, len(configs))
+
+	for _, tc := range configs {
+		t, err := NewTarget(tc)
+		if err != nil {
+			return nil, err
+		}
+
+		if names[t.Name] {
+			return nil, fmt.Errorf("target %q is listed more than once", t.Name)
+		}
+
+		names[t.Name] = true
+		targets = append(targets, t)
+	}
+
+	return targets, nil
+}
+
+// NewTarget creates a target from a targets entry, filling in anything it leaves out from the top level config
+func NewTarget(tc TargetConfig) (*Target, error) {
+	if tc.Remote == "" {
+		return nil, fmt.Errorf("target %q has no remote", tc.Name)
+	}
+
+	raddr, err := net.ResolveUDPAddr("udp", tc.Remote)
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve remote %q of target %q: %w", tc.Remote, tc.Name, err)
+	}
+
+	t := &Target{
+		Name:       tc.Name,
+		Remote:     raddr,
+		ClientID:   tc.ClientID,
+		PacketTime: tc.PacketTime,
+		Mode:       modeFromConfig(),
+		echoSize:   viper.GetBool("echo_size"),
+	}
+
+	if t.Name == "" {
+		t.Name = tc.Remote
+	}
+
+	if t.ClientID == "" {
+		t.ClientID, err = clientIDFromConfig()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(t.ClientID) > 64 {
+		return nil, fmt.Errorf("clientID of target %q too long, max length 64 (length %d)", t.Name, len(t.ClientID))
+	}
+
+	if tc.Key != "" {
+		t.Key = keyring.NewKey(tc.Key, tc.KeyID)
+	} else {
+		t.Key, err = keyring.ClientFromConfig()
+		if err != nil {
+			return nil, fmt.Errorf("target %q: %w", t.Name, err)
+		}
+	}
+
+	if t.PacketTime == 0 {
+		t.PacketTime = viper.GetDuration("packet_time")
+	}
+
+	schedule := viper.GetString("schedule")
+	if tc.Schedule != nil {
+		schedule = *tc.Schedule
+	}
+
+	t.schedule, err = parseSchedule(schedule, t.PacketTime)
+	if err != nil {
+		return nil, fmt.Errorf("target %q: %w", t.Name, err)
+	}
+
+	if tc.Encrypt != nil {
+		t.Mode = wrapper.ModeHMAC
+		if *tc.Encrypt {
+			t.Mode = wrapper.ModeAEAD
+		}
+	}
+
+	size := viper.GetString("size")
+	if tc.Size != nil {
+		size = *tc.Size
+	}
+
+	t.sizes, err = parseSizes(size)
+	if err != nil {
+		return nil, fmt.Errorf("target %q: %w", t.Name, err)
+	}
+
+	if tc.EchoSize != nil {
+		t.echoSize = *tc.EchoSize
+	}
+
+	return t, nil
+}
@@ -0,0 +1,286 @@
+/*
+Copyright © 2022 Tanner Storment
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stormentt/packetloss/client"
+	"github.com/stormentt/packetloss/keyring"
+	"github.com/stormentt/packetloss/server"
+)
+
+// benchCmd represents the bench command
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Measure how many packets per second a server & client can handle over loopback",
+	Long: `Run a server and a client sending as fast as it can in one process over loopback, and report the packets per
+second sent & acked. Then search for the highest rate the client can send at without loss, and report how many packets
+per second were acked at that rate. The results can be written as JSON to track throughput from release to release`,
+	PreRun: bindBenchFlags,
+	Run: func(cmd *cobra.Command, args []string) {
+		if viper.GetString("key") == "" {
+			secret := make([]byte, 32)
+			_, err := rand.Read(secret)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"Error": err,
+				}).Fatal("could not generate a key")
+			}
+
+			viper.Set("key", hex.EncodeToString(secret))
+		}
+
+		result, err := runBench()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Error": err,
+			}).Fatal("could not run benchmark")
+		}
+
+		log.WithFields(log.Fields{
+			"Sent":        result.Sent,
+			"Acked":       result.Acked,
+			"SentPPS":     fmt.Sprintf("%.0f", result.SentPPS),
+			"AckedPPS":    fmt.Sprintf("%.0f", result.AckedPPS),
+			"LossPercent": fmt.Sprintf("%.2f", result.LossPercent),
+			"LosslessPPS": fmt.Sprintf("%.0f", result.LosslessPPS),
+		}).Info("benchmark finished")
+
+		path := viper.GetString("bench_results")
+		if path == "" {
+			return
+		}
+
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Error": err,
+			}).Fatal("could not encode results")
+		}
+
+		err = os.WriteFile(path, append(data, '\n'), 0644)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Error": err,
+				"Path":  path,
+			}).Fatal("could not write results")
+		}
+	},
+}
+
+// benchResult is the outcome of a benchmark, as it is written to --results.
+// Sent, Acked, SentPPS, AckedPPS, & LossPercent are from flooding the server, which measures how much it can take
+// when overloaded. LosslessPPS is the highest rate acked with no more than MaxLoss percent loss, 0 if none was
+type benchResult struct {
+	GOOS   string `json:"goos"`
+	GOARCH string `json:"goarch"`
+	CPUs   int    `json:"cpus"`
+
+	Duration     time.Duration `json:"duration_ns"`
+	Batch        int           `json:"batch"`
+	SocketBuffer int           `json:"socket_buffer"`
+	Size         string        `json:"size,omitempty"`
+	Encrypt      bool          `json:"encrypt"`
+	MaxLoss      float64       `json:"max_loss"`
+
+	Sent        uint64  `json:"sent"`
+	Acked       uint64  `json:"acked"`
+	SentPPS     float64 `json:"sent_pps"`
+	AckedPPS    float64 `json:"acked_pps"`
+	LossPercent float64 `json:"loss_percent"`
+
+	LosslessPPS float64 `json:"lossless_pps"`
+
+	// Steps are every run, flooding first then the rates tried while searching for LosslessPPS
+	Steps []benchStep `json:"steps"`
+}
+
+// benchStep is one run of the benchmark at Rate packets per second, 0 for as fast as possible
+type benchStep struct {
+	Rate        float64       `json:"rate"`
+	Sent        uint64        `json:"sent"`
+	Acked       uint64        `json:"acked"`
+	SentPPS     float64       `json:"sent_pps"`
+	AckedPPS    float64       `json:"acked_pps"`
+	LossPercent float64       `json:"loss_percent"`
+	RTTAvg      time.Duration `json:"rtt_avg_ns"`
+	RTTP99      time.Duration `json:"rtt_p99_ns"`
+}
+
+// runBench serves on a loopback socket and floods it for the configured duration, then binary searches below the
+// rate that was acked for the highest rate that loses no more than bench_max_loss percent, one run per step
+func runBench() (*benchResult, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	keys, err := keyring.FromConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// the server reads the config while it runs, so nothing can be set once it has started
+	viper.Set("packet_time", 0)
+	remote := conn.LocalAddr().String()
+
+	go func() {
+		err := server.Serve(conn, keys, nil)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Error": err,
+			}).Error("benchmark server stopped")
+		}
+	}()
+
+	maxLoss := viper.GetFloat64("bench_max_loss")
+
+	flood, err := runBenchStep(remote, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &benchResult{
+		GOOS:         runtime.GOOS,
+		GOARCH:       runtime.GOARCH,
+		CPUs:         runtime.NumCPU(),
+		Duration:     viper.GetDuration("duration"),
+		Batch:        viper.GetInt("batch"),
+		SocketBuffer: viper.GetInt("socket_buffer"),
+		Size:         viper.GetString("size"),
+		Encrypt:      viper.GetBool("encrypt"),
+		MaxLoss:      maxLoss,
+		Sent:         flood.Sent,
+		Acked:        flood.Acked,
+		SentPPS:      flood.SentPPS,
+		AckedPPS:     flood.AckedPPS,
+		LossPercent:  flood.LossPercent,
+		Steps:        []benchStep{*flood},
+	}
+
+	// the first step tries the rate that was acked while flooding, the rest halve the gap between the best rate
+	// without loss and the lowest rate with it, which starts as the rate sent while flooding
+	low, high := 0.0, flood.SentPPS
+	for i := 0; i < viper.GetInt("bench_steps") && flood.AckedPPS >= 1; i++ {
+		rate := flood.AckedPPS
+		if i > 0 {
+			rate = (low + high) / 2
+		}
+
+		step, err := runBenchStep(remote, rate)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Steps = append(result.Steps, *step)
+
+		if step.LossPercent > maxLoss {
+			high = rate
+			continue
+		}
+
+		low = rate
+		result.LosslessPPS = step.AckedPPS
+	}
+
+	return result, nil
+}
+
+// runBenchStep sends to remote at rate packets per second, or as fast as possible if rate is 0, for the configured duration
+func runBenchStep(remote string, rate float64) (*benchStep, error) {
+	var packetTime time.Duration
+	if rate > 0 {
+		packetTime = time.Duration(float64(time.Second) / rate)
+	}
+
+	target, err := client.NewTarget(client.TargetConfig{
+		Name:       "bench",
+		Remote:     remote,
+		PacketTime: packetTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	summaries, err := client.Start([]*client.Target{target})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := summaries[0].Stats
+	duration := viper.GetDuration("duration")
+
+	step := &benchStep{
+		Rate:        rate,
+		Sent:        stats.TotalSent,
+		Acked:       stats.SentAndAcked,
+		SentPPS:     float64(stats.TotalSent) / duration.Seconds(),
+		AckedPPS:    float64(stats.SentAndAcked) / duration.Seconds(),
+		LossPercent: stats.SNAPercent,
+		RTTAvg:      stats.AvgRTT,
+		RTTP99:      stats.P99RTT,
+	}
+
+	log.WithFields(log.Fields{
+		"Rate":        fmt.Sprintf("%.0f", rate),
+		"AckedPPS":    fmt.Sprintf("%.0f", step.AckedPPS),
+		"LossPercent": fmt.Sprintf("%.2f", step.LossPercent),
+		"RTTAvg":      step.RTTAvg,
+	}).Info("benchmark step")
+
+	return step, nil
+}
+
+// bindBenchFlags binds the flags the bench command shares with the client & server commands, see bindMeshFlags
+func bindBenchFlags(cmd *cobra.Command, args []string) {
+	viper.BindPFlag("duration", cmd.Flags().Lookup("duration"))
+	viper.BindPFlag("drain", cmd.Flags().Lookup("drain"))
+	viper.BindPFlag("encrypt", cmd.Flags().Lookup("encrypt"))
+	viper.BindPFlag("size", cmd.Flags().Lookup("size"))
+	viper.BindPFlag("batch", cmd.Flags().Lookup("batch"))
+	viper.BindPFlag("socket_buffer", cmd.Flags().Lookup("socket-buffer"))
+	viper.BindPFlag("bench_results", cmd.Flags().Lookup("results"))
+	viper.BindPFlag("bench_max_loss", cmd.Flags().Lookup("max-loss"))
+	viper.BindPFlag("bench_steps", cmd.Flags().Lookup("steps"))
+}
+
+func init() {
+	benchCmd.Flags().Duration("duration", 5*time.Second, "Time to send packets for in each step")
+	benchCmd.Flags().Duration("drain", 2*time.Second, "Time to wait for the last acks after sending stops")
+	benchCmd.Flags().Bool("encrypt", false, "Encrypt packets with XChaCha20-Poly1305 instead of only authenticating them")
+	benchCmd.Flags().String("size", "", "Pad packets to a size in bytes: fixed (1400), a list to rotate through (64,512,1400), or a random range (64-1400)")
+	benchCmd.Flags().Int("batch", 32, "Most packets to send, receive, or ack with one system call, 1 to disable batching")
+	benchCmd.Flags().Int("socket-buffer", 4<<20, "Size of the sockets' send & receive buffers in bytes, capped by the OS (0 for the OS default)")
+	benchCmd.Flags().Float64("max-loss", 0.1, "Most loss in percent a rate can have and still count as without loss")
+	benchCmd.Flags().Int("steps", 5, "Most rates to try when searching for the highest rate without loss")
+	benchCmd.Flags().String("results", "", "Write the results as JSON to this file")
+
+	rootCmd.AddCommand(benchCmd)
+}
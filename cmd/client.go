@@ -18,63 +18,156 @@ package cmd
 
 import (
 	"fmt"
-	"net"
+	"os"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/stormentt/packetloss/client"
-	"golang.org/x/crypto/blake2b"
 )
 
 // clientCmd represents the client command
 var clientCmd = &cobra.Command{
-	Use:   "client",
-	Short: "Send UDP packets to server and record acknowledgements",
-	Long:  ``,
+	Use:    "client",
+	Short:  "Send UDP packets to server and record acknowledgements",
+	Long:   ``,
+	PreRun: bindClientFlags,
 	Run: func(cmd *cobra.Command, args []string) {
-		remoteStr := viper.GetString("remote")
-		raddr, err := net.ResolveUDPAddr("udp", remoteStr)
+		targets, err := client.TargetsFromConfig()
 		if err != nil {
 			log.WithFields(log.Fields{
-				"Error":         err,
-				"RemoteAddress": remoteStr,
-			}).Fatal("could not resolve remote addr")
+				"Error": err,
+			}).Fatal("could not load targets")
 		}
 
-		log.WithFields(log.Fields{
-			"RemoteAddress": remoteStr,
-		}).Info("sending packets")
+		for _, t := range targets {
+			log.WithFields(log.Fields{
+				"Target":        t.Name,
+				"RemoteAddress": t.Remote,
+				"ClientID":      t.ClientID,
+				"Schedule":      t.Schedule(),
+			}).Info("sending packets")
 
-		hkey := blake2b.Sum512([]byte(viper.GetString("key")))
-		log.WithFields(log.Fields{
-			"hkey": fmt.Sprintf("%X", hkey),
-		}).Debug("using mac key")
+			log.WithFields(log.Fields{
+				"Target": t.Name,
+				"KeyID":  t.Key.ID,
+				"hkey":   fmt.Sprintf("%X", t.Key.MAC),
+			}).Debug("using mac key")
+		}
 
-		err = client.Start(hkey[:], raddr)
+		summaries, err := client.Start(targets)
 
 		if err != nil {
 			log.WithFields(log.Fields{
-				"Error":         err,
-				"RemoteAddress": remoteStr,
+				"Error": err,
 			}).Fatal("could not send packets")
 		}
 
 		log.Info("finished")
+
+		ok := true
+		for _, s := range summaries {
+			if !checkThresholds(s.Target.Name, s.Stats) {
+				ok = false
+			}
+		}
+
+		if !ok {
+			os.Exit(2)
+		}
 	},
 }
 
+// checkThresholds compares the summary of a target's finished run against the --max-* flags
+// it returns false if any threshold was breached
+func checkThresholds(target string, summary *client.ClientStats) bool {
+	ok := true
+
+	maxLoss := viper.GetFloat64("max_loss")
+	if maxLoss >= 0 && summary.SNAPercent > maxLoss {
+		log.WithFields(log.Fields{
+			"Target":  target,
+			"Loss":    fmt.Sprintf("%.2f", summary.SNAPercent),
+			"MaxLoss": fmt.Sprintf("%.2f", maxLoss),
+		}).Error("loss threshold breached")
+
+		ok = false
+	}
+
+	maxP99 := viper.GetDuration("max_p99")
+	if maxP99 > 0 && summary.P99RTT > maxP99 {
+		log.WithFields(log.Fields{
+			"Target": target,
+			"P99":    summary.P99RTT,
+			"MaxP99": maxP99,
+		}).Error("p99 RTT threshold breached")
+
+		ok = false
+	}
+
+	maxJitter := viper.GetDuration("max_jitter")
+	if maxJitter > 0 && summary.Jitter > maxJitter {
+		log.WithFields(log.Fields{
+			"Target":    target,
+			"Jitter":    summary.Jitter,
+			"MaxJitter": maxJitter,
+		}).Error("jitter threshold breached")
+
+		ok = false
+	}
+
+	return ok
+}
+
+// bindClientFlags binds the flags the client shares with the server command.
+// They are bound when the command runs, binding them in init would leave viper reading the server's flags
+func bindClientFlags(cmd *cobra.Command, args []string) {
+	bindKeyFlags(cmd, args)
+
+	viper.BindPFlag("batch", cmd.Flags().Lookup("batch"))
+	viper.BindPFlag("socket_buffer", cmd.Flags().Lookup("socket-buffer"))
+}
+
 func init() {
 	clientCmd.Flags().StringP("remote", "r", "localhost:6666", "Remote address to send packets to")
-	clientCmd.Flags().StringP("key", "k", "", "Key to use for HMAC")
-	clientCmd.Flags().DurationP("packet-time", "t", 100*time.Millisecond, "Time to wait between sending packets")
+	addKeyFlags(clientCmd)
+	clientCmd.Flags().DurationP("packet-time", "t", 100*time.Millisecond, "Time to wait between sending packets, 0 to send as fast as possible")
+	clientCmd.Flags().String("schedule", "fixed", "When to send packets: fixed, poisson (random, averaging --packet-time apart), burst:<packets> every --packet-time, or ramp:<packet time>/<ramp time> from --packet-time")
 	clientCmd.Flags().StringP("client-id", "i", "", "ClientID to use for sending packets (default random UUID)")
+	clientCmd.Flags().Bool("encrypt", false, "Encrypt packets with XChaCha20-Poly1305 instead of only authenticating them")
+	clientCmd.Flags().String("size", "", "Pad packets to a size in bytes: fixed (1400), a list to rotate through (64,512,1400), or a random range (64-1400)")
+	clientCmd.Flags().Bool("echo-size", false, "Ask the server to pad its acks to the size of each packet")
+	clientCmd.Flags().Int("hello-retries", 5, "Number of hellos & resets to send before giving up on the server")
+	clientCmd.Flags().Duration("hello-timeout", time.Second, "Time to wait for the server to answer each hello or reset")
+	clientCmd.Flags().Bool("no-hello", false, "Skip the handshake, for servers older than protocol version 2")
+	clientCmd.Flags().Int("batch", 32, "Most packets to send or receive with one system call, 1 to disable batching")
+	clientCmd.Flags().Int("socket-buffer", 4<<20, "Size of the socket's send & receive buffers in bytes, capped by the OS (0 for the OS default)")
+	clientCmd.Flags().Duration("ack-timeout", 2*time.Second, "Time to wait for an ack before a packet is counted as lost")
+	clientCmd.Flags().Uint64("count", 0, "Number of packets to send before stopping (default 0, no limit)")
+	clientCmd.Flags().Duration("duration", 0, "Time to send packets for before stopping (default 0, no limit)")
+	clientCmd.Flags().Duration("drain", 2*time.Second, "Time to wait for the last acks after sending stops")
+	clientCmd.Flags().Float64("max-loss", -1, "Exit with status 2 if the run's loss percentage is higher than this (negative to disable)")
+	clientCmd.Flags().Duration("max-p99", 0, "Exit with status 2 if the run's p99 RTT is higher than this (0 to disable)")
+	clientCmd.Flags().Duration("max-jitter", 0, "Exit with status 2 if the run's jitter is higher than this (0 to disable)")
 
 	viper.BindPFlag("remote", clientCmd.Flags().Lookup("remote"))
-	viper.BindPFlag("key", clientCmd.Flags().Lookup("key"))
 	viper.BindPFlag("packet_time", clientCmd.Flags().Lookup("packet-time"))
+	viper.BindPFlag("schedule", clientCmd.Flags().Lookup("schedule"))
 	viper.BindPFlag("client_id", clientCmd.Flags().Lookup("client-id"))
+	viper.BindPFlag("encrypt", clientCmd.Flags().Lookup("encrypt"))
+	viper.BindPFlag("size", clientCmd.Flags().Lookup("size"))
+	viper.BindPFlag("echo_size", clientCmd.Flags().Lookup("echo-size"))
+	viper.BindPFlag("hello_retries", clientCmd.Flags().Lookup("hello-retries"))
+	viper.BindPFlag("hello_timeout", clientCmd.Flags().Lookup("hello-timeout"))
+	viper.BindPFlag("no_hello", clientCmd.Flags().Lookup("no-hello"))
+	viper.BindPFlag("ack_timeout", clientCmd.Flags().Lookup("ack-timeout"))
+	viper.BindPFlag("count", clientCmd.Flags().Lookup("count"))
+	viper.BindPFlag("duration", clientCmd.Flags().Lookup("duration"))
+	viper.BindPFlag("drain", clientCmd.Flags().Lookup("drain"))
+	viper.BindPFlag("max_loss", clientCmd.Flags().Lookup("max-loss"))
+	viper.BindPFlag("max_p99", clientCmd.Flags().Lookup("max-p99"))
+	viper.BindPFlag("max_jitter", clientCmd.Flags().Lookup("max-jitter"))
 
 	rootCmd.AddCommand(clientCmd)
 }
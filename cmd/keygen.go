@@ -0,0 +1,48 @@
+/*
+Copyright © 2022 Tanner Storment
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// keygenCmd represents the keygen command
+var keygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Print a random key",
+	Long:  `Print a random 256 bit key, for use as key in packetloss.yml or with --key`,
+	Run: func(cmd *cobra.Command, args []string) {
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Error": err,
+			}).Fatal("could not generate key")
+		}
+
+		fmt.Println(base64.RawURLEncoding.EncodeToString(key))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(keygenCmd)
+}
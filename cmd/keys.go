@@ -0,0 +1,44 @@
+/*
+Copyright © 2022 Tanner Storment
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// addKeyFlags adds the key flags shared by the client and server commands
+func addKeyFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("key", "k", "", "Key to use for HMAC")
+	cmd.Flags().Uint32("key-id", 0, "ID of the key, sent with every packet so keys can be rotated (default 0, legacy key without an ID)")
+	cmd.Flags().Bool("legacy-keys", false, "Derive keys without an ID with BLAKE2b instead of HKDF-SHA256, to talk to versions of packetloss from before HKDF")
+	cmd.Flags().Bool("insecure", false, "Allow running with an empty key")
+}
+
+// bindKeyFlags binds the key flags of the command that is running.
+// The client and server both have them, binding them in init would leave viper reading whichever command was bound last
+func bindKeyFlags(cmd *cobra.Command, args []string) {
+	viper.BindPFlag("key", cmd.Flags().Lookup("key"))
+	viper.BindPFlag("key_id", cmd.Flags().Lookup("key-id"))
+	viper.BindPFlag("insecure", cmd.Flags().Lookup("insecure"))
+	viper.BindPFlag("legacy_keys", cmd.Flags().Lookup("legacy-keys"))
+
+	if viper.GetBool("legacy_keys") {
+		log.Warn("keys without a key_id are derived with the legacy BLAKE2b derivation, only use --legacy-keys until every client & server is upgraded")
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright © 2022 Tanner Storment
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stormentt/packetloss/keyring"
+	"github.com/stormentt/packetloss/mesh"
+)
+
+// meshCmd represents the mesh command
+var meshCmd = &cobra.Command{
+	Use:   "mesh",
+	Short: "Run as one node of a full mesh, both server and client of every peer",
+	Long: `Listen for every peer's packets like the server and probe every other peer like the client, from one socket,
+and share results with the peers to build a loss & RTT matrix of the whole mesh`,
+	PreRun: bindMeshFlags,
+	Run: func(cmd *cobra.Command, args []string) {
+		name := viper.GetString("name")
+		if name == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				log.WithFields(log.Fields{
+					"Error": err,
+				}).Fatal("no --name given and could not get the hostname")
+			}
+
+			name = hostname
+		}
+
+		peers, err := mesh.PeersFromConfig(name)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Error": err,
+			}).Fatal("could not load peers")
+		}
+
+		localStr := viper.GetString("local")
+		laddr, err := net.ResolveUDPAddr("udp", localStr)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Error":        err,
+				"LocalAddress": localStr,
+			}).Fatal("could not resolve listen addr")
+		}
+
+		keys, err := keyring.NewReloadable(loadKeyring)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Error": err,
+			}).Fatal("could not load keyring")
+		}
+
+		keys.ReloadOnSignal(syscall.SIGHUP)
+
+		for _, t := range peers {
+			log.WithFields(log.Fields{
+				"Node":          name,
+				"Peer":          t.Name,
+				"RemoteAddress": t.Remote,
+				"Schedule":      t.Schedule(),
+			}).Info("peer")
+		}
+
+		err = mesh.Run(name, keys, laddr, peers)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Error":        err,
+				"LocalAddress": localStr,
+			}).Fatal("mesh node stopped")
+		}
+
+		log.Info("finished")
+	},
+}
+
+// bindMeshFlags binds the flags the mesh command shares with the client & server commands.
+// They are bound when the command runs, binding them in init would override the other commands' flags
+func bindMeshFlags(cmd *cobra.Command, args []string) {
+	bindKeyFlags(cmd, args)
+
+	viper.BindPFlag("local", cmd.Flags().Lookup("local"))
+	viper.BindPFlag("packet_time", cmd.Flags().Lookup("packet-time"))
+	viper.BindPFlag("schedule", cmd.Flags().Lookup("schedule"))
+	viper.BindPFlag("encrypt", cmd.Flags().Lookup("encrypt"))
+	viper.BindPFlag("size", cmd.Flags().Lookup("size"))
+	viper.BindPFlag("batch", cmd.Flags().Lookup("batch"))
+	viper.BindPFlag("socket_buffer", cmd.Flags().Lookup("socket-buffer"))
+	viper.BindPFlag("ack_timeout", cmd.Flags().Lookup("ack-timeout"))
+	viper.BindPFlag("hello_retries", cmd.Flags().Lookup("hello-retries"))
+	viper.BindPFlag("hello_timeout", cmd.Flags().Lookup("hello-timeout"))
+	viper.BindPFlag("max_skew", cmd.Flags().Lookup("max-skew"))
+	viper.BindPFlag("require_encryption", cmd.Flags().Lookup("require-encryption"))
+}
+
+func init() {
+	meshCmd.Flags().StringP("name", "n", "", "Name of this node in the peers list, also its ClientID (default the hostname)")
+	meshCmd.Flags().StringP("local", "l", ":6666", "Local address to listen on & send from")
+	addKeyFlags(meshCmd)
+	meshCmd.Flags().DurationP("packet-time", "t", 100*time.Millisecond, "Time to wait between sending packets to each peer")
+	meshCmd.Flags().String("schedule", "fixed", "When to send packets: fixed, poisson (random, averaging --packet-time apart), burst:<packets> every --packet-time, or ramp:<packet time>/<ramp time> from --packet-time")
+	meshCmd.Flags().Bool("encrypt", false, "Encrypt packets with XChaCha20-Poly1305 instead of only authenticating them")
+	meshCmd.Flags().String("size", "", "Pad packets to a size in bytes: fixed (1400), a list to rotate through (64,512,1400), or a random range (64-1400)")
+	meshCmd.Flags().Int("batch", 32, "Most packets to receive or ack with one system call, 1 to disable batching")
+	meshCmd.Flags().Int("socket-buffer", 4<<20, "Size of the socket's send & receive buffers in bytes, capped by the OS (0 for the OS default)")
+	meshCmd.Flags().Duration("ack-timeout", 2*time.Second, "Time to wait for an ack before a packet is counted as lost")
+	meshCmd.Flags().Int("hello-retries", 5, "Number of hellos & resets to send before trying a peer again later")
+	meshCmd.Flags().Duration("hello-timeout", time.Second, "Time to wait for a peer to answer each hello or reset")
+	meshCmd.Flags().Duration("retry-interval", 10*time.Second, "Time to wait before trying a peer that couldn't be reached again")
+	meshCmd.Flags().Duration("max-skew", time.Minute, "Reject packets timestamped further than this from this node's clock")
+	meshCmd.Flags().Bool("require-encryption", false, "Reject packets that aren't encrypted")
+
+	viper.BindPFlag("name", meshCmd.Flags().Lookup("name"))
+	viper.BindPFlag("retry_interval", meshCmd.Flags().Lookup("retry-interval"))
+
+	rootCmd.AddCommand(meshCmd)
+}
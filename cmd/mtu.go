@@ -0,0 +1,105 @@
+/*
+Copyright © 2022 Tanner Storment
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stormentt/packetloss/client"
+	packet "github.com/stormentt/packetloss/packet"
+)
+
+// mtuCmd represents the mtu command
+var mtuCmd = &cobra.Command{
+	Use:   "mtu",
+	Short: "Find the largest UDP payload the path to the server delivers",
+	Long: `Binary search for the largest UDP payload that reaches the server, with the DF bit set on Linux,
+and report the path MTU and whether larger packets are dropped silently (a black hole)`,
+	PreRun: bindMTUFlags,
+	Run: func(cmd *cobra.Command, args []string) {
+		t, err := client.TargetFromConfig()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Error": err,
+			}).Fatal("could not load target")
+		}
+
+		log.WithFields(log.Fields{
+			"RemoteAddress": t.Remote,
+		}).Info("probing path MTU")
+
+		res, err := client.DiscoverMTU(t)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Error":         err,
+				"RemoteAddress": t.Remote,
+			}).Fatal("could not probe path MTU")
+		}
+
+		log.WithFields(log.Fields{
+			"Payload": res.Payload,
+			"PathMTU": res.PathMTU,
+			"TooBig":  res.TooBig,
+			"Probes":  res.Probes,
+		}).Info("path MTU")
+
+		if res.BlackHole {
+			log.WithFields(log.Fields{
+				"TooBig": res.TooBig,
+			}).Warn("larger packets were dropped without an error, the path is a black hole for them")
+		} else if res.Rejected {
+			log.WithFields(log.Fields{
+				"TooBig": res.TooBig,
+			}).Info("larger packets were rejected as too big")
+		}
+	},
+}
+
+// bindMTUFlags binds the flags the mtu command shares with the client command.
+// They are bound when the command runs, binding them in init would override the client's flags
+func bindMTUFlags(cmd *cobra.Command, args []string) {
+	bindKeyFlags(cmd, args)
+
+	viper.BindPFlag("remote", cmd.Flags().Lookup("remote"))
+	viper.BindPFlag("client_id", cmd.Flags().Lookup("client-id"))
+	viper.BindPFlag("encrypt", cmd.Flags().Lookup("encrypt"))
+	viper.BindPFlag("hello_retries", cmd.Flags().Lookup("hello-retries"))
+	viper.BindPFlag("hello_timeout", cmd.Flags().Lookup("hello-timeout"))
+}
+
+func init() {
+	mtuCmd.Flags().StringP("remote", "r", "localhost:6666", "Remote address to send probes to")
+	addKeyFlags(mtuCmd)
+	mtuCmd.Flags().StringP("client-id", "i", "", "ClientID to use for sending probes (default random UUID)")
+	mtuCmd.Flags().Bool("encrypt", false, "Encrypt probes with XChaCha20-Poly1305 instead of only authenticating them")
+	mtuCmd.Flags().Int("hello-retries", 5, "Number of hellos to send before giving up on the server")
+	mtuCmd.Flags().Duration("hello-timeout", time.Second, "Time to wait for the server to answer each hello")
+	mtuCmd.Flags().Int("min", 0, "Smallest UDP payload to probe in bytes (default 0, the smallest probe)")
+	mtuCmd.Flags().Int("max", packet.MaxPacketSize, "Largest UDP payload to probe in bytes")
+	mtuCmd.Flags().Int("probe-retries", 3, "Number of probes to send at each size before it counts as too big")
+	mtuCmd.Flags().Duration("probe-timeout", 500*time.Millisecond, "Time to wait for the server to answer each probe")
+
+	viper.BindPFlag("mtu_min", mtuCmd.Flags().Lookup("min"))
+	viper.BindPFlag("mtu_max", mtuCmd.Flags().Lookup("max"))
+	viper.BindPFlag("probe_retries", mtuCmd.Flags().Lookup("probe-retries"))
+	viper.BindPFlag("probe_timeout", mtuCmd.Flags().Lookup("probe-timeout"))
+
+	rootCmd.AddCommand(mtuCmd)
+}
@@ -61,12 +61,13 @@ func initConfig() {
 		viper.AddConfigPath(home)
 		viper.SetConfigType("yml")
 		viper.SetConfigName("packetloss")
-		err = viper.ReadInConfig()
-		if err != nil {
-			log.WithFields(log.Fields{
-				"Error": err,
-			}).Debug("no config file")
-		}
+	}
+
+	err := viper.ReadInConfig()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Error": err,
+		}).Debug("no config file")
 	}
 }
 
@@ -99,7 +100,13 @@ func init() {
 	rootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "", "config file (default is $HOME/packetloss.yaml)")
 	rootCmd.PersistentFlags().StringP("log-level", "v", "INFO", "level of verbosity (DEBUG, INFO, WARN, ERROR, FATAL)")
 	rootCmd.PersistentFlags().DurationP("update-time", "u", time.Minute*10, "time between printing updates")
+	rootCmd.PersistentFlags().StringP("output", "o", "text", "format of updates (text, json)")
+	rootCmd.PersistentFlags().String("output-file", "", "file to append json updates to (default stdout)")
+	rootCmd.PersistentFlags().String("metrics-listen", "", "address to serve prometheus metrics on, e.g. :9100 (default disabled)")
 
 	viper.BindPFlag("update-time", rootCmd.PersistentFlags().Lookup("update-time"))
 	viper.BindPFlag("loglevel", rootCmd.PersistentFlags().Lookup("log-level"))
+	viper.BindPFlag("output", rootCmd.PersistentFlags().Lookup("output"))
+	viper.BindPFlag("output_file", rootCmd.PersistentFlags().Lookup("output-file"))
+	viper.BindPFlag("metrics_listen", rootCmd.PersistentFlags().Lookup("metrics-listen"))
 }
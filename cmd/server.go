@@ -17,22 +17,23 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package cmd
 
 import (
-	"fmt"
 	"net"
+	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/stormentt/packetloss/keyring"
 	"github.com/stormentt/packetloss/server"
-	"golang.org/x/crypto/blake2b"
 )
 
 // serverCmd represents the server command
 var serverCmd = &cobra.Command{
-	Use:   "server",
-	Short: "Listen for UDP packets and Acknowledge them",
-	Long:  ``,
+	Use:    "server",
+	Short:  "Listen for UDP packets and Acknowledge them",
+	Long:   ``,
+	PreRun: bindServerFlags,
 	Run: func(cmd *cobra.Command, args []string) {
 		localStr := viper.GetString("local")
 		laddr, err := net.ResolveUDPAddr("udp", localStr)
@@ -43,13 +44,16 @@ var serverCmd = &cobra.Command{
 			}).Fatal("could not resolve listen addr")
 		}
 
-		hkey := blake2b.Sum512([]byte(viper.GetString("key")))
+		keys, err := keyring.NewReloadable(loadKeyring)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Error": err,
+			}).Fatal("could not load keyring")
+		}
 
-		log.WithFields(log.Fields{
-			"hkey": fmt.Sprintf("%X", hkey),
-		}).Debug("using mac key")
+		keys.ReloadOnSignal(syscall.SIGHUP)
 
-		err = server.Listen(hkey[:], laddr)
+		err = server.Listen(keys, laddr)
 
 		if err != nil {
 			log.WithFields(log.Fields{
@@ -62,14 +66,44 @@ var serverCmd = &cobra.Command{
 	},
 }
 
+// loadKeyring reads the config file again and loads the keyring from it
+func loadKeyring() (keyring.Keyring, error) {
+	err := viper.ReadInConfig()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Error": err,
+		}).Debug("no config file")
+	}
+
+	return keyring.FromConfig()
+}
+
+// bindServerFlags binds the flags the server shares with the client command.
+// They are bound when the command runs, binding them in init would leave viper reading the client's flags
+func bindServerFlags(cmd *cobra.Command, args []string) {
+	bindKeyFlags(cmd, args)
+
+	viper.BindPFlag("batch", cmd.Flags().Lookup("batch"))
+	viper.BindPFlag("socket_buffer", cmd.Flags().Lookup("socket-buffer"))
+	viper.BindPFlag("workers", cmd.Flags().Lookup("workers"))
+}
+
 func init() {
 	serverCmd.Flags().StringP("local", "l", ":6666", "Local address to listen on")
-	serverCmd.Flags().StringP("key", "k", "", "Key to use for HMAC")
+	addKeyFlags(serverCmd)
 	serverCmd.Flags().Duration("cull-time", time.Minute*10, "time between culling server stats")
+	serverCmd.Flags().Duration("max-skew", time.Minute, "Reject packets timestamped further than this from the server's clock")
+	serverCmd.Flags().Bool("allow-no-session", false, "Accept packets from old clients that don't send a session, without replay protection")
+	serverCmd.Flags().Bool("require-encryption", false, "Reject packets that aren't encrypted")
+	serverCmd.Flags().Int("batch", 32, "Most packets to receive or ack with one system call, 1 to disable batching")
+	serverCmd.Flags().Int("socket-buffer", 4<<20, "Size of the socket's send & receive buffers in bytes, capped by the OS (0 for the OS default)")
+	serverCmd.Flags().Int("workers", 1, "Number of workers receiving packets, each with its own SO_REUSEPORT socket on Linux (0 for one per CPU)")
 
 	viper.BindPFlag("local", serverCmd.Flags().Lookup("local"))
-	viper.BindPFlag("key", serverCmd.Flags().Lookup("key"))
 	viper.BindPFlag("cull_time", serverCmd.Flags().Lookup("cull-time"))
+	viper.BindPFlag("max_skew", serverCmd.Flags().Lookup("max-skew"))
+	viper.BindPFlag("allow_no_session", serverCmd.Flags().Lookup("allow-no-session"))
+	viper.BindPFlag("require_encryption", serverCmd.Flags().Lookup("require-encryption"))
 
 	rootCmd.AddCommand(serverCmd)
 }
@@ -0,0 +1,316 @@
+package keyring
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Keyring looks up the keys clients authenticate their packets with
+type Keyring interface {
+	// Key returns the legacy key for clientID, or false if the client has no legacy key
+	Key(clientID string) ([]byte, bool)
+
+	// Keys returns every legacy key in the keyring. Legacy encrypted packets don't reveal their ClientID, so each key is tried
+	Keys() [][]byte
+
+	// ByID returns the key with a key ID, or false if there is no such key
+	ByID(id uint32) (Key, bool)
+}
+
+// Key is a MAC key ready for use by the wrapper package.
+// Legacy keys have an ID of 0 and are sent without one, other keys carry their ID in every packet
+type Key struct {
+	ID  uint32
+	MAC []byte
+
+	// ClientID is the only client allowed to use the key, or empty if any client may
+	ClientID string
+}
+
+// keySalt & keyInfo separate packetloss keys from any other use of the same secret
+const keySalt = "packetloss key id "
+const keyInfo = "packetloss packet mac key"
+
+// Derive turns a configured secret into the MAC key for a key without an ID, with HKDF-SHA256 like DeriveID.
+// ID 0 is reserved for keys without an ID, so no key with an ID derives the same MAC key from the same secret
+func Derive(secret string) []byte {
+	return DeriveID(secret, 0)
+}
+
+// DeriveLegacy turns a configured secret into a MAC key the way packetloss did before HKDF, with BLAKE2b-512.
+// It is only used for keys without an ID when legacy_keys is set, to talk to older clients & servers
+func DeriveLegacy(secret string) []byte {
+	hkey := blake2b.Sum512([]byte(secret))
+	return hkey[:]
+}
+
+// deriveNoID derives the MAC key for a key without an ID, with DeriveLegacy if legacy_keys is set and Derive if not
+func deriveNoID(secret string) []byte {
+	if viper.GetBool("legacy_keys") {
+		return DeriveLegacy(secret)
+	}
+
+	return Derive(secret)
+}
+
+// DeriveID turns a configured secret into the MAC key for key ID id with HKDF-SHA256.
+// The ID is part of the salt, so reusing a secret under another ID still gives a different key.
+// HKDF expects a high entropy secret like the ones from packetloss keygen, it does not make weak passwords stronger
+func DeriveID(secret string, id uint32) []byte {
+	salt := make([]byte, len(keySalt)+4)
+	copy(salt, keySalt)
+	binary.BigEndian.PutUint32(salt[len(keySalt):], id)
+
+	mac := make([]byte, blake2b.Size)
+	_, err := io.ReadFull(hkdf.New(sha256.New, []byte(secret), salt, []byte(keyInfo)), mac)
+	if err != nil {
+		// HKDF-SHA256 can produce up to 8160 bytes, it can't run out for 64
+		panic(err)
+	}
+
+	return mac
+}
+
+// NewKey derives the key for secret, using DeriveID if id isn't 0. Keys without an ID use Derive, or DeriveLegacy
+// if legacy_keys is set
+func NewKey(secret string, id uint32) Key {
+	if id == 0 {
+		return Key{MAC: deriveNoID(secret)}
+	}
+
+	return Key{
+		ID:  id,
+		MAC: DeriveID(secret, id),
+	}
+}
+
+// Ring is a Keyring. It can hold one legacy key shared by every client or legacy keys for each client,
+// and any number of keys with IDs. Several IDs can be valid at once, so keys can be rotated with an overlap
+type Ring struct {
+	shared    []byte
+	perClient map[string][]byte
+	byID      map[uint32]Key
+}
+
+// NewRing creates a new, empty Ring object
+func NewRing() *Ring {
+	return &Ring{
+		perClient: make(map[string][]byte),
+		byID:      make(map[uint32]Key),
+	}
+}
+
+// Key implements Keyring
+func (r *Ring) Key(clientID string) ([]byte, bool) {
+	if len(r.perClient) != 0 {
+		key, ok := r.perClient[clientID]
+		return key, ok
+	}
+
+	return r.shared, r.shared != nil
+}
+
+// Keys implements Keyring
+func (r *Ring) Keys() [][]byte {
+	if len(r.perClient) == 0 {
+		if r.shared == nil {
+			return nil
+		}
+
+		return [][]byte{r.shared}
+	}
+
+	keys := make([][]byte, 0, len(r.perClient))
+	for _, key := range r.perClient {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// ByID implements Keyring
+func (r *Ring) ByID(id uint32) (Key, bool) {
+	key, ok := r.byID[id]
+	return key, ok
+}
+
+// Empty returns true if the ring has no keys at all
+func (r *Ring) Empty() bool {
+	return r.shared == nil && len(r.perClient) == 0 && len(r.byID) == 0
+}
+
+// ClientKey is one entry in the keys list of packetloss.yml
+// entries without an ID are legacy keys and need a ClientID, entries with an ID are only limited to ClientID if it is set
+type ClientKey struct {
+	ClientID string `mapstructure:"client_id"`
+	ID       uint32 `mapstructure:"id"`
+	Key      string `mapstructure:"key"`
+}
+
+// FromConfig loads the server's keyring.
+// key is a legacy key shared by every client unless the keys list has legacy entries, and is also usable under key_id if
+// that is set. Entries in the keys list are added as described by ClientKey.
+// It refuses to load an empty keyring or an empty key unless insecure is set
+func FromConfig() (Keyring, error) {
+	var clientKeys []ClientKey
+	err := viper.UnmarshalKey("keys", &clientKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	insecure := viper.GetBool("insecure")
+	secret := viper.GetString("key")
+	r := NewRing()
+
+	for _, ck := range clientKeys {
+		if ck.Key == "" {
+			return nil, fmt.Errorf("keys entry for client %q id %d has no key", ck.ClientID, ck.ID)
+		}
+
+		if ck.ID != 0 {
+			if _, ok := r.byID[ck.ID]; ok {
+				return nil, fmt.Errorf("key id %d is used more than once", ck.ID)
+			}
+
+			key := NewKey(ck.Key, ck.ID)
+			key.ClientID = ck.ClientID
+			r.byID[ck.ID] = key
+
+			continue
+		}
+
+		if ck.ClientID == "" {
+			return nil, fmt.Errorf("keys entry has no client_id or id")
+		}
+
+		if _, ok := r.perClient[ck.ClientID]; ok {
+			return nil, fmt.Errorf("client %q has more than one key without an id", ck.ClientID)
+		}
+
+		r.perClient[ck.ClientID] = deriveNoID(ck.Key)
+	}
+
+	if secret != "" || (insecure && r.Empty()) {
+		if len(r.perClient) == 0 {
+			r.shared = deriveNoID(secret)
+		}
+
+		if id := viper.GetUint32("key_id"); id != 0 {
+			if _, ok := r.byID[id]; ok {
+				return nil, fmt.Errorf("key id %d is used more than once", id)
+			}
+
+			r.byID[id] = NewKey(secret, id)
+		}
+	}
+
+	if r.Empty() {
+		return nil, fmt.Errorf("no keys configured, set key or keys, or use --insecure to run with an empty key")
+	}
+
+	return r, nil
+}
+
+// ClientFromConfig loads the client's key from key & key_id.
+// It refuses to use an empty key unless insecure is set
+func ClientFromConfig() (Key, error) {
+	secret := viper.GetString("key")
+	if secret == "" && !viper.GetBool("insecure") {
+		return Key{}, fmt.Errorf("no key configured, set key or use --insecure to run with an empty key")
+	}
+
+	return NewKey(secret, viper.GetUint32("key_id")), nil
+}
+
+// Reloadable is a Keyring that can be swapped for a freshly loaded one while it is in use
+type Reloadable struct {
+	mu      sync.RWMutex
+	current Keyring
+
+	load func() (Keyring, error)
+}
+
+// NewReloadable creates a new Reloadable object, using load to load the keyring now and on every reload
+func NewReloadable(load func() (Keyring, error)) (*Reloadable, error) {
+	kr, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reloadable{
+		current: kr,
+		load:    load,
+	}, nil
+}
+
+// Key implements Keyring
+func (r *Reloadable) Key(clientID string) ([]byte, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.current.Key(clientID)
+}
+
+// Keys implements Keyring
+func (r *Reloadable) Keys() [][]byte {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.current.Keys()
+}
+
+// ByID implements Keyring
+func (r *Reloadable) ByID(id uint32) (Key, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.current.ByID(id)
+}
+
+// Reload loads the keyring again. If loading fails the current keyring is kept
+func (r *Reloadable) Reload() error {
+	kr, err := r.load()
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.current = kr
+	r.mu.Unlock()
+
+	return nil
+}
+
+// ReloadOnSignal reloads the keyring whenever one of sigs is received
+func (r *Reloadable) ReloadOnSignal(sigs ...os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+
+	go func() {
+		for sig := range ch {
+			err := r.Reload()
+			if err != nil {
+				log.WithFields(log.Fields{
+					"Error":  err,
+					"Signal": sig,
+				}).Error("could not reload keyring, keeping the old one")
+
+				continue
+			}
+
+			log.WithFields(log.Fields{
+				"Signal": sig,
+			}).Info("reloaded keyring")
+		}
+	}()
+}
@@ -0,0 +1,63 @@
+package keyring
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestDerive(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	noID := Derive("secret")
+	if bytes.Equal(noID, DeriveLegacy("secret")) {
+		t.Error("keys without an ID are still derived with BLAKE2b")
+	}
+
+	if bytes.Equal(noID, DeriveID("secret", 1)) {
+		t.Error("a key without an ID derives the same MAC key as key ID 1")
+	}
+
+	if !bytes.Equal(NewKey("secret", 0).MAC, noID) {
+		t.Error("NewKey did not derive a key without an ID with HKDF")
+	}
+
+	viper.Set("legacy_keys", true)
+
+	if !bytes.Equal(NewKey("secret", 0).MAC, DeriveLegacy("secret")) {
+		t.Error("NewKey did not use the legacy derivation with legacy_keys set")
+	}
+
+	if !bytes.Equal(NewKey("secret", 1).MAC, DeriveID("secret", 1)) {
+		t.Error("legacy_keys changed the derivation of a key with an ID")
+	}
+}
+
+func TestFromConfigLegacyKeys(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	viper.Set("key", "secret")
+
+	kr, err := FromConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, _ := kr.Key("client")
+	if !bytes.Equal(key, Derive("secret")) {
+		t.Error("shared key was not derived with HKDF")
+	}
+
+	viper.Set("legacy_keys", true)
+
+	kr, err = FromConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, _ = kr.Key("client")
+	if !bytes.Equal(key, DeriveLegacy("secret")) {
+		t.Error("shared key was not derived with BLAKE2b with legacy_keys set")
+	}
+}
@@ -0,0 +1,124 @@
+package mesh
+
+import (
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stormentt/packetloss/keyring"
+)
+
+// peerConnBuffer is how many packets a peerConn holds before dropping them, like a socket's receive buffer
+const peerConnBuffer = 256
+
+// peerConn is a client.PacketConn for one peer on the node's shared socket.
+// Writes go straight to the peer, reads return the acks the server side of the node passes on from it
+type peerConn struct {
+	sock *net.UDPConn
+	addr *net.UDPAddr
+
+	// key is what the acks from the peer are protected with, session is the session the client started with the peer
+	key     keyring.Key
+	session uint64
+
+	in        chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func newPeerConn(sock *net.UDPConn, addr *net.UDPAddr, key keyring.Key) *peerConn {
+	return &peerConn{
+		sock:   sock,
+		addr:   addr,
+		key:    key,
+		in:     make(chan []byte, peerConnBuffer),
+		closed: make(chan struct{}),
+	}
+}
+
+// deliver queues a copy of data to be read, or drops it if pc is closed or its queue is full
+func (pc *peerConn) deliver(data []byte) {
+	select {
+	case <-pc.closed:
+		return
+	default:
+	}
+
+	buf := make([]byte, len(data))
+	copy(buf, data)
+
+	select {
+	case pc.in <- buf:
+	default:
+		log.WithFields(log.Fields{
+			"addr": pc.addr,
+		}).Warn("dropped a packet from a peer, its queue is full")
+	}
+}
+
+// Read returns the next packet from the peer. A deadline set while Read is waiting only applies to later reads
+func (pc *peerConn) Read(b []byte) (int, error) {
+	pc.mu.Lock()
+	deadline := pc.deadline
+	pc.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return 0, os.ErrDeadlineExceeded
+		}
+
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		timeout = timer.C
+	}
+
+	select {
+	case data := <-pc.in:
+		return copy(b, data), nil
+	case <-timeout:
+		return 0, os.ErrDeadlineExceeded
+	case <-pc.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+// Write sends b to the peer from the shared socket
+func (pc *peerConn) Write(b []byte) (int, error) {
+	return pc.sock.WriteToUDP(b, pc.addr)
+}
+
+// SetSession makes pc a client.SessionConn, acks for session are passed on to pc whichever address they come from
+func (pc *peerConn) SetSession(session uint64) {
+	atomic.StoreUint64(&pc.session, session)
+}
+
+// Session returns the session pc was last told of, 0 if none
+func (pc *peerConn) Session() uint64 {
+	return atomic.LoadUint64(&pc.session)
+}
+
+func (pc *peerConn) SetReadDeadline(t time.Time) error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	pc.deadline = t
+	return nil
+}
+
+// Close stops reads, the shared socket is left open
+func (pc *peerConn) Close() error {
+	pc.closeOnce.Do(func() {
+		close(pc.closed)
+	})
+
+	return nil
+}
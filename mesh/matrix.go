@@ -0,0 +1,194 @@
+package mesh
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	packet "github.com/stormentt/packetloss/packet"
+	"github.com/stormentt/packetloss/report"
+)
+
+// cell is a measurement in the matrix and when it was made
+type cell struct {
+	*packet.MatrixCell
+	updated time.Time
+}
+
+// matrix is the loss & RTT between every pair of nodes, by the node that measured it and the node it measured.
+// The node's own row is filled in from its client's reports, the other rows from its peers' MATRIXPACKETs
+type matrix struct {
+	self  string
+	nodes []string
+
+	mu   sync.Mutex
+	rows map[string]map[string]cell
+
+	// sent is when each peer sent the row it last merged, so replayed & reordered rows can't replace newer ones
+	sent map[string]time.Time
+}
+
+func newMatrix(self string, nodes []string) *matrix {
+	m := &matrix{
+		self:  self,
+		nodes: nodes,
+		rows:  make(map[string]map[string]cell),
+		sent:  make(map[string]time.Time),
+	}
+
+	for _, node := range nodes {
+		m.rows[node] = make(map[string]cell)
+	}
+
+	return m
+}
+
+// Publish records a client report as this node's measurement of the path to the report's target,
+// which lets the matrix be given to the client as a metrics sink. Intervals without any packets are ignored
+func (m *matrix) Publish(r *report.Report) error {
+	if r.Client == nil || r.Client.Total == 0 {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rows[m.self][r.Target] = cell{
+		MatrixCell: &packet.MatrixCell{
+			Peer:        r.Target,
+			Packets:     r.Client.Total,
+			LossPercent: r.Client.LossPercent,
+			RttAvg:      r.Client.RTT.Avg,
+			RttP99:      r.Client.RTT.P99,
+		},
+		updated: r.Time,
+	}
+
+	return nil
+}
+
+// ownRow returns this node's row, leaving out measurements made before since
+func (m *matrix) ownRow(since time.Time) *packet.MatrixRow {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	row := &packet.MatrixRow{
+		Node: m.self,
+	}
+
+	for _, to := range m.nodes {
+		c, ok := m.rows[m.self][to]
+		if ok && !c.updated.Before(since) {
+			row.Cells = append(row.Cells, c.MatrixCell)
+		}
+	}
+
+	return row
+}
+
+// merge records the row a peer sent in a MATRIXPACKET. Rows are only accepted from the node they describe,
+// and replace older measurements from it. Rows from nodes that aren't in the mesh are ignored
+func (m *matrix) merge(p *packet.Packet) {
+	row := p.Matrix
+	if row == nil || row.Node != p.ClientID || row.Node == m.self {
+		log.WithFields(log.Fields{
+			"ClientID": p.ClientID,
+		}).Warn("received a matrix row that isn't from its node")
+
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cells, ok := m.rows[row.Node]
+	if !ok {
+		log.WithFields(log.Fields{
+			"Node": row.Node,
+		}).Warn("received a matrix row from a node that isn't in the mesh")
+
+		return
+	}
+
+	updated := time.Unix(0, p.ClientSendTime)
+	if !updated.After(m.sent[row.Node]) {
+		return
+	}
+
+	m.sent[row.Node] = updated
+
+	for to := range cells {
+		delete(cells, to)
+	}
+
+	for _, c := range row.Cells {
+		cells[c.Peer] = cell{
+			MatrixCell: c,
+			updated:    updated,
+		}
+	}
+}
+
+// report returns the matrix, leaving out measurements made before since
+func (m *matrix) report(since time.Time) *report.Matrix {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rm := &report.Matrix{
+		Nodes: m.nodes,
+		Cells: []report.MatrixCell{},
+	}
+
+	for _, from := range m.nodes {
+		for _, to := range m.nodes {
+			c, ok := m.rows[from][to]
+			if !ok || c.updated.Before(since) {
+				continue
+			}
+
+			rm.Cells = append(rm.Cells, report.MatrixCell{
+				From:        from,
+				To:          to,
+				Packets:     c.Packets,
+				LossPercent: c.LossPercent,
+				RTTAvg:      c.RttAvg,
+				RTTP99:      c.RttP99,
+				Updated:     c.updated,
+			})
+		}
+	}
+
+	return rm
+}
+
+// printMatrix logs rm one row at a time, every field is a node measured from the row's node.
+// Pairs without a recent measurement are shown as "-"
+func printMatrix(rm *report.Matrix) {
+	cells := make(map[string]report.MatrixCell)
+	for _, c := range rm.Cells {
+		cells[c.From+"\x00"+c.To] = c
+	}
+
+	for _, from := range rm.Nodes {
+		fields := log.Fields{
+			"From": from,
+		}
+
+		for _, to := range rm.Nodes {
+			if to == from {
+				continue
+			}
+
+			c, ok := cells[from+"\x00"+to]
+			if !ok {
+				fields[to] = "-"
+				continue
+			}
+
+			fields[to] = fmt.Sprintf("%.2f%%/%v", c.LossPercent, time.Duration(c.RTTAvg))
+		}
+
+		log.WithFields(fields).Info("Matrix")
+	}
+}
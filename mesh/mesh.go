@@ -0,0 +1,282 @@
+package mesh
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/stormentt/packetloss/client"
+	"github.com/stormentt/packetloss/keyring"
+	"github.com/stormentt/packetloss/metrics"
+	packet "github.com/stormentt/packetloss/packet"
+	"github.com/stormentt/packetloss/report"
+	"github.com/stormentt/packetloss/server"
+	wrapper "github.com/stormentt/packetloss/wrapper"
+)
+
+// staleIntervals is how many update intervals a measurement is kept in the matrix without being refreshed
+const staleIntervals = 3
+
+// node is one node of a full mesh: a server answering every peer and a client probing every peer, sharing one socket
+type node struct {
+	name  string
+	peers []*client.Target
+
+	sock   *net.UDPConn
+	matrix *matrix
+
+	// conns holds the open peerConn of each peer by address, acks from a peer are passed on to it
+	mu    sync.Mutex
+	conns map[netip.AddrPort]*peerConn
+
+	serial uint64
+}
+
+// PeersFromConfig returns every node in the peers list of the config file other than the one called name,
+// as targets to be probed with name as the ClientID. Anything a peer leaves out is taken from the top level config
+func PeersFromConfig(name string) ([]*client.Target, error) {
+	var configs []client.TargetConfig
+	err := viper.UnmarshalKey("peers", &configs)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	remotes := make(map[string]string)
+	peers := make([]*client.Target, 0, len(configs))
+
+	for _, tc := range configs {
+		if tc.Name == "" {
+			return nil, fmt.Errorf("peer %q has no name", tc.Remote)
+		}
+
+		if names[tc.Name] {
+			return nil, fmt.Errorf("peer %q is listed more than once", tc.Name)
+		}
+
+		names[tc.Name] = true
+
+		if tc.Name == name {
+			continue
+		}
+
+		tc.ClientID = name
+
+		t, err := client.NewTarget(tc)
+		if err != nil {
+			return nil, err
+		}
+
+		remote := t.Remote.String()
+		if other, ok := remotes[remote]; ok {
+			return nil, fmt.Errorf("peers %q and %q have the same remote %s", other, t.Name, remote)
+		}
+
+		remotes[remote] = t.Name
+		peers = append(peers, t)
+	}
+
+	if len(peers) == 0 {
+		return nil, fmt.Errorf("no peers other than %q", name)
+	}
+
+	return peers, nil
+}
+
+// Run listens on laddr as the node called name, answering the packets of any client with a key in keys like a server,
+// and probes every peer from the same socket like a client. Peers that can't be reached are retried every retry_interval.
+// Every update-time the node sends its row of the loss & RTT matrix to its peers and outputs the whole matrix.
+// Run only returns if the node can't be started, or the client's run is limited and has ended
+func Run(name string, keys keyring.Keyring, laddr *net.UDPAddr, peers []*client.Target) error {
+	sock, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return err
+	}
+
+	defer sock.Close()
+
+	out, err := report.FromConfig()
+	if err != nil {
+		return err
+	}
+
+	// the server & client sides share the sinks, so they are only set up once
+	sinks, err := metrics.FromConfig()
+	if err != nil {
+		return err
+	}
+
+	defer metrics.Close(sinks)
+
+	nodes := []string{name}
+	for _, t := range peers {
+		nodes = append(nodes, t.Name)
+	}
+
+	n := &node{
+		name:   name,
+		peers:  peers,
+		sock:   sock,
+		matrix: newMatrix(name, nodes),
+		conns:  make(map[netip.AddrPort]*peerConn),
+	}
+
+	go n.share(out)
+
+	done := make(chan error, 2)
+
+	go func() {
+		done <- server.ServeWith([]*net.UDPConn{sock}, keys, server.Options{
+			Other: n.handle,
+			Sinks: sinks,
+		})
+	}()
+
+	go func() {
+		_, err := client.StartWith(peers, client.Options{
+			Dial:          n.dial,
+			Retry:         true,
+			RetryInterval: viper.GetDuration("retry_interval"),
+			Sinks:         append([]metrics.Sink{n.matrix}, sinks...),
+		})
+
+		done <- err
+	}()
+
+	return <-done
+}
+
+// dial opens a peerConn to t on the node's socket, replacing any earlier one
+func (n *node) dial(t *client.Target) (client.PacketConn, error) {
+	pc := newPeerConn(n.sock, t.Remote, t.Key)
+
+	n.mu.Lock()
+	n.conns[addrPort(t.Remote)] = pc
+	n.mu.Unlock()
+
+	return pc, nil
+}
+
+// handle is given the packets the server side of the node doesn't answer. Matrix rows are merged into the matrix,
+// and acks are passed on to the peerConn of the peer that sent them. Acks are protected with the node's key for the
+// peer, which its keyring may not have, so they are routed by address. A peer with more than one address may answer
+// from another one, then its acks are matched to a peer by the key that validates them & their session
+func (n *node) handle(p *packet.Packet, data []byte, addr *net.UDPAddr) {
+	if p != nil && p.PacketType == packet.PacketType_MATRIXPACKET {
+		n.matrix.merge(p)
+		return
+	}
+
+	n.mu.Lock()
+	pc, ok := n.conns[addrPort(addr)]
+	n.mu.Unlock()
+
+	if !ok {
+		pc, ok = n.bySession(data)
+	}
+
+	if !ok {
+		log.WithFields(log.Fields{
+			"addr": addr,
+		}).Warn("received a packet that isn't from a peer or couldn't be validated")
+
+		return
+	}
+
+	pc.deliver(data)
+}
+
+// bySession returns the peerConn whose key validates the ack in data and whose session it is for
+func (n *node) bySession(data []byte) (*peerConn, bool) {
+	n.mu.Lock()
+	conns := make([]*peerConn, 0, len(n.conns))
+	for _, pc := range n.conns {
+		conns = append(conns, pc)
+	}
+	n.mu.Unlock()
+
+	for _, pc := range conns {
+		p := &packet.Packet{}
+		_, err := wrapper.DecodePacket(data, len(data), pc.key, p)
+		if err != nil {
+			continue
+		}
+
+		if p.Session != 0 && p.Session == pc.Session() {
+			return pc, true
+		}
+	}
+
+	return nil, false
+}
+
+// addrPort returns addr as a map key, IPv4 addresses mapped into IPv6 are the same as the IPv4 address
+func addrPort(addr *net.UDPAddr) netip.AddrPort {
+	ap := addr.AddrPort()
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+}
+
+// share sends the node's row of the matrix to every peer every update-time, then outputs the whole matrix.
+// The matrix is written to out as a report, or logged if out is nil
+func (n *node) share(out *report.Writer) {
+	interval := viper.GetDuration("update-time")
+
+	for range time.Tick(interval) {
+		since := time.Now().Add(-staleIntervals * interval)
+
+		row := n.matrix.ownRow(since)
+		for _, t := range n.peers {
+			n.sendRow(t, row)
+		}
+
+		rm := n.matrix.report(since)
+		if out == nil {
+			printMatrix(rm)
+			continue
+		}
+
+		r := report.New(report.RoleMesh, n.name)
+		r.Matrix = rm
+
+		err := out.Write(r)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Error": err,
+			}).Error("could not write report")
+		}
+	}
+}
+
+// sendRow sends row to t in a MATRIXPACKET, protected like the node's other packets to t
+func (n *node) sendRow(t *client.Target, row *packet.MatrixRow) {
+	n.serial++
+
+	p := &packet.Packet{
+		PacketType:     packet.PacketType_MATRIXPACKET,
+		Serial:         n.serial,
+		ClientID:       n.name,
+		ClientSendTime: time.Now().UnixNano(),
+		Matrix:         row,
+	}
+
+	data, err := wrapper.Encode(p, t.Key, t.Mode)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Error": err,
+		}).Error("could not encode matrix packet")
+
+		return
+	}
+
+	_, err = n.sock.WriteToUDP(data, t.Remote)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Error":  err,
+			"Target": t.Name,
+		}).Error("could not send matrix packet")
+	}
+}
@@ -0,0 +1,246 @@
+package mesh
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stormentt/packetloss/client"
+	"github.com/stormentt/packetloss/keyring"
+	"github.com/stormentt/packetloss/metrics"
+	packet "github.com/stormentt/packetloss/packet"
+	"github.com/stormentt/packetloss/server"
+	wrapper "github.com/stormentt/packetloss/wrapper"
+)
+
+// testConfig sets the config a node needs to run, reset once t is done
+func testConfig(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	viper.Set("packet_time", 5*time.Millisecond)
+	viper.Set("schedule", "fixed")
+	viper.Set("count", 20)
+	viper.Set("ack_timeout", time.Second)
+	viper.Set("drain", time.Second)
+	viper.Set("update-time", time.Second)
+	viper.Set("cull_time", time.Minute)
+	viper.Set("hello_retries", 3)
+	viper.Set("hello_timeout", time.Second)
+	viper.Set("max_skew", time.Minute)
+	viper.Set("batch", 1)
+}
+
+func listen(t *testing.T) *net.UDPConn {
+	sock, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { sock.Close() })
+
+	return sock
+}
+
+func newTestNode(name string, sock *net.UDPConn, peers []*client.Target) *node {
+	nodes := []string{name}
+	for _, t := range peers {
+		nodes = append(nodes, t.Name)
+	}
+
+	return &node{
+		name:   name,
+		peers:  peers,
+		sock:   sock,
+		matrix: newMatrix(name, nodes),
+		conns:  make(map[netip.AddrPort]*peerConn),
+	}
+}
+
+// clientKeys is a Keyring of legacy keys for each ClientID
+type clientKeys map[string][]byte
+
+func (ck clientKeys) Key(clientID string) ([]byte, bool) {
+	key, ok := ck[clientID]
+	return key, ok
+}
+
+func (ck clientKeys) Keys() [][]byte {
+	keys := make([][]byte, 0, len(ck))
+	for _, key := range ck {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+func (ck clientKeys) ByID(id uint32) (keyring.Key, bool) {
+	return keyring.Key{}, false
+}
+
+// ring returns a keyring with a legacy key for each ClientID
+func ring(secrets map[string]string) clientKeys {
+	ck := make(clientKeys)
+	for clientID, secret := range secrets {
+		ck[clientID] = keyring.Derive(secret)
+	}
+
+	return ck
+}
+
+// TestPeerKey probes a peer with a key for that peer alone, which the node's own keyring doesn't have.
+// The peer's acks are protected with that key, so they have to reach the client side without the server validating them
+func TestPeerKey(t *testing.T) {
+	testConfig(t)
+
+	sockA := listen(t)
+	sockB := listen(t)
+
+	// b only answers, its keyring has the key a uses for it
+	go server.Serve(sockB, ring(map[string]string{"a": "a to b"}), nil)
+
+	peer, err := client.NewTarget(client.TargetConfig{
+		Name:     "b",
+		Remote:   sockB.LocalAddr().String(),
+		ClientID: "a",
+		Key:      "a to b",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := newTestNode("a", sockA, []*client.Target{peer})
+
+	// a's keyring only has b's key for a, not the one a uses for b
+	go server.ServeWith([]*net.UDPConn{sockA}, ring(map[string]string{"b": "b to a"}), server.Options{
+		Other: n.handle,
+		Sinks: []metrics.Sink{},
+	})
+
+	summaries, err := client.StartWith([]*client.Target{peer}, client.Options{
+		Dial:  n.dial,
+		Sinks: []metrics.Sink{n.matrix},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := summaries[0].Stats
+	if stats.TotalSent != 20 || stats.TotalAcked != 20 {
+		t.Fatalf("sent %d and acked %d packets, expected 20 of each", stats.TotalSent, stats.TotalAcked)
+	}
+}
+
+// ack returns an ack from a peer for session, protected with key
+func ack(t *testing.T, key keyring.Key, session uint64) []byte {
+	data, err := wrapper.Encode(&packet.Packet{
+		PacketType: packet.PacketType_ACKPACKET,
+		ClientID:   "a",
+		Serial:     1,
+		Session:    session,
+	}, key, wrapper.ModeHMAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func received(pc *peerConn) int {
+	return len(pc.in)
+}
+
+func TestHandleRoutesByAddress(t *testing.T) {
+	sock := listen(t)
+	n := newTestNode("a", sock, nil)
+
+	key := keyring.NewKey("a to b", 0)
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 7000}
+
+	pc, _ := n.dial(&client.Target{Name: "b", Remote: addr, Key: key})
+
+	// acks are routed by address even if they don't validate, the client side drops them if they don't
+	n.handle(nil, []byte("not a packet"), addr)
+	if received(pc.(*peerConn)) != 1 {
+		t.Fatal("ack from the peer's address was not passed on")
+	}
+
+	// IPv4 mapped into IPv6 is the same address
+	n.handle(nil, ack(t, key, 1), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1).To16(), Port: 7000})
+	if received(pc.(*peerConn)) != 2 {
+		t.Fatal("ack from the peer's IPv4 mapped address was not passed on")
+	}
+}
+
+// TestHandleRoutesBySession sends acks from addresses that aren't any peer's remote, as a peer with more than one
+// address might
+func TestHandleRoutesBySession(t *testing.T) {
+	sock := listen(t)
+	n := newTestNode("a", sock, nil)
+
+	keyB := keyring.NewKey("a to b", 0)
+	keyC := keyring.NewKey("a to c", 0)
+
+	b, _ := n.dial(&client.Target{Name: "b", Remote: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 7000}, Key: keyB})
+	c, _ := n.dial(&client.Target{Name: "c", Remote: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 7001}, Key: keyC})
+
+	b.(*peerConn).SetSession(10)
+	c.(*peerConn).SetSession(20)
+
+	other := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 7000}
+
+	n.handle(nil, ack(t, keyC, 20), other)
+	if received(b.(*peerConn)) != 0 || received(c.(*peerConn)) != 1 {
+		t.Fatal("ack from another address was not passed on to the peer whose key & session it has")
+	}
+
+	// the right key but the wrong session, or the right session but the wrong key
+	n.handle(nil, ack(t, keyC, 10), other)
+	n.handle(nil, ack(t, keyB, 20), other)
+	n.handle(nil, ack(t, keyring.NewKey("someone else", 0), 10), other)
+	if received(b.(*peerConn)) != 0 || received(c.(*peerConn)) != 1 {
+		t.Fatal("ack that doesn't match a peer's key & session was passed on")
+	}
+}
+
+func TestPeersFromConfig(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	viper.Set("peers", []map[string]interface{}{
+		{"name": "a", "remote": "127.0.0.1:7101"},
+		{"name": "b", "remote": "127.0.0.1:7102", "key": "a to b"},
+		{"name": "c", "remote": "127.0.0.1:7103"},
+	})
+	viper.Set("key", "shared")
+
+	peers, err := PeersFromConfig("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(peers) != 2 || peers[0].Name != "b" || peers[1].Name != "c" {
+		t.Fatalf("expected peers b & c, got %v", peers)
+	}
+
+	for _, p := range peers {
+		if p.ClientID != "a" {
+			t.Errorf("peer %s has ClientID %q, expected the node's name", p.Name, p.ClientID)
+		}
+	}
+
+	if string(peers[0].Key.MAC) == string(peers[1].Key.MAC) {
+		t.Error("peer b's own key was not used")
+	}
+
+	viper.Set("peers", []map[string]interface{}{
+		{"name": "a", "remote": "127.0.0.1:7101"},
+		{"name": "b", "remote": "127.0.0.1:7102"},
+		{"name": "b", "remote": "127.0.0.1:7103"},
+	})
+
+	_, err = PeersFromConfig("a")
+	if err == nil {
+		t.Error("expected an error for a peer listed twice")
+	}
+}
@@ -0,0 +1,282 @@
+package metrics
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"github.com/stormentt/packetloss/netstats"
+	"github.com/stormentt/packetloss/report"
+)
+
+// rttBuckets are the upper bounds of the exported RTT histogram buckets in seconds, 50µs to about 13s
+var rttBuckets = prometheus.ExponentialBuckets(0.00005, 2, 19)
+
+var labels = []string{"client_id", "remote"}
+
+var (
+	clientSentDesc       = prometheus.NewDesc("packetloss_client_sent_total", "Packets sent by the client", labels, nil)
+	clientAckedDesc      = prometheus.NewDesc("packetloss_client_acked_total", "Packets sent by the client and acked in time", labels, nil)
+	clientLostDesc       = prometheus.NewDesc("packetloss_client_lost_total", "Packets sent by the client and never acked", labels, nil)
+	clientLateDesc       = prometheus.NewDesc("packetloss_client_late_total", "Packets acked after the ack timeout", labels, nil)
+	clientUpstreamDesc   = prometheus.NewDesc("packetloss_client_upstream_lost_total", "Packets lost on the way to the server", labels, nil)
+	clientDownstreamDesc = prometheus.NewDesc("packetloss_client_downstream_lost_total", "Acks lost on the way back from the server", labels, nil)
+	clientRTTDesc        = prometheus.NewDesc("packetloss_client_rtt_seconds", "Round trip times of acked packets", labels, nil)
+	clientJitterDesc     = prometheus.NewDesc("packetloss_client_jitter_seconds", "RFC 3550 interarrival jitter of acks", labels, nil)
+
+	serverReceivedDesc   = prometheus.NewDesc("packetloss_server_received_total", "Packets received by the server", labels, nil)
+	serverMissedDesc     = prometheus.NewDesc("packetloss_server_missed_total", "Packets the server inferred were missed", labels, nil)
+	serverReorderedDesc  = prometheus.NewDesc("packetloss_server_reordered_total", "Packets that arrived after a newer packet", labels, nil)
+	serverDuplicatesDesc = prometheus.NewDesc("packetloss_server_duplicates_total", "Packets received more than once", labels, nil)
+	serverReplayedDesc   = prometheus.NewDesc("packetloss_server_replayed_total", "Packets rejected as replays", labels, nil)
+	serverLastSeenDesc   = prometheus.NewDesc("packetloss_server_last_seen_timestamp_seconds", "When the server last heard from the client", labels, nil)
+	serverJitterDesc     = prometheus.NewDesc("packetloss_server_jitter_seconds", "RFC 3550 interarrival jitter of received packets", labels, nil)
+)
+
+// clientSeries are the metrics for one client's packets, as seen by the client
+type clientSeries struct {
+	clientID string
+	remote   string
+
+	sent       uint64
+	acked      uint64
+	lost       uint64
+	late       uint64
+	upstream   uint64
+	downstream uint64
+
+	rtt    *netstats.Histogram
+	jitter time.Duration
+}
+
+// serverSeries are the metrics for one client's packets, as seen by the server
+// the server's stats restart when a client resets, so the highest values since then are kept to turn them into counters
+type serverSeries struct {
+	clientID string
+	remote   string
+
+	received   uint64
+	missed     uint64
+	reordered  uint64
+	duplicates uint64
+	replayed   uint64
+
+	// high holds the highest counts since the client's last reset. Missed goes down when a packet counted as missed
+	// turns up reordered, so counts only grow the counters once they pass their highest value
+	high report.ServerReport
+	last report.ServerReport
+}
+
+// Exporter turns reports into Prometheus metrics.
+// Counters only ever go up, no matter how often the client's or server's own stats are reset
+type Exporter struct {
+	mu sync.Mutex
+
+	clients map[string]*clientSeries
+	servers map[string]*serverSeries
+}
+
+// NewExporter creates a new Exporter object
+func NewExporter() *Exporter {
+	return &Exporter{
+		clients: make(map[string]*clientSeries),
+		servers: make(map[string]*serverSeries),
+	}
+}
+
+// Serve returns an Exporter serving /metrics on addr. It fails if addr can't be listened on
+func Serve(addr string) (*Exporter, error) {
+	e := NewExporter()
+
+	registry := prometheus.NewRegistry()
+	err := registry.Register(e)
+	if err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	srv := &http.Server{
+		Handler: mux,
+	}
+
+	log.WithFields(log.Fields{
+		"Address": ln.Addr(),
+	}).Info("serving metrics")
+
+	go func() {
+		err := srv.Serve(ln)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.WithFields(log.Fields{
+				"Error":   err,
+				"Address": addr,
+			}).Error("metrics listener stopped")
+		}
+	}()
+
+	return e, nil
+}
+
+// Publish updates the metrics from a report
+// client summaries are skipped, their packets were already counted by the interval reports
+func (e *Exporter) Publish(r *report.Report) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if r.Summary {
+		return nil
+	}
+
+	if r.Client != nil {
+		key := r.ClientID + "\x00" + r.Remote
+
+		series, ok := e.clients[key]
+		if !ok {
+			series = &clientSeries{
+				clientID: r.ClientID,
+				remote:   r.Remote,
+				rtt:      netstats.NewHistogram(),
+			}
+			e.clients[key] = series
+		}
+
+		series.sent += r.Client.Sent
+		series.acked += r.Client.SentAndAcked
+		series.lost += r.Client.SentNotAcked
+		series.late += r.Client.Late
+		series.upstream += r.Client.Upstream.Lost
+		series.downstream += r.Client.Downstream.Lost
+		series.rtt.Merge(r.Client.RTT.Histogram)
+		series.jitter = time.Duration(r.Client.Variation.Jitter)
+	}
+
+	if r.Server != nil {
+		// the server only keeps one set of stats per client, so a client that changes address keeps its series
+		series, ok := e.servers[r.ClientID]
+		if !ok {
+			series = &serverSeries{
+				clientID: r.ClientID,
+			}
+			e.servers[r.ClientID] = series
+		}
+
+		series.remote = r.Remote
+
+		// the server's stats were reset if the session changed, or it has fewer packets than before
+		if r.Server.Session != series.high.Session || r.Server.Received < series.high.Received {
+			series.high = report.ServerReport{Session: r.Server.Session}
+		}
+
+		series.received += grow(&series.high.Received, r.Server.Received)
+		series.missed += grow(&series.high.Missed, r.Server.Missed)
+		series.reordered += grow(&series.high.Reordered, r.Server.Reordered)
+		series.duplicates += grow(&series.high.Duplicates, r.Server.Duplicates)
+		series.replayed += grow(&series.high.Replayed, r.Server.Replayed)
+		series.last = *r.Server
+	}
+
+	return nil
+}
+
+// Forget removes the server's series for clientID, once the server has forgotten the client
+func (e *Exporter) Forget(clientID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.servers, clientID)
+}
+
+// grow returns how far current is past the highest value a stat has had, and raises the highest value to current
+func grow(high *uint64, current uint64) uint64 {
+	if current <= *high {
+		return 0
+	}
+
+	d := current - *high
+	*high = current
+
+	return d
+}
+
+// Describe implements prometheus.Collector
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- clientSentDesc
+	ch <- clientAckedDesc
+	ch <- clientLostDesc
+	ch <- clientLateDesc
+	ch <- clientUpstreamDesc
+	ch <- clientDownstreamDesc
+	ch <- clientRTTDesc
+	ch <- clientJitterDesc
+
+	ch <- serverReceivedDesc
+	ch <- serverMissedDesc
+	ch <- serverReorderedDesc
+	ch <- serverDuplicatesDesc
+	ch <- serverReplayedDesc
+	ch <- serverLastSeenDesc
+	ch <- serverJitterDesc
+}
+
+// Collect implements prometheus.Collector
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, s := range e.clients {
+		ch <- prometheus.MustNewConstMetric(clientSentDesc, prometheus.CounterValue, float64(s.sent), s.clientID, s.remote)
+		ch <- prometheus.MustNewConstMetric(clientAckedDesc, prometheus.CounterValue, float64(s.acked), s.clientID, s.remote)
+		ch <- prometheus.MustNewConstMetric(clientLostDesc, prometheus.CounterValue, float64(s.lost), s.clientID, s.remote)
+		ch <- prometheus.MustNewConstMetric(clientLateDesc, prometheus.CounterValue, float64(s.late), s.clientID, s.remote)
+		ch <- prometheus.MustNewConstMetric(clientUpstreamDesc, prometheus.CounterValue, float64(s.upstream), s.clientID, s.remote)
+		ch <- prometheus.MustNewConstMetric(clientDownstreamDesc, prometheus.CounterValue, float64(s.downstream), s.clientID, s.remote)
+		ch <- prometheus.MustNewConstMetric(clientJitterDesc, prometheus.GaugeValue, s.jitter.Seconds(), s.clientID, s.remote)
+
+		count, sum, buckets := promBuckets(s.rtt)
+		ch <- prometheus.MustNewConstHistogram(clientRTTDesc, count, sum, buckets, s.clientID, s.remote)
+	}
+
+	for _, s := range e.servers {
+		ch <- prometheus.MustNewConstMetric(serverReceivedDesc, prometheus.CounterValue, float64(s.received), s.clientID, s.remote)
+		ch <- prometheus.MustNewConstMetric(serverMissedDesc, prometheus.CounterValue, float64(s.missed), s.clientID, s.remote)
+		ch <- prometheus.MustNewConstMetric(serverReorderedDesc, prometheus.CounterValue, float64(s.reordered), s.clientID, s.remote)
+		ch <- prometheus.MustNewConstMetric(serverDuplicatesDesc, prometheus.CounterValue, float64(s.duplicates), s.clientID, s.remote)
+		ch <- prometheus.MustNewConstMetric(serverReplayedDesc, prometheus.CounterValue, float64(s.replayed), s.clientID, s.remote)
+		ch <- prometheus.MustNewConstMetric(serverLastSeenDesc, prometheus.GaugeValue, float64(s.last.LastUpdate.UnixNano())/1e9, s.clientID, s.remote)
+		ch <- prometheus.MustNewConstMetric(serverJitterDesc, prometheus.GaugeValue, time.Duration(s.last.Variation.Jitter).Seconds(), s.clientID, s.remote)
+	}
+}
+
+// promBuckets converts a histogram into cumulative Prometheus buckets
+// a histogram bucket is counted under the first Prometheus bucket its upper bound fits in
+func promBuckets(h *netstats.Histogram) (uint64, float64, map[float64]uint64) {
+	buckets := make(map[float64]uint64, len(rttBuckets))
+	for _, le := range rttBuckets {
+		buckets[le] = 0
+	}
+
+	for _, b := range h.Buckets() {
+		high := time.Duration(b.High).Seconds()
+
+		for _, le := range rttBuckets {
+			if high <= le {
+				buckets[le] += b.Count
+			}
+		}
+	}
+
+	sum := h.Mean().Seconds() * float64(h.Count())
+
+	return h.Count(), sum, buckets
+}
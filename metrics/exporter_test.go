@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stormentt/packetloss/report"
+)
+
+func serverReport(session, received, missed uint64) *report.Report {
+	r := report.New(report.RoleServer, "client")
+	r.Server = &report.ServerReport{
+		Session:  session,
+		Received: received,
+		Missed:   missed,
+	}
+
+	return r
+}
+
+func TestExporterServerCounters(t *testing.T) {
+	e := NewExporter()
+
+	steps := []struct {
+		name string
+		r    *report.Report
+
+		received uint64
+		missed   uint64
+	}{
+		{"first report", serverReport(1, 10, 3), 10, 3},
+		{"reordered packet lowers missed", serverReport(1, 12, 2), 12, 3},
+		{"missed grows past its high", serverReport(1, 15, 4), 15, 4},
+		{"new session", serverReport(2, 5, 1), 20, 5},
+		{"stats reset without a session change", serverReport(2, 2, 0), 22, 5},
+	}
+
+	for _, step := range steps {
+		err := e.Publish(step.r)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+
+		series := e.servers["client"]
+		if series.received != step.received || series.missed != step.missed {
+			t.Errorf("%s: got received %d, missed %d, want %d, %d", step.name, series.received, series.missed, step.received, step.missed)
+		}
+	}
+
+	e.Forget("client")
+	if len(e.servers) != 0 {
+		t.Errorf("got %d server series after forgetting the client, want 0", len(e.servers))
+	}
+}
+
+func TestServeBindError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ln.Close()
+
+	_, err = Serve(ln.Addr().String())
+	if err == nil {
+		t.Error("serving on an address in use succeeded")
+	}
+}
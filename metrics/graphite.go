@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/stormentt/packetloss/report"
+)
+
+// Graphite sends reports to a Graphite (carbon) plaintext listener.
+// The connection is kept open between reports, and made again if writing to it fails
+type Graphite struct {
+	addr   string
+	prefix string
+
+	conn net.Conn
+}
+
+// NewGraphite creates a new Graphite object sending to addr, with every metric name starting with prefix
+func NewGraphite(addr, prefix string) (*Graphite, error) {
+	_, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Graphite{
+		addr:   addr,
+		prefix: prefix,
+	}, nil
+}
+
+// Publish implements Sink
+func (g *Graphite) Publish(r *report.Report) error {
+	path := metricPath(g.prefix, r)
+	ts := strconv.FormatInt(r.Time.Unix(), 10)
+
+	var b bytes.Buffer
+	for _, p := range points(r) {
+		b.WriteString(path + "." + p.Name + " " + strconv.FormatFloat(p.Value, 'f', -1, 64) + " " + ts + "\n")
+	}
+
+	// carbon doesn't answer, so a connection it has closed is only noticed when a write fails. Try once more on a new one
+	err := g.write(b.Bytes())
+	if err != nil {
+		err = g.write(b.Bytes())
+	}
+
+	return err
+}
+
+// write writes data to the connection, connecting first if there isn't one. The connection is closed if writing fails
+func (g *Graphite) write(data []byte) error {
+	if g.conn == nil {
+		conn, err := net.DialTimeout("tcp", g.addr, sinkTimeout)
+		if err != nil {
+			return err
+		}
+
+		g.conn = conn
+	}
+
+	g.conn.SetWriteDeadline(time.Now().Add(sinkTimeout))
+
+	_, err := g.conn.Write(data)
+	if err != nil {
+		g.conn.Close()
+		g.conn = nil
+	}
+
+	return err
+}
+
+// Close closes the connection
+func (g *Graphite) Close() error {
+	if g.conn == nil {
+		return nil
+	}
+
+	err := g.conn.Close()
+	g.conn = nil
+
+	return err
+}
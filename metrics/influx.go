@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/stormentt/packetloss/report"
+)
+
+// influxEscaper escapes tag keys & values in the InfluxDB line protocol
+var influxEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// influxLine formats r as a single line of InfluxDB line protocol, tagged with the client ID, remote address, and target
+func influxLine(r *report.Report) []byte {
+	var b bytes.Buffer
+
+	b.WriteString(measurement(r))
+	b.WriteString(",client_id=")
+	b.WriteString(influxEscaper.Replace(r.ClientID))
+	if r.Remote != "" {
+		b.WriteString(",remote=")
+		b.WriteString(influxEscaper.Replace(r.Remote))
+	}
+
+	if r.Target != "" {
+		b.WriteString(",target=")
+		b.WriteString(influxEscaper.Replace(r.Target))
+	}
+
+	for i, p := range points(r) {
+		if i == 0 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteByte(',')
+		}
+
+		b.WriteString(p.Name)
+		b.WriteByte('=')
+
+		if p.Integer {
+			b.WriteString(strconv.FormatInt(int64(p.Value), 10))
+			b.WriteByte('i')
+		} else {
+			b.WriteString(strconv.FormatFloat(p.Value, 'f', -1, 64))
+		}
+	}
+
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatInt(r.Time.UnixNano(), 10))
+	b.WriteByte('\n')
+
+	return b.Bytes()
+}
+
+// InfluxHTTP writes reports to an InfluxDB HTTP write endpoint
+type InfluxHTTP struct {
+	url   string
+	token string
+
+	client *http.Client
+}
+
+// NewInfluxHTTP creates a new InfluxHTTP object
+// url is the full write URL, such as http://localhost:8086/api/v2/write?org=o&bucket=b or http://localhost:8086/write?db=d.
+// token is sent as an API token if it isn't empty
+func NewInfluxHTTP(url, token string) (*InfluxHTTP, error) {
+	if url == "" {
+		return nil, fmt.Errorf("influx-http sink needs a url")
+	}
+
+	return &InfluxHTTP{
+		url:   url,
+		token: token,
+		client: &http.Client{
+			Timeout: sinkTimeout,
+		},
+	}, nil
+}
+
+// Publish implements Sink
+func (ih *InfluxHTTP) Publish(r *report.Report) error {
+	req, err := http.NewRequest(http.MethodPost, ih.url, bytes.NewReader(influxLine(r)))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if ih.token != "" {
+		req.Header.Set("Authorization", "Token "+ih.token)
+	}
+
+	resp, err := ih.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("influxdb write failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// InfluxUDP writes reports to an InfluxDB UDP listener
+type InfluxUDP struct {
+	conn net.Conn
+}
+
+// NewInfluxUDP creates a new InfluxUDP object sending to addr
+func NewInfluxUDP(addr string) (*InfluxUDP, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &InfluxUDP{
+		conn: conn,
+	}, nil
+}
+
+// Publish implements Sink
+func (iu *InfluxUDP) Publish(r *report.Report) error {
+	_, err := iu.conn.Write(influxLine(r))
+	return err
+}
@@ -0,0 +1,305 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/stormentt/packetloss/report"
+)
+
+// Sink receives the report for every client at the end of every interval
+type Sink interface {
+	Publish(r *report.Report) error
+}
+
+// Forgetter is a Sink that keeps state for each client, which can be dropped once the server forgets the client
+type Forgetter interface {
+	Forget(clientID string)
+}
+
+// SinkConfig is one entry in the sinks list of packetloss.yml
+type SinkConfig struct {
+	// Type is one of influx-http, influx-udp, statsd, or graphite
+	Type string `mapstructure:"type"`
+
+	// URL is the InfluxDB write endpoint for influx-http, Address is host:port for every other type
+	URL     string `mapstructure:"url"`
+	Address string `mapstructure:"address"`
+
+	// Token is sent as an InfluxDB API token if set
+	Token string `mapstructure:"token"`
+
+	// Prefix is prepended to StatsD & Graphite metric names, it defaults to packetloss
+	Prefix string `mapstructure:"prefix"`
+}
+
+// FromConfig returns the Prometheus exporter if metrics_listen is set, followed by every sink in the sinks list.
+// The sinks in the list publish from their own goroutines, see Async. They should be closed with Close once they're done
+func FromConfig() ([]Sink, error) {
+	var sinks []Sink
+
+	if addr := viper.GetString("metrics_listen"); addr != "" {
+		e, err := Serve(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		sinks = append(sinks, e)
+	}
+
+	var configs []SinkConfig
+	err := viper.UnmarshalKey("sinks", &configs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sc := range configs {
+		sink, err := NewSink(sc)
+		if err != nil {
+			return nil, err
+		}
+
+		log.WithFields(log.Fields{
+			"Type":    sc.Type,
+			"URL":     sc.URL,
+			"Address": sc.Address,
+		}).Info("publishing metrics")
+
+		sinks = append(sinks, NewAsync(sink))
+	}
+
+	return sinks, nil
+}
+
+// NewSink creates the sink described by sc
+func NewSink(sc SinkConfig) (Sink, error) {
+	if sc.Prefix == "" {
+		sc.Prefix = "packetloss"
+	}
+
+	switch sc.Type {
+	case "influx-http":
+		return NewInfluxHTTP(sc.URL, sc.Token)
+	case "influx-udp":
+		return NewInfluxUDP(sc.Address)
+	case "statsd":
+		return NewStatsD(sc.Address, sc.Prefix)
+	case "graphite":
+		return NewGraphite(sc.Address, sc.Prefix)
+	default:
+		return nil, fmt.Errorf("unknown sink type %q", sc.Type)
+	}
+}
+
+// Publish sends r to every sink, logging any that fail
+// summaries are not published, their packets were already published by the interval reports
+func Publish(sinks []Sink, r *report.Report) {
+	if r.Summary {
+		return
+	}
+
+	for _, sink := range sinks {
+		err := sink.Publish(r)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Error": err,
+				"Sink":  fmt.Sprintf("%T", sink),
+			}).Error("could not publish metrics")
+		}
+	}
+}
+
+// Close closes every sink that needs closing, publishing anything they still have queued
+func Close(sinks []Sink) {
+	for _, sink := range sinks {
+		if c, ok := sink.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+// Forget tells every sink that keeps state for each client that the server has forgotten clientID
+func Forget(sinks []Sink, clientID string) {
+	for _, sink := range sinks {
+		if f, ok := sink.(Forgetter); ok {
+			f.Forget(clientID)
+		}
+	}
+}
+
+// point is a single value from a report
+type point struct {
+	Name  string
+	Value float64
+
+	// Integer values are whole numbers. Counter values count events during the interval,
+	// everything else is a gauge holding the current value
+	Integer bool
+	Counter bool
+}
+
+// points flattens r into the values the push sinks send. Durations are in nanoseconds, like the JSON report
+// client reports cover one interval so their counts are counters, server reports are totals since the client's last reset
+func points(r *report.Report) []point {
+	count := func(name string, v uint64, counter bool) point {
+		return point{Name: name, Value: float64(v), Integer: true, Counter: counter}
+	}
+
+	gauge := func(name string, v float64) point {
+		return point{Name: name, Value: v}
+	}
+
+	duration := func(name string, ns int64) point {
+		return point{Name: name, Value: float64(ns), Integer: true}
+	}
+
+	var ps []point
+
+	if c := r.Client; c != nil {
+		ps = append(ps,
+			count("total", c.Total, true),
+			count("sent", c.Sent, true),
+			count("acked", c.Acked, true),
+			count("sent_and_acked", c.SentAndAcked, true),
+			count("sent_not_acked", c.SentNotAcked, true),
+			count("acked_not_sent", c.AckedNotSent, true),
+			count("late", c.Late, true),
+			count("upstream_lost", c.Upstream.Lost, true),
+			count("downstream_lost", c.Downstream.Lost, true),
+			count("loss_runs", c.LossRuns.Runs, true),
+			count("longest_run", c.LossRuns.LongestRun, false),
+			gauge("loss_percent", c.LossPercent),
+			gauge("late_percent", c.LatePercent),
+			duration("rtt_avg_ns", c.RTT.Avg),
+			duration("rtt_min_ns", c.RTT.Min),
+			duration("rtt_max_ns", c.RTT.Max),
+			duration("rtt_stddev_ns", c.RTT.StdDev),
+			duration("rtt_p50_ns", c.RTT.P50),
+			duration("rtt_p90_ns", c.RTT.P90),
+			duration("rtt_p99_ns", c.RTT.P99),
+			duration("rtt_p999_ns", c.RTT.P999),
+			duration("forward_avg_ns", c.Forward.Avg),
+			duration("reverse_avg_ns", c.Reverse.Avg),
+			duration("jitter_ns", c.Variation.Jitter),
+			duration("ipdv_mean_abs_ns", c.Variation.IPDVMeanAbs),
+		)
+	}
+
+	if s := r.Server; s != nil {
+		ps = append(ps,
+			count("received", s.Received, false),
+			count("missed", s.Missed, false),
+			count("reordered", s.Reordered, false),
+			count("duplicates", s.Duplicates, false),
+			count("replayed", s.Replayed, false),
+			count("loss_runs", s.LossRuns.Runs, false),
+			count("longest_run", s.LossRuns.LongestRun, false),
+			gauge("loss_percent", s.LossPercent),
+			gauge("reorder_percent", s.ReorderPercent),
+			duration("jitter_ns", s.Variation.Jitter),
+			duration("ipdv_mean_abs_ns", s.Variation.IPDVMeanAbs),
+		)
+	}
+
+	return ps
+}
+
+// measurement returns the name reports from r's role are grouped under
+func measurement(r *report.Report) string {
+	return "packetloss_" + r.Role
+}
+
+// sinkTimeout limits how long a sink can take to publish a report
+const sinkTimeout = 5 * time.Second
+
+// sinkQueueSize is how many reports can wait for a sink before new reports are dropped
+const sinkQueueSize = 1024
+
+// errQueueFull is returned when a report is dropped because the sink's queue is full
+var errQueueFull = errors.New("sink is falling behind, its queue is full")
+
+// Async publishes reports to a sink from its own goroutine, so a slow or unreachable sink can't hold up the client's or
+// server's packets. Reports wait in a queue of sinkQueueSize, and are dropped once it is full
+type Async struct {
+	sink  Sink
+	queue chan *report.Report
+	done  chan struct{}
+
+	// mu stops reports being queued while the queue is being closed
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewAsync creates a new Async object publishing to sink
+func NewAsync(sink Sink) *Async {
+	a := &Async{
+		sink:  sink,
+		queue: make(chan *report.Report, sinkQueueSize),
+		done:  make(chan struct{}),
+	}
+
+	go a.run()
+
+	return a
+}
+
+// run publishes queued reports until the queue is closed
+func (a *Async) run() {
+	defer close(a.done)
+
+	for r := range a.queue {
+		err := a.sink.Publish(r)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Error": err,
+				"Sink":  fmt.Sprintf("%T", a.sink),
+			}).Error("could not publish metrics")
+		}
+	}
+}
+
+// Publish implements Sink. It queues r without waiting for it to be published
+func (a *Async) Publish(r *report.Report) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.closed {
+		return net.ErrClosed
+	}
+
+	select {
+	case a.queue <- r:
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+// Close stops queueing reports and waits for the queued ones to be published, for at most sinkTimeout.
+// The sink is closed too if it needs closing
+func (a *Async) Close() error {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+
+	select {
+	case <-a.done:
+	case <-time.After(sinkTimeout):
+		return errors.New("timed out publishing queued reports")
+	}
+
+	if c, ok := a.sink.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
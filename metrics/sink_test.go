@@ -0,0 +1,268 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stormentt/packetloss/report"
+)
+
+// testReport returns a client report for client "edge 1", with 5 packets sent and 4 acked
+func testReport() *report.Report {
+	r := report.New(report.RoleClient, "edge 1")
+	r.Remote = "127.0.0.1:6666"
+	r.Time = time.Unix(1700000000, 0)
+	r.Client = &report.ClientReport{
+		Total:        5,
+		Sent:         5,
+		Acked:        4,
+		SentAndAcked: 4,
+		SentNotAcked: 1,
+		LossPercent:  20,
+	}
+
+	return r
+}
+
+func TestInfluxUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer pc.Close()
+
+	sink, err := NewInfluxUDP(pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sink.Publish(testReport())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	buff := make([]byte, 65536)
+	n, _, err := pc.ReadFrom(buff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line := string(buff[:n])
+	for _, want := range []string{`packetloss_client,client_id=edge\ 1,remote=127.0.0.1:6666 `, "sent=5i", "loss_percent=20", " 1700000000000000000\n"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("line %q does not contain %q", line, want)
+		}
+	}
+}
+
+func TestInfluxHTTP(t *testing.T) {
+	bodies := make(chan string, 1)
+	auth := make(chan string, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		bodies <- string(body)
+		auth <- req.Header.Get("Authorization")
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	sink, err := NewInfluxHTTP(srv.URL+"/api/v2/write?org=o&bucket=b", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sink.Publish(testReport())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body := <-bodies; !strings.HasPrefix(body, "packetloss_client,") || !strings.Contains(body, "acked=4i") {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	if got := <-auth; got != "Token secret" {
+		t.Errorf("got Authorization %q, want %q", got, "Token secret")
+	}
+}
+
+func TestInfluxHTTPError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "bucket not found", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	sink, err := NewInfluxHTTP(srv.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sink.Publish(testReport())
+	if err == nil || !strings.Contains(err.Error(), "bucket not found") {
+		t.Errorf("got error %v, want one including the response", err)
+	}
+}
+
+func TestGraphite(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ln.Close()
+
+	sink, err := NewGraphite(ln.Addr().String(), "packetloss")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sink.Close()
+
+	// both reports should arrive over the same connection
+	for i := 0; i < 2; i++ {
+		err = sink.Publish(testReport())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var sent int
+	scanner := bufio.NewScanner(conn)
+	for sent < 2 && scanner.Scan() {
+		line := scanner.Text()
+		if line == "packetloss.client.edge_1.127_0_0_1_6666.sent 5 1700000000" {
+			sent++
+		}
+	}
+
+	if sent != 2 {
+		t.Errorf("got %d sent lines, want 2: %v", sent, scanner.Err())
+	}
+}
+
+func TestGraphiteReconnects(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ln.Close()
+
+	sink, err := NewGraphite(ln.Addr().String(), "packetloss")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sink.Close()
+
+	err = sink.Publish(testReport())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// carbon going away leaves the sink with a dead connection
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Close()
+
+	// the first write after the close can succeed before the reset comes back, so keep publishing until a new
+	// connection is made
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		sink.Publish(testReport())
+
+		select {
+		case conn := <-accepted:
+			conn.Close()
+			return
+		case <-deadline:
+			t.Fatal("the sink never reconnected")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// blockingSink blocks every Publish until release is closed
+type blockingSink struct {
+	release   chan struct{}
+	published chan *report.Report
+}
+
+func (bs *blockingSink) Publish(r *report.Report) error {
+	<-bs.release
+	bs.published <- r
+	return nil
+}
+
+func TestAsyncDoesNotBlock(t *testing.T) {
+	bs := &blockingSink{
+		release:   make(chan struct{}),
+		published: make(chan *report.Report, sinkQueueSize+2),
+	}
+
+	a := NewAsync(bs)
+
+	start := time.Now()
+	for i := 0; i < sinkQueueSize; i++ {
+		err := a.Publish(testReport())
+		if err != nil {
+			t.Fatalf("report %d: %v", i, err)
+		}
+	}
+
+	if time.Since(start) > time.Second {
+		t.Errorf("publishing to a blocked sink took %v", time.Since(start))
+	}
+
+	// one report is with the sink and the queue is full, so the next can't be queued
+	a.Publish(testReport())
+	err := a.Publish(testReport())
+	if err != errQueueFull {
+		t.Errorf("got %v publishing to a full queue, want errQueueFull", err)
+	}
+
+	close(bs.release)
+
+	err = a.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bs.published) < sinkQueueSize {
+		t.Errorf("got %d reports published after Close, want at least %d", len(bs.published), sinkQueueSize)
+	}
+
+	if err := a.Publish(testReport()); err == nil {
+		t.Error("publishing after Close succeeded")
+	}
+}
@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"bytes"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/stormentt/packetloss/report"
+)
+
+// statsdPacketSize keeps StatsD packets under a typical path MTU so they aren't fragmented
+const statsdPacketSize = 1400
+
+// pathUnsafe matches characters that would break up a dotted StatsD or Graphite metric name
+var pathUnsafe = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// metricPath returns the dotted name prefix for r, such as packetloss.client.<client id>.<remote>
+func metricPath(prefix string, r *report.Report) string {
+	parts := []string{prefix, r.Role, pathUnsafe.ReplaceAllString(r.ClientID, "_")}
+	if r.Remote != "" {
+		parts = append(parts, pathUnsafe.ReplaceAllString(r.Remote, "_"))
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// StatsD sends reports to a StatsD server.
+// Counts from client intervals are sent as counters, everything else as gauges
+type StatsD struct {
+	conn   net.Conn
+	prefix string
+}
+
+// NewStatsD creates a new StatsD object sending to addr, with every metric name starting with prefix
+func NewStatsD(addr, prefix string) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StatsD{
+		conn:   conn,
+		prefix: prefix,
+	}, nil
+}
+
+// Publish implements Sink
+func (sd *StatsD) Publish(r *report.Report) error {
+	path := metricPath(sd.prefix, r)
+
+	var b bytes.Buffer
+	for _, p := range points(r) {
+		kind := "g"
+		if p.Counter {
+			kind = "c"
+		}
+
+		line := path + "." + p.Name + ":" + strconv.FormatFloat(p.Value, 'f', -1, 64) + "|" + kind + "\n"
+
+		if b.Len()+len(line) > statsdPacketSize {
+			_, err := sd.conn.Write(b.Bytes())
+			if err != nil {
+				return err
+			}
+
+			b.Reset()
+		}
+
+		b.WriteString(line)
+	}
+
+	if b.Len() == 0 {
+		return nil
+	}
+
+	_, err := sd.conn.Write(b.Bytes())
+	return err
+}
@@ -0,0 +1,268 @@
+package netstats
+
+import (
+	"encoding/json"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// histogramSubBits sets the precision of a Histogram
+// every power of two range is split into 2^(histogramSubBits-1) buckets, so bucket widths are within 1/64th of their values
+const histogramSubBits = 7
+
+const histogramSubCount = 1 << histogramSubBits
+const histogramHalfCount = histogramSubCount / 2
+
+// Histogram is a log-linear histogram of durations, similar to an HDR histogram.
+// Memory is bounded no matter how many values are recorded, and histograms can be merged together
+type Histogram struct {
+	counts []uint64
+
+	count      uint64
+	sum        float64
+	sumSquares float64
+
+	min time.Duration
+	max time.Duration
+}
+
+// NewHistogram creates a new Histogram object
+func NewHistogram() *Histogram {
+	return &Histogram{}
+}
+
+// Record adds a duration to the histogram, negative durations are recorded as 0
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+
+	idx := bucketIndex(uint64(d))
+	if idx >= len(h.counts) {
+		h.grow(idx + 1)
+	}
+
+	h.counts[idx]++
+
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+
+	if h.count == 0 || d > h.max {
+		h.max = d
+	}
+
+	h.count++
+	h.sum += float64(d)
+	h.sumSquares += float64(d) * float64(d)
+}
+
+// Merge adds every value recorded in other to h
+func (h *Histogram) Merge(other *Histogram) {
+	if other == nil || other.count == 0 {
+		return
+	}
+
+	if len(other.counts) > len(h.counts) {
+		h.grow(len(other.counts))
+	}
+
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+
+	if h.count == 0 || other.min < h.min {
+		h.min = other.min
+	}
+
+	if h.count == 0 || other.max > h.max {
+		h.max = other.max
+	}
+
+	h.count += other.count
+	h.sum += other.sum
+	h.sumSquares += other.sumSquares
+}
+
+// Count returns how many values have been recorded
+func (h *Histogram) Count() uint64 {
+	return h.count
+}
+
+// Min returns the smallest recorded value
+func (h *Histogram) Min() time.Duration {
+	return h.min
+}
+
+// Max returns the largest recorded value
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+// Mean returns the mean of the recorded values
+func (h *Histogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+
+	return time.Duration(h.sum / float64(h.count))
+}
+
+// StdDev returns the population standard deviation of the recorded values
+func (h *Histogram) StdDev() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+
+	mean := h.sum / float64(h.count)
+	variance := h.sumSquares/float64(h.count) - mean*mean
+	if variance < 0 {
+		return 0
+	}
+
+	return time.Duration(math.Sqrt(variance))
+}
+
+// Quantile returns the value at quantile q, where q is between 0 and 1
+// the result is the middle of the bucket the quantile falls in, limited to the recorded min & max
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	if rank == 0 {
+		rank = 1
+	}
+
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen < rank {
+			continue
+		}
+
+		low, high := bucketBounds(i)
+		value := time.Duration(low + (high-low)/2)
+
+		if value < h.min {
+			return h.min
+		}
+
+		if value > h.max {
+			return h.max
+		}
+
+		return value
+	}
+
+	return h.max
+}
+
+// HistogramBucket is how many values between Low and High nanoseconds were recorded
+type HistogramBucket struct {
+	Low   uint64 `json:"low_ns"`
+	High  uint64 `json:"high_ns"`
+	Count uint64 `json:"count"`
+}
+
+type histogramJSON struct {
+	Count      uint64            `json:"count"`
+	Sum        float64           `json:"sum_ns"`
+	SumSquares float64           `json:"sum_squares"`
+	Min        int64             `json:"min_ns"`
+	Max        int64             `json:"max_ns"`
+	Buckets    []HistogramBucket `json:"buckets"`
+}
+
+// Buckets returns every bucket that has values in it, in ascending order
+func (h *Histogram) Buckets() []HistogramBucket {
+	buckets := []HistogramBucket{}
+
+	for i, c := range h.counts {
+		if c == 0 {
+			continue
+		}
+
+		low, high := bucketBounds(i)
+		buckets = append(buckets, HistogramBucket{
+			Low:   low,
+			High:  high,
+			Count: c,
+		})
+	}
+
+	return buckets
+}
+
+// MarshalJSON encodes the histogram with its non-empty buckets, so it can be decoded and merged later
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	return json.Marshal(histogramJSON{
+		Count:      h.count,
+		Sum:        h.sum,
+		SumSquares: h.sumSquares,
+		Min:        int64(h.min),
+		Max:        int64(h.max),
+		Buckets:    h.Buckets(),
+	})
+}
+
+// UnmarshalJSON decodes a histogram encoded by MarshalJSON
+func (h *Histogram) UnmarshalJSON(data []byte) error {
+	var hj histogramJSON
+	err := json.Unmarshal(data, &hj)
+	if err != nil {
+		return err
+	}
+
+	*h = Histogram{
+		count:      hj.Count,
+		sum:        hj.Sum,
+		sumSquares: hj.SumSquares,
+		min:        time.Duration(hj.Min),
+		max:        time.Duration(hj.Max),
+	}
+
+	for _, b := range hj.Buckets {
+		idx := bucketIndex(b.Low)
+		if idx >= len(h.counts) {
+			h.grow(idx + 1)
+		}
+
+		h.counts[idx] += b.Count
+	}
+
+	return nil
+}
+
+func (h *Histogram) grow(n int) {
+	counts := make([]uint64, n)
+	copy(counts, h.counts)
+	h.counts = counts
+}
+
+// bucketIndex returns which bucket v belongs in
+// values below histogramSubCount get a bucket each, above that every power of two gets histogramHalfCount buckets
+func bucketIndex(v uint64) int {
+	if v < histogramSubCount {
+		return int(v)
+	}
+
+	shift := bits.Len64(v) - histogramSubBits
+	mantissa := v >> shift
+
+	return histogramSubCount + (shift-1)*histogramHalfCount + int(mantissa-histogramHalfCount)
+}
+
+// bucketBounds returns the smallest and largest values that belong in bucket idx
+func bucketBounds(idx int) (uint64, uint64) {
+	if idx < histogramSubCount {
+		return uint64(idx), uint64(idx)
+	}
+
+	shift := (idx-histogramSubCount)/histogramHalfCount + 1
+	mantissa := uint64((idx-histogramSubCount)%histogramHalfCount + histogramHalfCount)
+
+	return mantissa << shift, (mantissa+1)<<shift - 1
+}
@@ -0,0 +1,190 @@
+package netstats
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBucketIndex(t *testing.T) {
+	cases := []struct {
+		v         uint64
+		idx       int
+		low, high uint64
+	}{
+		{0, 0, 0, 0},
+		{127, 127, 127, 127},
+		{128, 128, 128, 129},
+		{129, 128, 128, 129},
+		{130, 129, 130, 131},
+		{255, 191, 254, 255},
+		{256, 192, 256, 259},
+		{1000000, 954, 999424, 1007615},
+	}
+
+	for _, tc := range cases {
+		idx := bucketIndex(tc.v)
+		if idx != tc.idx {
+			t.Errorf("bucketIndex(%d) = %d, expected %d", tc.v, idx, tc.idx)
+			continue
+		}
+
+		low, high := bucketBounds(idx)
+		if low != tc.low || high != tc.high {
+			t.Errorf("bucketBounds(%d) = %d-%d, expected %d-%d", idx, low, high, tc.low, tc.high)
+		}
+	}
+}
+
+// TestBucketBounds checks the buckets cover every value without gaps, and are never wider than 1/64th of their values
+func TestBucketBounds(t *testing.T) {
+	var next uint64
+	for idx := 0; idx < bucketIndex(uint64(time.Hour)); idx++ {
+		low, high := bucketBounds(idx)
+		if low != next {
+			t.Fatalf("bucket %d starts at %d, expected %d", idx, low, next)
+		}
+
+		if bucketIndex(low) != idx || bucketIndex(high) != idx {
+			t.Fatalf("bucket %d is %d-%d, but they go in %d & %d", idx, low, high, bucketIndex(low), bucketIndex(high))
+		}
+
+		if high-low > low/64 {
+			t.Fatalf("bucket %d is %d-%d, wider than 1/64th", idx, low, high)
+		}
+
+		next = high + 1
+	}
+}
+
+// uniform returns a histogram of 1 to n microseconds
+func uniform(n int) *Histogram {
+	h := NewHistogram()
+	for i := 1; i <= n; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+
+	return h
+}
+
+func TestQuantile(t *testing.T) {
+	h := uniform(10000)
+
+	cases := []struct {
+		q        float64
+		expected time.Duration
+	}{
+		{0.5, 5000 * time.Microsecond},
+		{0.99, 9900 * time.Microsecond},
+		{0.999, 9990 * time.Microsecond},
+		{1, 10000 * time.Microsecond},
+	}
+
+	for _, tc := range cases {
+		got := h.Quantile(tc.q)
+
+		diff := got - tc.expected
+		if diff < 0 {
+			diff = -diff
+		}
+
+		if diff > tc.expected/64 {
+			t.Errorf("p%v = %v, expected %v", tc.q*100, got, tc.expected)
+		}
+	}
+}
+
+// TestQuantileLimits checks quantiles are limited to the recorded values, rather than the middle of their bucket
+func TestQuantileLimits(t *testing.T) {
+	h := NewHistogram()
+	if h.Quantile(0.5) != 0 {
+		t.Errorf("empty histogram p50 = %v", h.Quantile(0.5))
+	}
+
+	h.Record(1000003)
+	h.Record(-time.Second)
+
+	if h.Quantile(0.99) != 1000003 {
+		t.Errorf("p99 = %v, expected the max", h.Quantile(0.99))
+	}
+
+	if h.Quantile(0.01) != 0 || h.Min() != 0 {
+		t.Errorf("p1 = %v, min %v, expected negative durations recorded as 0", h.Quantile(0.01), h.Min())
+	}
+}
+
+func TestMeanStdDev(t *testing.T) {
+	h := NewHistogram()
+	for _, v := range []time.Duration{2, 4, 4, 4, 5, 5, 7, 9} {
+		h.Record(v)
+	}
+
+	if h.Count() != 8 || h.Mean() != 5 || h.StdDev() != 2 {
+		t.Errorf("count %d, mean %v, stddev %v, expected 8, 5 & 2", h.Count(), h.Mean(), h.StdDev())
+	}
+
+	if h.Min() != 2 || h.Max() != 9 {
+		t.Errorf("min %v, max %v, expected 2 & 9", h.Min(), h.Max())
+	}
+}
+
+func TestMerge(t *testing.T) {
+	low, high := NewHistogram(), NewHistogram()
+	for i := 1; i <= 10000; i++ {
+		d := time.Duration(i) * time.Microsecond
+		if i%3 == 0 {
+			high.Record(d)
+		} else {
+			low.Record(d)
+		}
+	}
+
+	merged := NewHistogram()
+	merged.Merge(nil)
+	merged.Merge(NewHistogram())
+	merged.Merge(low)
+	merged.Merge(high)
+
+	if !reflect.DeepEqual(merged.Buckets(), uniform(10000).Buckets()) {
+		t.Error("merged buckets differ from recording every value in one histogram")
+	}
+
+	all := uniform(10000)
+	if merged.Count() != all.Count() || merged.Min() != all.Min() || merged.Max() != all.Max() || merged.Mean() != all.Mean() {
+		t.Errorf("merged count %d, min %v, max %v, mean %v, expected %d, %v, %v, %v",
+			merged.Count(), merged.Min(), merged.Max(), merged.Mean(), all.Count(), all.Min(), all.Max(), all.Mean())
+	}
+
+	for _, q := range []float64{0.5, 0.99, 0.999} {
+		if merged.Quantile(q) != all.Quantile(q) {
+			t.Errorf("merged p%v = %v, expected %v", q*100, merged.Quantile(q), all.Quantile(q))
+		}
+	}
+}
+
+func TestHistogramJSON(t *testing.T) {
+	h := uniform(10000)
+	h.Record(3 * time.Second)
+
+	data, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := NewHistogram()
+	err = json.Unmarshal(data, decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(decoded, h) {
+		t.Error("histogram changed through JSON")
+	}
+
+	// decoded histograms can still be merged
+	decoded.Merge(h)
+	if decoded.Count() != 2*h.Count() || decoded.Quantile(0.5) != h.Quantile(0.5) {
+		t.Errorf("merged decoded histogram has count %d & p50 %v", decoded.Count(), decoded.Quantile(0.5))
+	}
+}
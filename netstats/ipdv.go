@@ -0,0 +1,92 @@
+package netstats
+
+import (
+	"time"
+)
+
+// IPDV accumulates RFC 5481 IP packet delay variation, the difference in one-way delay between consecutive packets.
+// The zero value is ready to use
+type IPDV struct {
+	lastDelay time.Duration
+	hasLast   bool
+
+	count    uint64
+	totalAbs time.Duration
+	min      time.Duration
+	max      time.Duration
+}
+
+// Add records the one-way delay of the next packet in sequence.
+// Delays may include a constant clock offset since only differences between them are used
+func (v *IPDV) Add(delay time.Duration) {
+	if v.hasLast {
+		d := delay - v.lastDelay
+
+		if v.count == 0 || d < v.min {
+			v.min = d
+		}
+
+		if v.count == 0 || d > v.max {
+			v.max = d
+		}
+
+		if d < 0 {
+			v.totalAbs -= d
+		} else {
+			v.totalAbs += d
+		}
+
+		v.count++
+	}
+
+	v.lastDelay = delay
+	v.hasLast = true
+}
+
+// Break marks a gap in the sequence, the next delay will not be compared against the previous one
+func (v *IPDV) Break() {
+	v.hasLast = false
+}
+
+// Merge adds the delay variations recorded in other to v
+// the two sequences are not joined, no delay variation is recorded between them
+func (v *IPDV) Merge(other *IPDV) {
+	if other.count == 0 {
+		return
+	}
+
+	if v.count == 0 || other.min < v.min {
+		v.min = other.min
+	}
+
+	if v.count == 0 || other.max > v.max {
+		v.max = other.max
+	}
+
+	v.count += other.count
+	v.totalAbs += other.totalAbs
+}
+
+// Count returns how many delay variations have been recorded
+func (v *IPDV) Count() uint64 {
+	return v.count
+}
+
+// Min returns the most negative delay variation, a packet that arrived sooner than the one before it
+func (v *IPDV) Min() time.Duration {
+	return v.min
+}
+
+// Max returns the most positive delay variation, a packet that arrived later than the one before it
+func (v *IPDV) Max() time.Duration {
+	return v.max
+}
+
+// MeanAbs returns the mean of the absolute delay variations
+func (v *IPDV) MeanAbs() time.Duration {
+	if v.count == 0 {
+		return 0
+	}
+
+	return v.totalAbs / time.Duration(v.count)
+}
@@ -0,0 +1,64 @@
+package netstats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIPDV(t *testing.T) {
+	var v IPDV
+
+	// 10, 12, 9, 9 vary by +2, -3 & 0
+	for _, delay := range []time.Duration{10, 12, 9, 9} {
+		v.Add(delay * time.Millisecond)
+	}
+
+	if v.Count() != 3 || v.Min() != -3*time.Millisecond || v.Max() != 2*time.Millisecond {
+		t.Errorf("count %d, min %v, max %v, expected 3, -3ms & 2ms", v.Count(), v.Min(), v.Max())
+	}
+
+	if v.MeanAbs() != 5*time.Millisecond/3 {
+		t.Errorf("mean %v, expected %v", v.MeanAbs(), 5*time.Millisecond/3)
+	}
+}
+
+// TestIPDVBreak checks the first delay after a break isn't compared with the one before it
+func TestIPDVBreak(t *testing.T) {
+	var v IPDV
+
+	v.Add(10 * time.Millisecond)
+	v.Add(11 * time.Millisecond)
+	v.Break()
+	v.Add(500 * time.Millisecond)
+	v.Add(499 * time.Millisecond)
+	v.Break()
+	v.Break()
+	v.Add(time.Millisecond)
+
+	if v.Count() != 2 || v.Min() != -time.Millisecond || v.Max() != time.Millisecond || v.MeanAbs() != time.Millisecond {
+		t.Errorf("count %d, min %v, max %v, mean %v, expected 2, -1ms, 1ms & 1ms", v.Count(), v.Min(), v.Max(), v.MeanAbs())
+	}
+}
+
+func TestIPDVMerge(t *testing.T) {
+	var a, b, empty IPDV
+
+	a.Add(10 * time.Millisecond)
+	a.Add(14 * time.Millisecond)
+
+	b.Add(100 * time.Millisecond)
+	b.Add(98 * time.Millisecond)
+	b.Add(98 * time.Millisecond)
+
+	a.Merge(&empty)
+	a.Merge(&b)
+
+	if a.Count() != 3 || a.Min() != -2*time.Millisecond || a.Max() != 4*time.Millisecond || a.MeanAbs() != 2*time.Millisecond {
+		t.Errorf("count %d, min %v, max %v, mean %v, expected 3, -2ms, 4ms & 2ms", a.Count(), a.Min(), a.Max(), a.MeanAbs())
+	}
+
+	empty.Merge(&b)
+	if empty.Min() != -2*time.Millisecond || empty.Max() != 0 {
+		t.Errorf("merged into empty: min %v, max %v, expected -2ms & 0", empty.Min(), empty.Max())
+	}
+}
@@ -0,0 +1,37 @@
+package netstats
+
+import (
+	"time"
+)
+
+// Jitter is the RFC 3550 interarrival jitter estimator.
+// The zero value is ready to use
+type Jitter struct {
+	lastTransit time.Duration
+	started     bool
+
+	jitter float64
+}
+
+// Add records the transit time of a packet, in order of arrival.
+// Transit times may include a constant clock offset since only differences between them are used
+func (j *Jitter) Add(transit time.Duration) {
+	if !j.started {
+		j.lastTransit = transit
+		j.started = true
+		return
+	}
+
+	d := float64(transit - j.lastTransit)
+	if d < 0 {
+		d = -d
+	}
+
+	j.jitter += (d - j.jitter) / 16
+	j.lastTransit = transit
+}
+
+// Value returns the current jitter estimate
+func (j *Jitter) Value() time.Duration {
+	return time.Duration(j.jitter)
+}
@@ -0,0 +1,51 @@
+package netstats
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestJitter(t *testing.T) {
+	var j Jitter
+
+	// the first packet only sets the transit time the next is compared to, each after it moves 1/16th of the way
+	// from the jitter to the difference in transit times
+	steps := []struct {
+		transit  time.Duration
+		expected time.Duration
+	}{
+		{10 * time.Millisecond, 0},
+		{10*time.Millisecond + 16*time.Microsecond, time.Microsecond},
+		{10 * time.Millisecond, 1937 * time.Nanosecond},
+		{10 * time.Millisecond, 1816 * time.Nanosecond},
+	}
+
+	for i, step := range steps {
+		j.Add(step.transit)
+
+		if j.Value() != step.expected {
+			t.Errorf("packet %d: jitter %v, expected %v", i, j.Value(), step.expected)
+		}
+	}
+}
+
+// TestJitterConverges checks transit times alternating by d bring the jitter to d, a 1/16th of the rest of the way
+// with each packet, whatever the clock offset
+func TestJitterConverges(t *testing.T) {
+	d := 160 * time.Microsecond
+
+	for _, offset := range []time.Duration{0, time.Hour, -time.Hour} {
+		var j Jitter
+
+		j.Add(offset)
+		for i := 1; i <= 64; i++ {
+			j.Add(offset + time.Duration(i%2)*d)
+		}
+
+		expected := float64(d) * (1 - math.Pow(15.0/16, 64))
+		if math.Abs(float64(j.Value())-expected) > 1 {
+			t.Errorf("offset %v: jitter %v, expected %v", offset, j.Value(), time.Duration(expected))
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package netstats
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// runBuckets is how many power of two buckets loss run lengths are sorted into, the last bucket holds every longer run
+const runBuckets = 16
+
+// LossRuns tracks runs of consecutive lost packets, fed one packet at a time in sequence order.
+// It has no pointers, so copying a LossRuns copies all of its state. The zero value is ready to use
+type LossRuns struct {
+	packets uint64
+	lost    uint64
+	runs    uint64
+
+	buckets [runBuckets]uint64
+
+	currentRun    uint64
+	longestRun    uint64
+	longestOutage time.Duration
+	lastReceived  time.Time
+
+	// the last two packets, and counts of the loss patterns used to fit the Gilbert-Elliott model
+	prevLost  bool
+	prev2Lost bool
+	lossLoss  uint64
+	lossX3    uint64
+	lossRecv1 uint64
+}
+
+// RunBucket is how many loss runs had a length between Min and Max packets
+type RunBucket struct {
+	Min   uint64
+	Max   uint64
+	Count uint64
+}
+
+func (rb RunBucket) String() string {
+	if rb.Min == rb.Max {
+		return fmt.Sprintf("%d:%d", rb.Min, rb.Count)
+	}
+
+	return fmt.Sprintf("%d-%d:%d", rb.Min, rb.Max, rb.Count)
+}
+
+// GilbertElliott holds the parameters of a two state Gilbert-Elliott loss model.
+// P is the chance of moving from the good state to the bad state, R the chance of moving back,
+// and LossInBad the chance of losing a packet in the bad state. Packets are never lost in the good state.
+// Simple is true if the three parameter fit wasn't possible and LossInBad was assumed to be 1
+type GilbertElliott struct {
+	P         float64
+	R         float64
+	LossInBad float64
+	Simple    bool
+}
+
+// Lost records n lost packets in a row
+func (lr *LossRuns) Lost(n uint64) {
+	if n == 0 {
+		return
+	}
+
+	if lr.currentRun == 0 {
+		lr.runs++
+	}
+
+	// count loss-loss pairs, loss-loss-loss triples, and loss-received-loss triples in closed form
+	if lr.prevLost {
+		lr.lossLoss++
+		if lr.prev2Lost {
+			lr.lossX3++
+		}
+
+		if n >= 2 {
+			lr.lossX3++
+		}
+	} else if lr.prev2Lost {
+		lr.lossRecv1++
+	}
+
+	lr.lossLoss += n - 1
+	if n > 2 {
+		lr.lossX3 += n - 2
+	}
+
+	if n == 1 {
+		lr.prev2Lost = lr.prevLost
+	} else {
+		lr.prev2Lost = true
+	}
+
+	lr.prevLost = true
+
+	lr.packets += n
+	lr.lost += n
+	lr.currentRun += n
+
+	if lr.currentRun > lr.longestRun {
+		lr.longestRun = lr.currentRun
+	}
+}
+
+// Received records a packet that arrived. ts is when it was sent, used to time outages
+func (lr *LossRuns) Received(ts time.Time) {
+	if lr.currentRun > 0 {
+		idx := bits.Len64(lr.currentRun - 1)
+		if idx >= runBuckets {
+			idx = runBuckets - 1
+		}
+
+		lr.buckets[idx]++
+
+		if !lr.lastReceived.IsZero() {
+			if outage := ts.Sub(lr.lastReceived); outage > lr.longestOutage {
+				lr.longestOutage = outage
+			}
+		}
+
+		lr.currentRun = 0
+	}
+
+	lr.prev2Lost = lr.prevLost
+	lr.prevLost = false
+
+	lr.packets++
+	lr.lastReceived = ts
+}
+
+// Merge adds the runs recorded in other to lr, as if other's packets followed on from lr's
+// loss patterns spanning the join are not counted
+func (lr *LossRuns) Merge(other *LossRuns) {
+	lr.packets += other.packets
+	lr.lost += other.lost
+	lr.runs += other.runs
+
+	for i, count := range other.buckets {
+		lr.buckets[i] += count
+	}
+
+	if other.longestRun > lr.longestRun {
+		lr.longestRun = other.longestRun
+	}
+
+	if other.longestOutage > lr.longestOutage {
+		lr.longestOutage = other.longestOutage
+	}
+
+	lr.lossLoss += other.lossLoss
+	lr.lossX3 += other.lossX3
+	lr.lossRecv1 += other.lossRecv1
+
+	lr.currentRun = other.currentRun
+	lr.lastReceived = other.lastReceived
+	lr.prevLost = other.prevLost
+	lr.prev2Lost = other.prev2Lost
+}
+
+// Runs returns how many runs of lost packets there have been
+func (lr *LossRuns) Runs() uint64 {
+	return lr.runs
+}
+
+// LongestRun returns the most packets lost in a row
+func (lr *LossRuns) LongestRun() uint64 {
+	return lr.longestRun
+}
+
+// LongestOutage returns the longest time between two received packets that had lost packets between them
+func (lr *LossRuns) LongestOutage() time.Duration {
+	return lr.longestOutage
+}
+
+// Distribution returns how many finished runs fell into each power of two length bucket
+// buckets with no runs are left out
+func (lr *LossRuns) Distribution() []RunBucket {
+	var dist []RunBucket
+
+	for i, count := range lr.buckets {
+		if count == 0 {
+			continue
+		}
+
+		rb := RunBucket{
+			Min:   1,
+			Max:   1,
+			Count: count,
+		}
+
+		if i > 0 {
+			rb.Min = 1<<(i-1) + 1
+			rb.Max = 1 << i
+		}
+
+		if i == runBuckets-1 {
+			rb.Max = math.MaxUint64
+		}
+
+		dist = append(dist, rb)
+	}
+
+	return dist
+}
+
+// GilbertElliott fits a Gilbert-Elliott model to the recorded losses using Gilbert's method:
+// a = P(loss), b = P(loss | previous loss), and c = P(loss-loss-loss) / P(loss-any-loss) are measured,
+// then solved for the model parameters with 1-R = (ac - b^2) / (2ac - b(a+c)), LossInBad = b / (1-R)
+func (lr *LossRuns) GilbertElliott() GilbertElliott {
+	if lr.lost == 0 || lr.packets == 0 {
+		return GilbertElliott{}
+	}
+
+	a := float64(lr.lost) / float64(lr.packets)
+	b := float64(lr.lossLoss) / float64(lr.lost)
+
+	var c float64
+	if lr.lossX3+lr.lossRecv1 != 0 {
+		c = float64(lr.lossX3) / float64(lr.lossX3+lr.lossRecv1)
+	}
+
+	if b > 0 && c > 0 {
+		denom := 2*a*c - b*(a+c)
+
+		if denom != 0 {
+			q := (a*c - b*b) / denom
+
+			if q > 0 && q < 1 && math.Min(b/q, 1)-a > 0 {
+				// sampling noise can push LossInBad just over 1 when every packet in the bad state is lost
+				ge := GilbertElliott{
+					R:         1 - q,
+					LossInBad: math.Min(b/q, 1),
+				}
+				ge.P = a * ge.R / (ge.LossInBad - a)
+
+				if ge.P > 0 && ge.P <= 1 {
+					return ge
+				}
+			}
+		}
+	}
+
+	// simple Gilbert model: every packet in the bad state is lost
+	received := lr.packets - lr.lost
+	ge := GilbertElliott{
+		R:         float64(lr.runs) / float64(lr.lost),
+		LossInBad: 1,
+		Simple:    true,
+	}
+
+	if received != 0 {
+		ge.P = math.Min(float64(lr.runs)/float64(received), 1)
+	}
+
+	return ge
+}
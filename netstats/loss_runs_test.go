@@ -0,0 +1,179 @@
+package netstats
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// feedModes are the ways a pattern of lost & received packets can be given to a LossRuns: a packet at a time, each run
+// in one Lost call, or each run split across two calls
+var feedModes = []string{"single", "grouped", "split"}
+
+// feed gives lr pattern, where L is a lost packet and R a received one. Packets are sent a millisecond apart
+func feed(lr *LossRuns, pattern string, mode string) {
+	start := time.Unix(1000, 0)
+
+	for i := 0; i < len(pattern); {
+		if pattern[i] == 'R' {
+			lr.Received(start.Add(time.Duration(i) * time.Millisecond))
+			i++
+
+			continue
+		}
+
+		n := 1
+		if mode != "single" {
+			n = len(pattern[i:]) - len(strings.TrimLeft(pattern[i:], "L"))
+		}
+
+		if mode == "split" && n > 1 {
+			lr.Lost(1)
+			lr.Lost(uint64(n - 1))
+		} else {
+			lr.Lost(uint64(n))
+		}
+
+		i += n
+	}
+}
+
+func TestLossRunsCounts(t *testing.T) {
+	cases := []struct {
+		pattern string
+
+		lossLoss, lossX3, lossRecv1 uint64
+		runs, longest               uint64
+	}{
+		{"LRLLLRRL", 2, 1, 1, 3, 3},
+		{"RRRRLLLLRL", 3, 2, 1, 2, 4},
+		{"LLLLLL", 5, 4, 0, 1, 6},
+		{"LRLRL", 0, 0, 2, 3, 1},
+		{"RLLRRLLLLLR", 5, 3, 0, 2, 5},
+		{"LLRLL", 2, 0, 1, 2, 2},
+		{"RRR", 0, 0, 0, 0, 0},
+	}
+
+	for _, tc := range cases {
+		for _, mode := range feedModes {
+			var lr LossRuns
+			feed(&lr, tc.pattern, mode)
+
+			got := []uint64{lr.lossLoss, lr.lossX3, lr.lossRecv1, lr.Runs(), lr.LongestRun()}
+			expected := []uint64{tc.lossLoss, tc.lossX3, tc.lossRecv1, tc.runs, tc.longest}
+
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("%s fed %s: loss-loss, loss x3, loss-recv-loss, runs & longest run are %v, expected %v",
+					tc.pattern, mode, got, expected)
+			}
+
+			if lr.packets != uint64(len(tc.pattern)) || lr.lost != uint64(strings.Count(tc.pattern, "L")) {
+				t.Errorf("%s fed %s: %d of %d packets lost", tc.pattern, mode, lr.lost, lr.packets)
+			}
+		}
+	}
+}
+
+func TestLossRunsDistribution(t *testing.T) {
+	var lr LossRuns
+	feed(&lr, "LRLLLRRLLLLLLLLLRLL", "grouped")
+
+	// the last run hasn't finished, so it isn't in the distribution yet
+	expected := []RunBucket{
+		{Min: 1, Max: 1, Count: 1},
+		{Min: 3, Max: 4, Count: 1},
+		{Min: 9, Max: 16, Count: 1},
+	}
+
+	if dist := lr.Distribution(); !reflect.DeepEqual(dist, expected) {
+		t.Errorf("distribution %v, expected %v", dist, expected)
+	}
+
+	lr.Lost(40000)
+	lr.Received(time.Unix(2000, 0))
+
+	dist := lr.Distribution()
+	last := dist[len(dist)-1]
+	if last.Min != 1<<14+1 || last.Max != math.MaxUint64 || last.Count != 1 {
+		t.Errorf("longest runs are in %v, expected the last bucket", last)
+	}
+
+	if lr.LongestRun() != 40002 {
+		t.Errorf("longest run %d, expected 40002", lr.LongestRun())
+	}
+}
+
+func TestLongestOutage(t *testing.T) {
+	var lr LossRuns
+	feed(&lr, "RLRRLLLLRL", "grouped")
+
+	// packets 3 & 8 were received with 4 lost between them
+	if lr.LongestOutage() != 5*time.Millisecond {
+		t.Errorf("longest outage %v, expected 5ms", lr.LongestOutage())
+	}
+}
+
+func TestGilbertElliott(t *testing.T) {
+	cases := []struct {
+		pattern string
+		repeat  int
+
+		expected GilbertElliott
+	}{
+		// a = 1/2, b = 3/5, c = 2/3, so 1-R = 4/5 and LossInBad = 3/4
+		{"RRRRLLLLRL", 1, GilbertElliott{P: 0.4, R: 0.2, LossInBad: 0.75}},
+		// a = 1/3, b = 2/3, c = 1, so 1-R = 1/2 and LossInBad is limited to 1
+		{"RRRRRRLLL", 4, GilbertElliott{P: 0.25, R: 0.5, LossInBad: 1}},
+		// LossInBad would come out below the loss rate, so the simple model is used: R = runs/lost, P = runs/received
+		{"LRLLLRRL", 1, GilbertElliott{P: 1, R: 0.6, LossInBad: 1, Simple: true}},
+		// no loss follows a loss, so the three parameter fit isn't possible
+		{"RRRLRRRL", 1, GilbertElliott{P: 1.0 / 3, R: 1, LossInBad: 1, Simple: true}},
+		{"RRRR", 1, GilbertElliott{}},
+	}
+
+	for _, tc := range cases {
+		for _, mode := range feedModes {
+			var lr LossRuns
+			feed(&lr, strings.Repeat(tc.pattern, tc.repeat), mode)
+
+			ge := lr.GilbertElliott()
+			if ge.Simple != tc.expected.Simple || !near(ge.P, tc.expected.P) || !near(ge.R, tc.expected.R) ||
+				!near(ge.LossInBad, tc.expected.LossInBad) {
+				t.Errorf("%s x%d fed %s: %+v, expected %+v", tc.pattern, tc.repeat, mode, ge, tc.expected)
+			}
+		}
+	}
+}
+
+// TestLossRunsMerge checks merging two halves split between received packets is the same as recording them together
+func TestLossRunsMerge(t *testing.T) {
+	first, second := "LLRLLLRRLR", "RLLLLRLRRLL"
+
+	var whole, a, b LossRuns
+	feed(&whole, first+second, "grouped")
+	feed(&a, first, "grouped")
+	feed(&b, second, "grouped")
+
+	a.Merge(&b)
+
+	got := []uint64{a.packets, a.lost, a.Runs(), a.LongestRun(), a.lossLoss, a.lossX3, a.lossRecv1}
+	expected := []uint64{whole.packets, whole.lost, whole.Runs(), whole.LongestRun(), whole.lossLoss, whole.lossX3, whole.lossRecv1}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("merged counts %v, expected %v", got, expected)
+	}
+
+	if !reflect.DeepEqual(a.Distribution(), whole.Distribution()) {
+		t.Errorf("merged distribution %v, expected %v", a.Distribution(), whole.Distribution())
+	}
+
+	if a.GilbertElliott() != whole.GilbertElliott() {
+		t.Errorf("merged fit %+v, expected %+v", a.GilbertElliott(), whole.GilbertElliott())
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
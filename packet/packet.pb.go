@@ -23,9 +23,13 @@ const (
 type PacketType int32
 
 const (
-	PacketType_REQPACKET   PacketType = 0
-	PacketType_ACKPACKET   PacketType = 1
-	PacketType_RESETPACKET PacketType = 2
+	PacketType_REQPACKET      PacketType = 0
+	PacketType_ACKPACKET      PacketType = 1
+	PacketType_RESETPACKET    PacketType = 2
+	PacketType_HELLOPACKET    PacketType = 3
+	PacketType_HELLOACKPACKET PacketType = 4
+	PacketType_RESETACKPACKET PacketType = 5
+	PacketType_MATRIXPACKET   PacketType = 6
 )
 
 // Enum value maps for PacketType.
@@ -34,11 +38,19 @@ var (
 		0: "REQPACKET",
 		1: "ACKPACKET",
 		2: "RESETPACKET",
+		3: "HELLOPACKET",
+		4: "HELLOACKPACKET",
+		5: "RESETACKPACKET",
+		6: "MATRIXPACKET",
 	}
 	PacketType_value = map[string]int32{
-		"REQPACKET":   0,
-		"ACKPACKET":   1,
-		"RESETPACKET": 2,
+		"REQPACKET":      0,
+		"ACKPACKET":      1,
+		"RESETPACKET":    2,
+		"HELLOPACKET":    3,
+		"HELLOACKPACKET": 4,
+		"RESETACKPACKET": 5,
+		"MATRIXPACKET":   6,
 	}
 )
 
@@ -69,6 +81,68 @@ func (PacketType) EnumDescriptor() ([]byte, []int) {
 	return file_packet_proto_rawDescGZIP(), []int{0}
 }
 
+// Feature is an optional part of the protocol a peer supports or requires
+type Feature int32
+
+const (
+	Feature_FEATURE_NONE          Feature = 0
+	Feature_FEATURE_TIMESTAMPS    Feature = 1
+	Feature_FEATURE_ENCRYPTION    Feature = 2
+	Feature_FEATURE_PAYLOAD_SIZES Feature = 3
+	Feature_FEATURE_SESSIONS      Feature = 4
+	Feature_FEATURE_KEY_IDS       Feature = 5
+	Feature_FEATURE_PROBES        Feature = 6
+)
+
+// Enum value maps for Feature.
+var (
+	Feature_name = map[int32]string{
+		0: "FEATURE_NONE",
+		1: "FEATURE_TIMESTAMPS",
+		2: "FEATURE_ENCRYPTION",
+		3: "FEATURE_PAYLOAD_SIZES",
+		4: "FEATURE_SESSIONS",
+		5: "FEATURE_KEY_IDS",
+		6: "FEATURE_PROBES",
+	}
+	Feature_value = map[string]int32{
+		"FEATURE_NONE":          0,
+		"FEATURE_TIMESTAMPS":    1,
+		"FEATURE_ENCRYPTION":    2,
+		"FEATURE_PAYLOAD_SIZES": 3,
+		"FEATURE_SESSIONS":      4,
+		"FEATURE_KEY_IDS":       5,
+		"FEATURE_PROBES":        6,
+	}
+)
+
+func (x Feature) Enum() *Feature {
+	p := new(Feature)
+	*p = x
+	return p
+}
+
+func (x Feature) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Feature) Descriptor() protoreflect.EnumDescriptor {
+	return file_packet_proto_enumTypes[1].Descriptor()
+}
+
+func (Feature) Type() protoreflect.EnumType {
+	return &file_packet_proto_enumTypes[1]
+}
+
+func (x Feature) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Feature.Descriptor instead.
+func (Feature) EnumDescriptor() ([]byte, []int) {
+	return file_packet_proto_rawDescGZIP(), []int{1}
+}
+
 type Packet struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -77,6 +151,34 @@ type Packet struct {
 	PacketType PacketType `protobuf:"varint,1,opt,name=packet_type,json=packetType,proto3,enum=packet.PacketType" json:"packet_type,omitempty"`
 	Serial     uint64     `protobuf:"varint,2,opt,name=serial,proto3" json:"serial,omitempty"`
 	ClientID   string     `protobuf:"bytes,3,opt,name=clientID,proto3" json:"clientID,omitempty"`
+	// timestamps are nanoseconds since the unix epoch, 0 if unset
+	ClientSendTime int64 `protobuf:"varint,4,opt,name=client_send_time,json=clientSendTime,proto3" json:"client_send_time,omitempty"`
+	ServerRecvTime int64 `protobuf:"varint,5,opt,name=server_recv_time,json=serverRecvTime,proto3" json:"server_recv_time,omitempty"`
+	ServerSendTime int64 `protobuf:"varint,6,opt,name=server_send_time,json=serverSendTime,proto3" json:"server_send_time,omitempty"`
+	// server_received is how many REQPACKETs the server has received from this client since the last reset, sent on acks
+	ServerReceived uint64 `protobuf:"varint,7,opt,name=server_received,json=serverReceived,proto3" json:"server_received,omitempty"`
+	// session is picked at random by the client when it starts, and binds every packet to that run of the client.
+	// acks echo it back. 0 means the client predates sessions
+	Session uint64 `protobuf:"varint,8,opt,name=session,proto3" json:"session,omitempty"`
+	// the rest are only sent on HELLOPACKETs & HELLOACKPACKETs, which a client exchanges with the server before sending.
+	// required_features are the features the server won't talk without
+	ProtocolVersion  uint32    `protobuf:"varint,9,opt,name=protocol_version,json=protocolVersion,proto3" json:"protocol_version,omitempty"`
+	Features         []Feature `protobuf:"varint,10,rep,packed,name=features,proto3,enum=packet.Feature" json:"features,omitempty"`
+	RequiredFeatures []Feature `protobuf:"varint,11,rep,packed,name=required_features,json=requiredFeatures,proto3,enum=packet.Feature" json:"required_features,omitempty"`
+	// server limits: the largest packet the server can receive in bytes, and how far in nanoseconds a packet's
+	// client_send_time may be from the server's clock before it is rejected
+	MaxPacketSize uint32 `protobuf:"varint,12,opt,name=max_packet_size,json=maxPacketSize,proto3" json:"max_packet_size,omitempty"`
+	MaxClockSkew  int64  `protobuf:"varint,13,opt,name=max_clock_skew,json=maxClockSkew,proto3" json:"max_clock_skew,omitempty"`
+	// padding fills a packet out to payload_size bytes on the wire, payload_size is 0 for unpadded packets.
+	// payload_size is fixed size so the padding needed doesn't depend on it.
+	// echo_size asks the server to pad its ack to the size of the request, received_size is the size of the request
+	// as the server received it, sent on acks & hello acks
+	Padding      []byte `protobuf:"bytes,14,opt,name=padding,proto3" json:"padding,omitempty"`
+	PayloadSize  uint32 `protobuf:"fixed32,15,opt,name=payload_size,json=payloadSize,proto3" json:"payload_size,omitempty"`
+	EchoSize     bool   `protobuf:"varint,16,opt,name=echo_size,json=echoSize,proto3" json:"echo_size,omitempty"`
+	ReceivedSize uint32 `protobuf:"varint,17,opt,name=received_size,json=receivedSize,proto3" json:"received_size,omitempty"`
+	// matrix is a mesh node's row of the loss & RTT matrix, sent on MATRIXPACKETs
+	Matrix *MatrixRow `protobuf:"bytes,18,opt,name=matrix,proto3" json:"matrix,omitempty"`
 }
 
 func (x *Packet) Reset() {
@@ -132,25 +234,332 @@ func (x *Packet) GetClientID() string {
 	return ""
 }
 
+func (x *Packet) GetClientSendTime() int64 {
+	if x != nil {
+		return x.ClientSendTime
+	}
+	return 0
+}
+
+func (x *Packet) GetServerRecvTime() int64 {
+	if x != nil {
+		return x.ServerRecvTime
+	}
+	return 0
+}
+
+func (x *Packet) GetServerSendTime() int64 {
+	if x != nil {
+		return x.ServerSendTime
+	}
+	return 0
+}
+
+func (x *Packet) GetServerReceived() uint64 {
+	if x != nil {
+		return x.ServerReceived
+	}
+	return 0
+}
+
+func (x *Packet) GetSession() uint64 {
+	if x != nil {
+		return x.Session
+	}
+	return 0
+}
+
+func (x *Packet) GetProtocolVersion() uint32 {
+	if x != nil {
+		return x.ProtocolVersion
+	}
+	return 0
+}
+
+func (x *Packet) GetFeatures() []Feature {
+	if x != nil {
+		return x.Features
+	}
+	return nil
+}
+
+func (x *Packet) GetRequiredFeatures() []Feature {
+	if x != nil {
+		return x.RequiredFeatures
+	}
+	return nil
+}
+
+func (x *Packet) GetMaxPacketSize() uint32 {
+	if x != nil {
+		return x.MaxPacketSize
+	}
+	return 0
+}
+
+func (x *Packet) GetMaxClockSkew() int64 {
+	if x != nil {
+		return x.MaxClockSkew
+	}
+	return 0
+}
+
+func (x *Packet) GetPadding() []byte {
+	if x != nil {
+		return x.Padding
+	}
+	return nil
+}
+
+func (x *Packet) GetPayloadSize() uint32 {
+	if x != nil {
+		return x.PayloadSize
+	}
+	return 0
+}
+
+func (x *Packet) GetEchoSize() bool {
+	if x != nil {
+		return x.EchoSize
+	}
+	return false
+}
+
+func (x *Packet) GetReceivedSize() uint32 {
+	if x != nil {
+		return x.ReceivedSize
+	}
+	return 0
+}
+
+func (x *Packet) GetMatrix() *MatrixRow {
+	if x != nil {
+		return x.Matrix
+	}
+	return nil
+}
+
+// MatrixRow is what one mesh node measured to each of its peers over its last interval
+type MatrixRow struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Node  string        `protobuf:"bytes,1,opt,name=node,proto3" json:"node,omitempty"`
+	Cells []*MatrixCell `protobuf:"bytes,2,rep,name=cells,proto3" json:"cells,omitempty"`
+}
+
+func (x *MatrixRow) Reset() {
+	*x = MatrixRow{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_packet_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MatrixRow) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MatrixRow) ProtoMessage() {}
+
+func (x *MatrixRow) ProtoReflect() protoreflect.Message {
+	mi := &file_packet_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MatrixRow.ProtoReflect.Descriptor instead.
+func (*MatrixRow) Descriptor() ([]byte, []int) {
+	return file_packet_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *MatrixRow) GetNode() string {
+	if x != nil {
+		return x.Node
+	}
+	return ""
+}
+
+func (x *MatrixRow) GetCells() []*MatrixCell {
+	if x != nil {
+		return x.Cells
+	}
+	return nil
+}
+
+// MatrixCell is what a mesh node measured to one peer, rtts are in nanoseconds
+type MatrixCell struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Peer        string  `protobuf:"bytes,1,opt,name=peer,proto3" json:"peer,omitempty"`
+	Packets     uint64  `protobuf:"varint,2,opt,name=packets,proto3" json:"packets,omitempty"`
+	LossPercent float64 `protobuf:"fixed64,3,opt,name=loss_percent,json=lossPercent,proto3" json:"loss_percent,omitempty"`
+	RttAvg      int64   `protobuf:"varint,4,opt,name=rtt_avg,json=rttAvg,proto3" json:"rtt_avg,omitempty"`
+	RttP99      int64   `protobuf:"varint,5,opt,name=rtt_p99,json=rttP99,proto3" json:"rtt_p99,omitempty"`
+}
+
+func (x *MatrixCell) Reset() {
+	*x = MatrixCell{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_packet_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MatrixCell) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MatrixCell) ProtoMessage() {}
+
+func (x *MatrixCell) ProtoReflect() protoreflect.Message {
+	mi := &file_packet_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MatrixCell.ProtoReflect.Descriptor instead.
+func (*MatrixCell) Descriptor() ([]byte, []int) {
+	return file_packet_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *MatrixCell) GetPeer() string {
+	if x != nil {
+		return x.Peer
+	}
+	return ""
+}
+
+func (x *MatrixCell) GetPackets() uint64 {
+	if x != nil {
+		return x.Packets
+	}
+	return 0
+}
+
+func (x *MatrixCell) GetLossPercent() float64 {
+	if x != nil {
+		return x.LossPercent
+	}
+	return 0
+}
+
+func (x *MatrixCell) GetRttAvg() int64 {
+	if x != nil {
+		return x.RttAvg
+	}
+	return 0
+}
+
+func (x *MatrixCell) GetRttP99() int64 {
+	if x != nil {
+		return x.RttP99
+	}
+	return 0
+}
+
 var File_packet_proto protoreflect.FileDescriptor
 
 var file_packet_proto_rawDesc = []byte{
 	0x0a, 0x0c, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x06,
-	0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x22, 0x71, 0x0a, 0x06, 0x50, 0x61, 0x63, 0x6b, 0x65, 0x74,
-	0x12, 0x33, 0x0a, 0x0b, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x5f, 0x74, 0x79, 0x70, 0x65, 0x18,
-	0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x12, 0x2e, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x2e, 0x50,
-	0x61, 0x63, 0x6b, 0x65, 0x74, 0x54, 0x79, 0x70, 0x65, 0x52, 0x0a, 0x70, 0x61, 0x63, 0x6b, 0x65,
-	0x74, 0x54, 0x79, 0x70, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x65, 0x72, 0x69, 0x61, 0x6c, 0x18,
-	0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x06, 0x73, 0x65, 0x72, 0x69, 0x61, 0x6c, 0x12, 0x1a, 0x0a,
-	0x08, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52,
-	0x08, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x2a, 0x3b, 0x0a, 0x0a, 0x50, 0x61, 0x63,
-	0x6b, 0x65, 0x74, 0x54, 0x79, 0x70, 0x65, 0x12, 0x0d, 0x0a, 0x09, 0x52, 0x45, 0x51, 0x50, 0x41,
-	0x43, 0x4b, 0x45, 0x54, 0x10, 0x00, 0x12, 0x0d, 0x0a, 0x09, 0x41, 0x43, 0x4b, 0x50, 0x41, 0x43,
-	0x4b, 0x45, 0x54, 0x10, 0x01, 0x12, 0x0f, 0x0a, 0x0b, 0x52, 0x45, 0x53, 0x45, 0x54, 0x50, 0x41,
-	0x43, 0x4b, 0x45, 0x54, 0x10, 0x02, 0x42, 0x27, 0x5a, 0x25, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62,
-	0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x73, 0x74, 0x6f, 0x72, 0x6d, 0x65, 0x6e, 0x74, 0x74, 0x2f, 0x70,
-	0x61, 0x63, 0x6b, 0x65, 0x74, 0x6c, 0x6f, 0x73, 0x73, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62,
-	0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x22, 0xc0, 0x05, 0x0a, 0x06, 0x50, 0x61, 0x63, 0x6b, 0x65,
+	0x74, 0x12, 0x33, 0x0a, 0x0b, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x5f, 0x74, 0x79, 0x70, 0x65,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x12, 0x2e, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x2e,
+	0x50, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x54, 0x79, 0x70, 0x65, 0x52, 0x0a, 0x70, 0x61, 0x63, 0x6b,
+	0x65, 0x74, 0x54, 0x79, 0x70, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x65, 0x72, 0x69, 0x61, 0x6c,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x06, 0x73, 0x65, 0x72, 0x69, 0x61, 0x6c, 0x12, 0x1a,
+	0x0a, 0x08, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x08, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x12, 0x28, 0x0a, 0x10, 0x63, 0x6c,
+	0x69, 0x65, 0x6e, 0x74, 0x5f, 0x73, 0x65, 0x6e, 0x64, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x03, 0x52, 0x0e, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x53, 0x65, 0x6e, 0x64,
+	0x54, 0x69, 0x6d, 0x65, 0x12, 0x28, 0x0a, 0x10, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x5f, 0x72,
+	0x65, 0x63, 0x76, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0e,
+	0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x52, 0x65, 0x63, 0x76, 0x54, 0x69, 0x6d, 0x65, 0x12, 0x28,
+	0x0a, 0x10, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x5f, 0x73, 0x65, 0x6e, 0x64, 0x5f, 0x74, 0x69,
+	0x6d, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0e, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72,
+	0x53, 0x65, 0x6e, 0x64, 0x54, 0x69, 0x6d, 0x65, 0x12, 0x27, 0x0a, 0x0f, 0x73, 0x65, 0x72, 0x76,
+	0x65, 0x72, 0x5f, 0x72, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x64, 0x18, 0x07, 0x20, 0x01, 0x28,
+	0x04, 0x52, 0x0e, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x52, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65,
+	0x64, 0x12, 0x18, 0x0a, 0x07, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x08, 0x20, 0x01,
+	0x28, 0x04, 0x52, 0x07, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x29, 0x0a, 0x10, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18,
+	0x09, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x56,
+	0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x2b, 0x0a, 0x08, 0x66, 0x65, 0x61, 0x74, 0x75, 0x72,
+	0x65, 0x73, 0x18, 0x0a, 0x20, 0x03, 0x28, 0x0e, 0x32, 0x0f, 0x2e, 0x70, 0x61, 0x63, 0x6b, 0x65,
+	0x74, 0x2e, 0x46, 0x65, 0x61, 0x74, 0x75, 0x72, 0x65, 0x52, 0x08, 0x66, 0x65, 0x61, 0x74, 0x75,
+	0x72, 0x65, 0x73, 0x12, 0x3c, 0x0a, 0x11, 0x72, 0x65, 0x71, 0x75, 0x69, 0x72, 0x65, 0x64, 0x5f,
+	0x66, 0x65, 0x61, 0x74, 0x75, 0x72, 0x65, 0x73, 0x18, 0x0b, 0x20, 0x03, 0x28, 0x0e, 0x32, 0x0f,
+	0x2e, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x2e, 0x46, 0x65, 0x61, 0x74, 0x75, 0x72, 0x65, 0x52,
+	0x10, 0x72, 0x65, 0x71, 0x75, 0x69, 0x72, 0x65, 0x64, 0x46, 0x65, 0x61, 0x74, 0x75, 0x72, 0x65,
+	0x73, 0x12, 0x26, 0x0a, 0x0f, 0x6d, 0x61, 0x78, 0x5f, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x5f,
+	0x73, 0x69, 0x7a, 0x65, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0d, 0x6d, 0x61, 0x78, 0x50,
+	0x61, 0x63, 0x6b, 0x65, 0x74, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x24, 0x0a, 0x0e, 0x6d, 0x61, 0x78,
+	0x5f, 0x63, 0x6c, 0x6f, 0x63, 0x6b, 0x5f, 0x73, 0x6b, 0x65, 0x77, 0x18, 0x0d, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x0c, 0x6d, 0x61, 0x78, 0x43, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x6b, 0x65, 0x77, 0x12,
+	0x18, 0x0a, 0x07, 0x70, 0x61, 0x64, 0x64, 0x69, 0x6e, 0x67, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x0c,
+	0x52, 0x07, 0x70, 0x61, 0x64, 0x64, 0x69, 0x6e, 0x67, 0x12, 0x21, 0x0a, 0x0c, 0x70, 0x61, 0x79,
+	0x6c, 0x6f, 0x61, 0x64, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x07, 0x52,
+	0x0b, 0x70, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x1b, 0x0a, 0x09,
+	0x65, 0x63, 0x68, 0x6f, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x10, 0x20, 0x01, 0x28, 0x08, 0x52,
+	0x08, 0x65, 0x63, 0x68, 0x6f, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x23, 0x0a, 0x0d, 0x72, 0x65, 0x63,
+	0x65, 0x69, 0x76, 0x65, 0x64, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x11, 0x20, 0x01, 0x28, 0x0d,
+	0x52, 0x0c, 0x72, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x64, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x29,
+	0x0a, 0x06, 0x6d, 0x61, 0x74, 0x72, 0x69, 0x78, 0x18, 0x12, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x11,
+	0x2e, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x2e, 0x4d, 0x61, 0x74, 0x72, 0x69, 0x78, 0x52, 0x6f,
+	0x77, 0x52, 0x06, 0x6d, 0x61, 0x74, 0x72, 0x69, 0x78, 0x22, 0x49, 0x0a, 0x09, 0x4d, 0x61, 0x74,
+	0x72, 0x69, 0x78, 0x52, 0x6f, 0x77, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x6f, 0x64, 0x65, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x6f, 0x64, 0x65, 0x12, 0x28, 0x0a, 0x05, 0x63, 0x65,
+	0x6c, 0x6c, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x70, 0x61, 0x63, 0x6b,
+	0x65, 0x74, 0x2e, 0x4d, 0x61, 0x74, 0x72, 0x69, 0x78, 0x43, 0x65, 0x6c, 0x6c, 0x52, 0x05, 0x63,
+	0x65, 0x6c, 0x6c, 0x73, 0x22, 0x8f, 0x01, 0x0a, 0x0a, 0x4d, 0x61, 0x74, 0x72, 0x69, 0x78, 0x43,
+	0x65, 0x6c, 0x6c, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x65, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x04, 0x70, 0x65, 0x65, 0x72, 0x12, 0x18, 0x0a, 0x07, 0x70, 0x61, 0x63, 0x6b, 0x65,
+	0x74, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x07, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74,
+	0x73, 0x12, 0x21, 0x0a, 0x0c, 0x6c, 0x6f, 0x73, 0x73, 0x5f, 0x70, 0x65, 0x72, 0x63, 0x65, 0x6e,
+	0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0b, 0x6c, 0x6f, 0x73, 0x73, 0x50, 0x65, 0x72,
+	0x63, 0x65, 0x6e, 0x74, 0x12, 0x17, 0x0a, 0x07, 0x72, 0x74, 0x74, 0x5f, 0x61, 0x76, 0x67, 0x18,
+	0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x72, 0x74, 0x74, 0x41, 0x76, 0x67, 0x12, 0x17, 0x0a,
+	0x07, 0x72, 0x74, 0x74, 0x5f, 0x70, 0x39, 0x39, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06,
+	0x72, 0x74, 0x74, 0x50, 0x39, 0x39, 0x2a, 0x86, 0x01, 0x0a, 0x0a, 0x50, 0x61, 0x63, 0x6b, 0x65,
+	0x74, 0x54, 0x79, 0x70, 0x65, 0x12, 0x0d, 0x0a, 0x09, 0x52, 0x45, 0x51, 0x50, 0x41, 0x43, 0x4b,
+	0x45, 0x54, 0x10, 0x00, 0x12, 0x0d, 0x0a, 0x09, 0x41, 0x43, 0x4b, 0x50, 0x41, 0x43, 0x4b, 0x45,
+	0x54, 0x10, 0x01, 0x12, 0x0f, 0x0a, 0x0b, 0x52, 0x45, 0x53, 0x45, 0x54, 0x50, 0x41, 0x43, 0x4b,
+	0x45, 0x54, 0x10, 0x02, 0x12, 0x0f, 0x0a, 0x0b, 0x48, 0x45, 0x4c, 0x4c, 0x4f, 0x50, 0x41, 0x43,
+	0x4b, 0x45, 0x54, 0x10, 0x03, 0x12, 0x12, 0x0a, 0x0e, 0x48, 0x45, 0x4c, 0x4c, 0x4f, 0x41, 0x43,
+	0x4b, 0x50, 0x41, 0x43, 0x4b, 0x45, 0x54, 0x10, 0x04, 0x12, 0x12, 0x0a, 0x0e, 0x52, 0x45, 0x53,
+	0x45, 0x54, 0x41, 0x43, 0x4b, 0x50, 0x41, 0x43, 0x4b, 0x45, 0x54, 0x10, 0x05, 0x12, 0x10, 0x0a,
+	0x0c, 0x4d, 0x41, 0x54, 0x52, 0x49, 0x58, 0x50, 0x41, 0x43, 0x4b, 0x45, 0x54, 0x10, 0x06, 0x2a,
+	0xa5, 0x01, 0x0a, 0x07, 0x46, 0x65, 0x61, 0x74, 0x75, 0x72, 0x65, 0x12, 0x10, 0x0a, 0x0c, 0x46,
+	0x45, 0x41, 0x54, 0x55, 0x52, 0x45, 0x5f, 0x4e, 0x4f, 0x4e, 0x45, 0x10, 0x00, 0x12, 0x16, 0x0a,
+	0x12, 0x46, 0x45, 0x41, 0x54, 0x55, 0x52, 0x45, 0x5f, 0x54, 0x49, 0x4d, 0x45, 0x53, 0x54, 0x41,
+	0x4d, 0x50, 0x53, 0x10, 0x01, 0x12, 0x16, 0x0a, 0x12, 0x46, 0x45, 0x41, 0x54, 0x55, 0x52, 0x45,
+	0x5f, 0x45, 0x4e, 0x43, 0x52, 0x59, 0x50, 0x54, 0x49, 0x4f, 0x4e, 0x10, 0x02, 0x12, 0x19, 0x0a,
+	0x15, 0x46, 0x45, 0x41, 0x54, 0x55, 0x52, 0x45, 0x5f, 0x50, 0x41, 0x59, 0x4c, 0x4f, 0x41, 0x44,
+	0x5f, 0x53, 0x49, 0x5a, 0x45, 0x53, 0x10, 0x03, 0x12, 0x14, 0x0a, 0x10, 0x46, 0x45, 0x41, 0x54,
+	0x55, 0x52, 0x45, 0x5f, 0x53, 0x45, 0x53, 0x53, 0x49, 0x4f, 0x4e, 0x53, 0x10, 0x04, 0x12, 0x13,
+	0x0a, 0x0f, 0x46, 0x45, 0x41, 0x54, 0x55, 0x52, 0x45, 0x5f, 0x4b, 0x45, 0x59, 0x5f, 0x49, 0x44,
+	0x53, 0x10, 0x05, 0x12, 0x12, 0x0a, 0x0e, 0x46, 0x45, 0x41, 0x54, 0x55, 0x52, 0x45, 0x5f, 0x50,
+	0x52, 0x4f, 0x42, 0x45, 0x53, 0x10, 0x06, 0x42, 0x27, 0x5a, 0x25, 0x67, 0x69, 0x74, 0x68, 0x75,
+	0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x73, 0x74, 0x6f, 0x72, 0x6d, 0x65, 0x6e, 0x74, 0x74, 0x2f,
+	0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x6c, 0x6f, 0x73, 0x73, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -165,19 +574,26 @@ func file_packet_proto_rawDescGZIP() []byte {
 	return file_packet_proto_rawDescData
 }
 
-var file_packet_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_packet_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_packet_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_packet_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
 var file_packet_proto_goTypes = []interface{}{
-	(PacketType)(0), // 0: packet.PacketType
-	(*Packet)(nil),  // 1: packet.Packet
+	(PacketType)(0),    // 0: packet.PacketType
+	(Feature)(0),       // 1: packet.Feature
+	(*Packet)(nil),     // 2: packet.Packet
+	(*MatrixRow)(nil),  // 3: packet.MatrixRow
+	(*MatrixCell)(nil), // 4: packet.MatrixCell
 }
 var file_packet_proto_depIdxs = []int32{
 	0, // 0: packet.Packet.packet_type:type_name -> packet.PacketType
-	1, // [1:1] is the sub-list for method output_type
-	1, // [1:1] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	1, // 1: packet.Packet.features:type_name -> packet.Feature
+	1, // 2: packet.Packet.required_features:type_name -> packet.Feature
+	3, // 3: packet.Packet.matrix:type_name -> packet.MatrixRow
+	4, // 4: packet.MatrixRow.cells:type_name -> packet.MatrixCell
+	5, // [5:5] is the sub-list for method output_type
+	5, // [5:5] is the sub-list for method input_type
+	5, // [5:5] is the sub-list for extension type_name
+	5, // [5:5] is the sub-list for extension extendee
+	0, // [0:5] is the sub-list for field type_name
 }
 
 func init() { file_packet_proto_init() }
@@ -198,14 +614,38 @@ func file_packet_proto_init() {
 				return nil
 			}
 		}
+		file_packet_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MatrixRow); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_packet_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MatrixCell); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_packet_proto_rawDesc,
-			NumEnums:      1,
-			NumMessages:   1,
+			NumEnums:      2,
+			NumMessages:   3,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
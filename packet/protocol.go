@@ -0,0 +1,26 @@
+package proto
+
+// ProtocolVersion is the version of the protocol spoken by this build. It is sent in the handshake
+// version 1 had no handshake, version 2 added HELLOPACKET & HELLOACKPACKET, version 3 added RESETACKPACKET,
+// version 4 answered HELLOPACKETs with the size they arrived at, for path MTU probes, version 5 added MATRIXPACKET
+const ProtocolVersion = 5
+
+// MinProtocolVersion is the oldest version this build can talk to
+const MinProtocolVersion = 2
+
+// ResetAckVersion is the first version whose servers acknowledge RESETPACKETs
+const ResetAckVersion = 3
+
+// MaxPacketSize is the largest UDP payload that fits in an IPv4 packet, no packet can be larger
+const MaxPacketSize = 65507
+
+// HasFeature returns true if f is in features
+func HasFeature(features []Feature, f Feature) bool {
+	for _, feature := range features {
+		if feature == f {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,230 @@
+package report
+
+import (
+	"time"
+
+	"github.com/stormentt/packetloss/netstats"
+)
+
+// SchemaVersion is bumped whenever a field is removed or changes meaning, new fields can be added without bumping it
+const SchemaVersion = 1
+
+const (
+	RoleClient = "client"
+	RoleServer = "server"
+	RoleMesh   = "mesh"
+)
+
+// Report is the result of one interval for one client, as seen by either the client or the server.
+// Durations are in nanoseconds and percentages are between 0 and 100
+type Report struct {
+	Schema   int       `json:"schema"`
+	Role     string    `json:"role"`
+	Time     time.Time `json:"time"`
+	Summary  bool      `json:"summary"`
+	ClientID string    `json:"client_id"`
+	Remote   string    `json:"remote,omitempty"`
+
+	// Target is the name of the target a client report is for, set when the client is given a list of targets
+	Target string `json:"target,omitempty"`
+
+	Client *ClientReport `json:"client,omitempty"`
+	Server *ServerReport `json:"server,omitempty"`
+
+	// Matrix is set on mesh reports instead of Client or Server
+	Matrix *Matrix `json:"matrix,omitempty"`
+}
+
+// ClientReport is what a client saw of its own packets and their acks
+type ClientReport struct {
+	Total        uint64 `json:"total"`
+	Sent         uint64 `json:"sent"`
+	Acked        uint64 `json:"acked"`
+	SentAndAcked uint64 `json:"sent_and_acked"`
+	SentNotAcked uint64 `json:"sent_not_acked"`
+	AckedNotSent uint64 `json:"acked_not_sent"`
+	Late         uint64 `json:"late"`
+
+	// LateAcks are acks for packets that an earlier report counted as lost
+	LateAcks uint64 `json:"late_acks"`
+
+	LossPercent float64 `json:"loss_percent"`
+	LatePercent float64 `json:"late_percent"`
+
+	Upstream   Direction `json:"upstream"`
+	Downstream Direction `json:"downstream"`
+
+	RTT     Latency `json:"rtt"`
+	Forward Delay   `json:"forward"`
+	Reverse Delay   `json:"reverse"`
+
+	ClockOffset int64   `json:"clock_offset_ns"`
+	ClockDrift  float64 `json:"clock_drift_ppm"`
+
+	Variation Variation `json:"variation"`
+	LossRuns  LossRuns  `json:"loss_runs"`
+
+	Sizes []SizeLoss `json:"sizes,omitempty"`
+
+	// Schedule is how the client spaced out its packets
+	Schedule *Schedule `json:"schedule,omitempty"`
+}
+
+// ServerReport is what the server saw of a client's packets
+type ServerReport struct {
+	Received   uint64    `json:"received"`
+	Missed     uint64    `json:"missed"`
+	Reordered  uint64    `json:"reordered"`
+	Duplicates uint64    `json:"duplicates"`
+	Replayed   uint64    `json:"replayed"`
+	LastUpdate time.Time `json:"last_update"`
+
+	// Session is the client's current session, the stats start again from 0 when it changes
+	Session uint64 `json:"session,omitempty"`
+
+	LossPercent      float64 `json:"loss_percent"`
+	ReorderPercent   float64 `json:"reorder_percent"`
+	ReorderExtentAvg float64 `json:"reorder_extent_avg"`
+	ReorderExtentMax uint64  `json:"reorder_extent_max"`
+
+	Variation Variation `json:"variation"`
+	LossRuns  LossRuns  `json:"loss_runs"`
+}
+
+// Schedule is how a client spaced out its packets. Type is fixed, poisson, burst, or ramp.
+// Interval is the time between packets, their mean time apart for poisson, the time between bursts for burst,
+// and the starting time between packets for ramp, which ramps to RampTo over RampTime
+type Schedule struct {
+	Type      string `json:"type"`
+	Interval  int64  `json:"interval_ns"`
+	BurstSize int    `json:"burst_size,omitempty"`
+	RampTo    int64  `json:"ramp_to_ns,omitempty"`
+	RampTime  int64  `json:"ramp_time_ns,omitempty"`
+}
+
+// SizeLoss is the loss of padded packets in one size bucket, Size is the smallest size in the bucket in bytes
+type SizeLoss struct {
+	Size        int     `json:"size"`
+	Sent        uint64  `json:"sent"`
+	Acked       uint64  `json:"acked"`
+	Lost        uint64  `json:"lost"`
+	Late        uint64  `json:"late"`
+	LossPercent float64 `json:"loss_percent"`
+}
+
+// Matrix is the loss & RTT between every pair of mesh nodes, as far as one node knows them.
+// There is a cell for every pair with a recent measurement, From's measurement of the path to To
+type Matrix struct {
+	Nodes []string     `json:"nodes"`
+	Cells []MatrixCell `json:"cells"`
+}
+
+// MatrixCell is what From measured to To over From's last interval. Updated is when From measured it
+type MatrixCell struct {
+	From        string    `json:"from"`
+	To          string    `json:"to"`
+	Packets     uint64    `json:"packets"`
+	LossPercent float64   `json:"loss_percent"`
+	RTTAvg      int64     `json:"rtt_avg_ns"`
+	RTTP99      int64     `json:"rtt_p99_ns"`
+	Updated     time.Time `json:"updated"`
+}
+
+// Direction is the loss in one direction of the path
+type Direction struct {
+	Lost    uint64  `json:"lost"`
+	Percent float64 `json:"percent"`
+}
+
+// Latency summarises a set of round trip times. The histogram can be used to merge reports from several intervals
+type Latency struct {
+	Avg    int64 `json:"avg_ns"`
+	Min    int64 `json:"min_ns"`
+	Max    int64 `json:"max_ns"`
+	StdDev int64 `json:"stddev_ns"`
+	P50    int64 `json:"p50_ns"`
+	P90    int64 `json:"p90_ns"`
+	P95    int64 `json:"p95_ns"`
+	P99    int64 `json:"p99_ns"`
+	P999   int64 `json:"p999_ns"`
+
+	Histogram *netstats.Histogram `json:"histogram,omitempty"`
+}
+
+// Delay summarises a set of one-way delays
+type Delay struct {
+	Avg int64 `json:"avg_ns"`
+	Min int64 `json:"min_ns"`
+	Max int64 `json:"max_ns"`
+}
+
+// Variation is the RFC 3550 jitter and RFC 5481 IPDV of a set of packets
+type Variation struct {
+	Jitter      int64 `json:"jitter_ns"`
+	IPDVMin     int64 `json:"ipdv_min_ns"`
+	IPDVMax     int64 `json:"ipdv_max_ns"`
+	IPDVMeanAbs int64 `json:"ipdv_mean_abs_ns"`
+}
+
+// LossRuns describes runs of consecutive lost packets and the loss model fitted to them
+type LossRuns struct {
+	Runs          uint64      `json:"runs"`
+	LongestRun    uint64      `json:"longest_run"`
+	LongestOutage int64       `json:"longest_outage_ns"`
+	Lengths       []RunLength `json:"lengths"`
+
+	GilbertElliott GilbertElliott `json:"gilbert_elliott"`
+}
+
+// RunLength is how many loss runs were between Min and Max packets long
+type RunLength struct {
+	Min   uint64 `json:"min"`
+	Max   uint64 `json:"max"`
+	Count uint64 `json:"count"`
+}
+
+// GilbertElliott holds the fitted parameters of a Gilbert-Elliott loss model
+type GilbertElliott struct {
+	P         float64 `json:"p"`
+	R         float64 `json:"r"`
+	LossInBad float64 `json:"loss_in_bad"`
+	Simple    bool    `json:"simple"`
+}
+
+// New returns a report with the schema, role, and time filled in
+func New(role, clientID string) *Report {
+	return &Report{
+		Schema:   SchemaVersion,
+		Role:     role,
+		Time:     time.Now(),
+		ClientID: clientID,
+	}
+}
+
+// NewLossRuns converts loss runs into their report form
+func NewLossRuns(runs *netstats.LossRuns) LossRuns {
+	ge := runs.GilbertElliott()
+
+	lengths := []RunLength{}
+	for _, rb := range runs.Distribution() {
+		lengths = append(lengths, RunLength{
+			Min:   rb.Min,
+			Max:   rb.Max,
+			Count: rb.Count,
+		})
+	}
+
+	return LossRuns{
+		Runs:          runs.Runs(),
+		LongestRun:    runs.LongestRun(),
+		LongestOutage: int64(runs.LongestOutage()),
+		Lengths:       lengths,
+
+		GilbertElliott: GilbertElliott{
+			P:         ge.P,
+			R:         ge.R,
+			LossInBad: ge.LossInBad,
+			Simple:    ge.Simple,
+		},
+	}
+}
@@ -0,0 +1,56 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// Writer writes reports as JSON, one object per line
+type Writer struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewWriter creates a new Writer object that writes to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		enc: json.NewEncoder(w),
+	}
+}
+
+// FromConfig returns a Writer for the configured output, or nil if reports should be logged as text
+// output_file is appended to if set, otherwise reports are written to stdout
+func FromConfig() (*Writer, error) {
+	switch viper.GetString("output") {
+	case "", "text":
+		return nil, nil
+	case "json":
+	default:
+		return nil, fmt.Errorf("unknown output format %q", viper.GetString("output"))
+	}
+
+	path := viper.GetString("output_file")
+	if path == "" || path == "-" {
+		return NewWriter(os.Stdout), nil
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWriter(f), nil
+}
+
+// Write writes a single report
+func (w *Writer) Write(r *Report) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.enc.Encode(r)
+}
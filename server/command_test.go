@@ -0,0 +1,175 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func testSerial(session, serial uint64) wrapSerial {
+	now := time.Now()
+
+	return wrapSerial{
+		Serial:         serial,
+		From:           &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6666},
+		ClientID:       "client",
+		RecvTime:       now,
+		ClientSendTime: now.UnixNano(),
+		Session:        session,
+	}
+}
+
+func doCommand(t *testing.T, sm *StatsMap, cmd StatsCommand) {
+	t.Helper()
+
+	unlock := sm.Lock("client")
+	defer unlock()
+
+	err := cmd.Do(sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func clientStats(sm *StatsMap) ServerStats {
+	unlock := sm.Lock("client")
+	defer unlock()
+
+	var stats ServerStats
+	sm.Get("client").Clone(&stats)
+
+	return stats
+}
+
+func TestResetAfterSessionPackets(t *testing.T) {
+	sm := NewStatsMap()
+
+	for serial := uint64(1); serial <= 5; serial++ {
+		doCommand(t, sm, newRecvPacketCommand(testSerial(1, serial)))
+	}
+
+	// the client restarts, and its new session's packets overtake its reset
+	doCommand(t, sm, newRecvPacketCommand(testSerial(2, 1)))
+	doCommand(t, sm, newRecvPacketCommand(testSerial(2, 2)))
+	doCommand(t, sm, newResetPacketCommand(testSerial(2, 1)))
+	doCommand(t, sm, newRecvPacketCommand(testSerial(2, 3)))
+
+	stats := clientStats(sm)
+	if stats.Session != 2 || stats.Received != 3 || stats.Missed != 0 {
+		t.Errorf("got session %d, received %d, missed %d, want 2, 3, 0", stats.Session, stats.Received, stats.Missed)
+	}
+}
+
+func TestDuplicateCommand(t *testing.T) {
+	sm := NewStatsMap()
+
+	doCommand(t, sm, newRecvPacketCommand(testSerial(1, 1)))
+	doCommand(t, sm, newDuplicatePacketCommand(testSerial(1, 1)))
+
+	stats := clientStats(sm)
+	if stats.Duplicates != 1 || stats.Replayed != 0 || stats.Received != 1 {
+		t.Errorf("got duplicates %d, replayed %d, received %d, want 1, 0, 1", stats.Duplicates, stats.Replayed, stats.Received)
+	}
+}
+
+func recvSerials(t *testing.T, sm *StatsMap, session uint64, serials ...uint64) {
+	t.Helper()
+
+	for _, serial := range serials {
+		doCommand(t, sm, newRecvPacketCommand(testSerial(session, serial)))
+	}
+}
+
+// TestReordered checks late packets are moved from Missed to Reordered, with their RFC 4737 extent: how many newer
+// packets arrived before them
+func TestReordered(t *testing.T) {
+	sm := NewStatsMap()
+
+	// 2 arrives after 5, 6 & 7, 4 after them too, 3 never arrives and 2 is then duplicated
+	recvSerials(t, sm, 1, 1, 5, 6, 7, 2, 4, 2)
+
+	stats := clientStats(sm)
+	if stats.Received != 6 || stats.Missed != 1 || stats.Reordered != 2 || stats.Duplicates != 1 {
+		t.Errorf("got received %d, missed %d, reordered %d, duplicates %d, want 6, 1, 2, 1",
+			stats.Received, stats.Missed, stats.Reordered, stats.Duplicates)
+	}
+
+	if stats.ReorderExtentTotal != 6 || stats.ReorderExtentMax != 3 {
+		t.Errorf("got extent total %d, max %d, want 6, 3", stats.ReorderExtentTotal, stats.ReorderExtentMax)
+	}
+}
+
+// TestReorderedAcrossWords checks the extent counts newer packets in every word of the window
+func TestReorderedAcrossWords(t *testing.T) {
+	sm := NewStatsMap()
+
+	recvSerials(t, sm, 1, 1, 2)
+	for serial := uint64(4); serial <= 70; serial++ {
+		recvSerials(t, sm, 1, serial)
+	}
+
+	recvSerials(t, sm, 1, 3)
+
+	stats := clientStats(sm)
+	if stats.Missed != 0 || stats.Reordered != 1 || stats.ReorderExtentMax != 67 {
+		t.Errorf("got missed %d, reordered %d, extent %d, want 0, 1, 67", stats.Missed, stats.Reordered, stats.ReorderExtentMax)
+	}
+}
+
+func TestOldSerial(t *testing.T) {
+	sm := NewStatsMap()
+
+	recvSerials(t, sm, 1, 1, 2+windowSize)
+
+	unlock := sm.Lock("client")
+	err := newRecvPacketCommand(testSerial(1, 2)).Do(sm)
+	unlock()
+
+	if _, ok := err.(RecvOldSerialErr); !ok {
+		t.Errorf("got %v, want RecvOldSerialErr", err)
+	}
+}
+
+// TestRestarted checks when a client is taken to have restarted without its reset arriving
+func TestRestarted(t *testing.T) {
+	cases := []struct {
+		name string
+
+		// the client's first packets are sent in session before, then serial in session
+		before  uint64
+		first   []uint64
+		session uint64
+		serial  uint64
+
+		restarted bool
+	}{
+		// clients without sessions restart from serial 1, unless serial 1 is still in the window
+		{"sessionless duplicate", 0, []uint64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 0, 1, false},
+		{"sessionless restart", 0, []uint64{1, windowSize + 10}, 0, 1, true},
+		{"sessionless restart before window", 0, []uint64{2, 3, 4}, 0, 1, true},
+		{"sessionless late", 0, []uint64{1, 5, 6}, 0, 2, false},
+		// clients with sessions restart when the session changes
+		{"same session", 1, []uint64{1, 2, 3}, 1, 1, false},
+		{"new session", 1, []uint64{1, 2, 3}, 2, 5, true},
+	}
+
+	for _, tc := range cases {
+		sm := NewStatsMap()
+
+		recvSerials(t, sm, tc.before, tc.first...)
+		before := clientStats(sm)
+		recvSerials(t, sm, tc.session, tc.serial)
+
+		stats := clientStats(sm)
+		restarted := stats.LastSerial == tc.serial && stats.Received == 1
+
+		if restarted != tc.restarted {
+			t.Errorf("%s: restarted %v, want %v (received %d, last serial %d, was %d & %d)",
+				tc.name, restarted, tc.restarted, stats.Received, stats.LastSerial, before.Received, before.LastSerial)
+		}
+
+		if restarted && (stats.Session != tc.session || stats.Missed != tc.serial-1) {
+			t.Errorf("%s: session %d, missed %d after restart, want %d, %d", tc.name, stats.Session, stats.Missed, tc.session, tc.serial-1)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package server
+
+type DuplicatePacketCommand struct {
+	ws wrapSerial
+
+	oldStats *ServerStats
+}
+
+func newDuplicatePacketCommand(ws wrapSerial) *DuplicatePacketCommand {
+	oldStats := &ServerStats{}
+
+	return &DuplicatePacketCommand{
+		ws,
+		oldStats,
+	}
+}
+
+func (cmd *DuplicatePacketCommand) Do(sm *StatsMap) error {
+	stats := sm.Get(cmd.ws.ClientID)
+	stats.Clone(cmd.oldStats)
+
+	stats.Duplicates++
+
+	return nil
+}
+
+func (cmd *DuplicatePacketCommand) Undo(sm *StatsMap) error {
+	stats := sm.Get(cmd.ws.ClientID)
+	cmd.oldStats.Clone(stats)
+
+	return nil
+}
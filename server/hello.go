@@ -0,0 +1,92 @@
+package server
+
+import (
+	"net"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/stormentt/packetloss/keyring"
+	packet "github.com/stormentt/packetloss/packet"
+	wrapper "github.com/stormentt/packetloss/wrapper"
+)
+
+// serverFeatures returns the features this server supports, and the ones it requires of clients
+func serverFeatures() ([]packet.Feature, []packet.Feature) {
+	features := []packet.Feature{
+		packet.Feature_FEATURE_TIMESTAMPS,
+		packet.Feature_FEATURE_ENCRYPTION,
+		packet.Feature_FEATURE_SESSIONS,
+		packet.Feature_FEATURE_KEY_IDS,
+		packet.Feature_FEATURE_PAYLOAD_SIZES,
+		packet.Feature_FEATURE_PROBES,
+	}
+
+	var required []packet.Feature
+	if viper.GetBool("require_encryption") {
+		required = append(required, packet.Feature_FEATURE_ENCRYPTION)
+	}
+
+	if !viper.GetBool("allow_no_session") {
+		required = append(required, packet.Feature_FEATURE_SESSIONS, packet.Feature_FEATURE_TIMESTAMPS)
+	}
+
+	return features, required
+}
+
+// sendHelloAck answers a client's HELLOPACKET of size bytes with the server's protocol version, features, and limits
+// it is answered even if the server would reject the client's other packets, so the client can explain why.
+// Path MTU probes are padded hellos, so the ack carries the size the hello arrived at but isn't padded itself
+func sendHelloAck(conn *net.UDPConn, key keyring.Key, mode wrapper.Mode, hello *packet.Packet, size int, recvTime time.Time, addr *net.UDPAddr) {
+	features, required := serverFeatures()
+
+	ack := &packet.Packet{
+		PacketType:       packet.PacketType_HELLOACKPACKET,
+		Serial:           hello.Serial,
+		ClientID:         hello.ClientID,
+		Session:          hello.Session,
+		ClientSendTime:   hello.ClientSendTime,
+		ServerRecvTime:   recvTime.UnixNano(),
+		ProtocolVersion:  packet.ProtocolVersion,
+		Features:         features,
+		RequiredFeatures: required,
+		MaxPacketSize:    packet.MaxPacketSize,
+		MaxClockSkew:     int64(viper.GetDuration("max_skew")),
+		ReceivedSize:     uint32(size),
+	}
+
+	ack.ServerSendTime = time.Now().UnixNano()
+
+	data, err := wrapper.Encode(ack, key, mode)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Error": err,
+		}).Error("could not encode hello ack packet")
+
+		return
+	}
+
+	_, err = conn.WriteTo(data, addr)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Error": err,
+		}).Error("could not send hello ack packet")
+
+		return
+	}
+
+	fields := log.Fields{
+		"ClientID":        hello.ClientID,
+		"ProtocolVersion": hello.ProtocolVersion,
+		"Features":        hello.Features,
+		"addr":            addr,
+	}
+
+	if hello.PayloadSize != 0 {
+		fields["Size"] = size
+		log.WithFields(fields).Debug("answered probe")
+		return
+	}
+
+	log.WithFields(fields).Info("client said hello")
+}
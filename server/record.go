@@ -2,62 +2,147 @@ package server
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/stormentt/packetloss/netstats"
 )
 
-// StatsMap is a map of ClientIDs to statistics for individual clients
+// statsShards is how many shards a StatsMap is split into, so clients in different shards can be updated at once
+const statsShards = 64
+
+// StatsMap is a map of ClientIDs to statistics for individual clients.
+// It is split into shards by ClientID, each with its own lock, so several workers can record packets at once
 type StatsMap struct {
+	shards [statsShards]statsShard
+}
+
+// statsShard is one shard of a StatsMap
+type statsShard struct {
+	mu       sync.Mutex
 	internal map[string]*ServerStats
 }
 
 // NewStatsMap returns a new StatsMap object
 func NewStatsMap() *StatsMap {
-	return &StatsMap{
-		internal: make(map[string]*ServerStats),
+	sm := &StatsMap{}
+	for i := range sm.shards {
+		sm.shards[i].internal = make(map[string]*ServerStats)
+	}
+
+	return sm
+}
+
+// shard returns the shard holding client's stats, picked with FNV-1a
+func (sm *StatsMap) shard(client string) *statsShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(client); i++ {
+		h ^= uint32(client[i])
+		h *= 16777619
 	}
+
+	return &sm.shards[h%statsShards]
+}
+
+// Lock locks client's stats, and the stats of every other client in its shard, until the returned func is called.
+// It must be held while the client's stats are used, including by every StatsCommand
+func (sm *StatsMap) Lock(client string) func() {
+	shard := sm.shard(client)
+	shard.mu.Lock()
+
+	return shard.mu.Unlock
 }
 
 // Get retrieves the statistics block for a specific ClientID
-// If the ClientID has never been seen before, it creates a new stats block
+// If the ClientID has never been seen before, it creates a new stats block. The client must be locked with Lock
 func (sm *StatsMap) Get(client string) *ServerStats {
-	if stats, ok := sm.internal[client]; ok {
+	shard := sm.shard(client)
+	if stats, ok := shard.internal[client]; ok {
 		stats.LastUpdated = time.Now()
 		return stats
 	} else {
-		sm.internal[client] = &ServerStats{}
-		return sm.internal[client]
+		shard.internal[client] = &ServerStats{}
+		return shard.internal[client]
+	}
+}
+
+// each calls f with every client's stats, locking a shard at a time
+func (sm *StatsMap) each(f func(client string, stats *ServerStats)) {
+	for i := range sm.shards {
+		shard := &sm.shards[i]
+
+		shard.mu.Lock()
+		for client, stats := range shard.internal {
+			f(client, stats)
+		}
+		shard.mu.Unlock()
 	}
 }
 
 // Print outputs every clients stats
 func (sm *StatsMap) Print() {
 	log.Trace("StatsMap.Print()")
-	for client, stats := range sm.internal {
+	sm.each(func(client string, stats *ServerStats) {
 		totalPackets := stats.Received + stats.Missed
 		percentLoss := float64(stats.Missed) / float64(totalPackets) * 100.0
+		percentReordered := float64(stats.Reordered) / float64(totalPackets) * 100.0
+
+		var avgExtent float64
+		if stats.Reordered != 0 {
+			avgExtent = float64(stats.ReorderExtentTotal) / float64(stats.Reordered)
+		}
+
+		ge := stats.Runs.GilbertElliott()
 
 		log.WithFields(log.Fields{
-			"Total":       totalPackets,
-			"Missed":      stats.Missed,
-			"PercentMiss": fmt.Sprintf("%0.2f", percentLoss),
-			"ClientID":    client,
-			"LastUpdate":  stats.LastUpdated,
-			"Timestamp":   time.Now(),
+			"Total":          totalPackets,
+			"Missed":         stats.Missed,
+			"PercentMiss":    fmt.Sprintf("%0.2f", percentLoss),
+			"Jitter":         stats.Jitter.Value(),
+			"IPDVMin":        stats.IPDV.Min(),
+			"IPDVMax":        stats.IPDV.Max(),
+			"IPDVMeanAbs":    stats.IPDV.MeanAbs(),
+			"Reordered":      stats.Reordered,
+			"PercentReorder": fmt.Sprintf("%0.2f", percentReordered),
+			"ExtentAvg":      fmt.Sprintf("%0.2f", avgExtent),
+			"ExtentMax":      stats.ReorderExtentMax,
+			"Duplicates":     stats.Duplicates,
+			"Replayed":       stats.Replayed,
+			"LossRuns":       stats.Runs.Runs(),
+			"LongestRun":     stats.Runs.LongestRun(),
+			"LongestOutage":  stats.Runs.LongestOutage(),
+			"RunLengths":     fmt.Sprintf("%v", stats.Runs.Distribution()),
+			"GEP":            fmt.Sprintf("%.4f", ge.P),
+			"GER":            fmt.Sprintf("%.4f", ge.R),
+			"GELossInBad":    fmt.Sprintf("%.4f", ge.LossInBad),
+			"ClientID":       client,
+			"LastUpdate":     stats.LastUpdated,
+			"Timestamp":      time.Now(),
 		}).Info("stats")
-	}
+	})
 }
 
-// Cull removes old clients from the StatsMap
-func (sm *StatsMap) Cull() {
+// Cull removes old clients from the StatsMap, and returns their ClientIDs
+func (sm *StatsMap) Cull() []string {
 	log.Trace("StatsMap.Cull()")
 
-	for id, stats := range sm.internal {
-		if stats.Cullable() {
-			delete(sm.internal, id)
+	var culled []string
+
+	for i := range sm.shards {
+		shard := &sm.shards[i]
+
+		shard.mu.Lock()
+		for id, stats := range shard.internal {
+			if stats.Cullable() {
+				delete(shard.internal, id)
+				culled = append(culled, id)
+			}
 		}
+		shard.mu.Unlock()
 	}
+
+	return culled
 }
 
 type ServerStats struct {
@@ -67,6 +152,30 @@ type ServerStats struct {
 	LastSerial uint64
 	LastAck    uint64
 
+	// Reordered packets arrived after a newer serial, Duplicates arrived more than once
+	// the reorder extent is how many newer packets arrived before a reordered one (RFC 4737)
+	Reordered          uint64
+	Duplicates         uint64
+	ReorderExtentTotal uint64
+	ReorderExtentMax   uint64
+	Window             receiveWindow
+
+	// Replayed packets were authentic but rejected by the replay guard. Repeats of a serial still inside the guard's
+	// window are far more likely to be network duplicates, so they are counted as Duplicates instead
+	Replayed uint64
+
+	// Runs tracks runs of consecutive missed packets
+	Runs netstats.LossRuns
+
+	// Jitter & IPDV are calculated from the client's send timestamps and the server's receive timestamps
+	Jitter netstats.Jitter
+	IPDV   netstats.IPDV
+
+	// Remote is the address the client's packets last came from, Session is the client's current session.
+	// Both are kept by Reset
+	Remote  string
+	Session uint64
+
 	LastUpdated time.Time
 }
 
@@ -77,6 +186,19 @@ func (stats *ServerStats) Clone(dest *ServerStats) {
 	dest.LastSerial = stats.LastSerial
 	dest.LastAck = stats.Received
 
+	dest.Reordered = stats.Reordered
+	dest.Duplicates = stats.Duplicates
+	dest.ReorderExtentTotal = stats.ReorderExtentTotal
+	dest.ReorderExtentMax = stats.ReorderExtentMax
+	dest.Window = stats.Window
+	dest.Replayed = stats.Replayed
+
+	dest.Runs = stats.Runs
+	dest.Jitter = stats.Jitter
+	dest.IPDV = stats.IPDV
+
+	dest.Remote = stats.Remote
+	dest.Session = stats.Session
 	dest.LastUpdated = stats.LastUpdated
 }
 
@@ -87,6 +209,17 @@ func (stats *ServerStats) Reset() {
 	stats.LastSerial = 0
 	stats.LastAck = 0
 
+	stats.Reordered = 0
+	stats.Duplicates = 0
+	stats.ReorderExtentTotal = 0
+	stats.ReorderExtentMax = 0
+	stats.Window = receiveWindow{}
+	stats.Replayed = 0
+
+	stats.Runs = netstats.LossRuns{}
+	stats.Jitter = netstats.Jitter{}
+	stats.IPDV = netstats.IPDV{}
+
 	stats.LastUpdated = time.Now()
 }
 
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStatsMapShards(t *testing.T) {
+	sm := NewStatsMap()
+
+	used := make(map[*statsShard]bool)
+	for i := 0; i < 1000; i++ {
+		client := fmt.Sprintf("client %d", i)
+
+		shard := sm.shard(client)
+		if shard != sm.shard(client) {
+			t.Fatalf("%s is in more than one shard", client)
+		}
+
+		used[shard] = true
+	}
+
+	if len(used) != statsShards {
+		t.Errorf("1000 clients only used %d of %d shards", len(used), statsShards)
+	}
+}
+
+// TestStatsMapConcurrent records packets from many clients at once, as several workers would. Run it with -race
+func TestStatsMapConcurrent(t *testing.T) {
+	sm := NewStatsMap()
+
+	const clients = 32
+	const packets = 200
+
+	var wg sync.WaitGroup
+	for c := 0; c < clients; c++ {
+		client := fmt.Sprintf("client %d", c)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for serial := uint64(1); serial <= packets; serial++ {
+				ws := testSerial(1, serial)
+				ws.ClientID = client
+
+				unlock := sm.Lock(client)
+				err := newRecvPacketCommand(ws).Do(sm)
+				unlock()
+
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	reports := sm.Reports()
+	if len(reports) != clients {
+		t.Fatalf("%d reports, expected one for each of %d clients", len(reports), clients)
+	}
+
+	for _, r := range reports {
+		if r.Server.Received != packets || r.Server.Missed != 0 {
+			t.Errorf("%s: received %d, missed %d, expected %d, 0", r.ClientID, r.Server.Received, r.Server.Missed, packets)
+		}
+	}
+}
+
+func TestCullAcrossShards(t *testing.T) {
+	sm := NewStatsMap()
+
+	stale := make(map[string]bool)
+	for i := 0; i < 200; i++ {
+		client := fmt.Sprintf("client %d", i)
+
+		unlock := sm.Lock(client)
+		stats := sm.Get(client)
+		stats.LastUpdated = time.Now()
+
+		if i%2 == 0 {
+			stats.LastUpdated = time.Now().Add(-time.Hour)
+			stale[client] = true
+		}
+		unlock()
+	}
+
+	culled := sm.Cull()
+	if len(culled) != len(stale) {
+		t.Errorf("culled %d clients, expected %d", len(culled), len(stale))
+	}
+
+	for _, client := range culled {
+		if !stale[client] {
+			t.Errorf("culled %s, which was up to date", client)
+		}
+	}
+
+	reports := sm.Reports()
+	if len(reports) != 200-len(stale) {
+		t.Errorf("%d reports after culling, expected %d", len(reports), 200-len(stale))
+	}
+
+	for _, r := range reports {
+		if stale[r.ClientID] {
+			t.Errorf("culled %s still has a report", r.ClientID)
+		}
+	}
+}
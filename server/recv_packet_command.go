@@ -24,8 +24,88 @@ func newRecvPacketCommand(ws wrapSerial) *RecvPacketCommand {
 func (cmd *RecvPacketCommand) Do(sm *StatsMap) error {
 	stats := sm.Get(cmd.ws.ClientID)
 	stats.Clone(cmd.oldStats)
+	stats.Remote = cmd.ws.From.String()
+
+	if cmd.restarted(stats) {
+		if stats.LastSerial != 0 {
+			log.WithFields(log.Fields{
+				"ClientID":   cmd.ws.ClientID,
+				"LastSerial": stats.LastSerial,
+				"Serial":     cmd.ws.Serial,
+				"Session":    cmd.ws.Session,
+			}).Info("client restarted without a reset")
+		}
+
+		stats.Reset()
+		stats.Session = cmd.ws.Session
+	}
 
 	if stats.LastSerial >= cmd.ws.Serial {
+		return cmd.doLate(stats)
+	}
+
+	dSerial := cmd.ws.Serial - stats.LastSerial
+	if dSerial > 1 {
+		log.WithFields(log.Fields{
+			"LastSerial": stats.LastSerial,
+			"Serial":     cmd.ws.Serial,
+			"dSerial":    dSerial,
+		}).Info("missed packets")
+
+		stats.Missed += dSerial - 1
+		stats.Runs.Lost(dSerial - 1)
+	}
+
+	sendTime := cmd.ws.RecvTime
+	if cmd.ws.ClientSendTime != 0 {
+		sendTime = time.Unix(0, cmd.ws.ClientSendTime)
+	}
+
+	stats.Runs.Received(sendTime)
+
+	if cmd.ws.ClientSendTime != 0 {
+		transit := cmd.ws.RecvTime.Sub(sendTime)
+		stats.Jitter.Add(transit)
+
+		// delay variation is only measured between consecutive packets
+		if dSerial != 1 {
+			stats.IPDV.Break()
+		}
+
+		stats.IPDV.Add(transit)
+	}
+
+	stats.Window.advance(dSerial)
+	stats.Window.set(0)
+
+	stats.Received++
+	stats.LastSerial = cmd.ws.Serial
+	stats.LastUpdated = time.Now()
+
+	return nil
+}
+
+// restarted returns true if the client has restarted without its RESETPACKET reaching us.
+// Clients with sessions have restarted if the session changed. Clients without sessions have restarted if they start
+// again from serial 1, unless serial 1 was already received and this is a duplicate
+func (cmd *RecvPacketCommand) restarted(stats *ServerStats) bool {
+	if cmd.ws.Session != 0 || stats.Session != 0 {
+		return cmd.ws.Session != stats.Session
+	}
+
+	if cmd.ws.Serial != 1 || stats.LastSerial <= 1 {
+		return false
+	}
+
+	distance := stats.LastSerial - 1
+	return distance >= windowSize || !stats.Window.has(distance)
+}
+
+// doLate handles a packet whose serial is not newer than the last serial
+// packets within the receive window are either duplicates or reordered packets that were counted as missed
+func (cmd *RecvPacketCommand) doLate(stats *ServerStats) error {
+	distance := stats.LastSerial - cmd.ws.Serial
+	if distance >= windowSize {
 		log.WithFields(log.Fields{
 			"LastSerial": stats.LastSerial,
 			"Serial":     cmd.ws.Serial,
@@ -37,19 +117,45 @@ func (cmd *RecvPacketCommand) Do(sm *StatsMap) error {
 		}
 	}
 
-	dSerial := cmd.ws.Serial - stats.LastSerial
-	if dSerial > 1 {
+	if stats.Window.has(distance) {
 		log.WithFields(log.Fields{
 			"LastSerial": stats.LastSerial,
 			"Serial":     cmd.ws.Serial,
-			"dSerial":    dSerial,
-		}).Info("missed packets")
+		}).Debug("duplicate packet")
 
-		stats.Missed += dSerial
+		stats.Duplicates++
+		stats.LastUpdated = time.Now()
+
+		return nil
+	}
+
+	// loss runs are not corrected for late arrivals, the run was already recorded when the gap was seen
+	// RFC 4737 reordering extent: how many packets with a newer serial arrived before this one
+	extent := stats.Window.countNewer(distance)
+
+	log.WithFields(log.Fields{
+		"LastSerial": stats.LastSerial,
+		"Serial":     cmd.ws.Serial,
+		"Extent":     extent,
+	}).Debug("reordered packet")
+
+	stats.Window.set(distance)
+
+	if stats.Missed > 0 {
+		stats.Missed--
+	}
+
+	if cmd.ws.ClientSendTime != 0 {
+		stats.Jitter.Add(cmd.ws.RecvTime.Sub(time.Unix(0, cmd.ws.ClientSendTime)))
 	}
 
 	stats.Received++
-	stats.LastSerial = cmd.ws.Serial
+	stats.Reordered++
+	stats.ReorderExtentTotal += extent
+	if extent > stats.ReorderExtentMax {
+		stats.ReorderExtentMax = extent
+	}
+
 	stats.LastUpdated = time.Now()
 
 	return nil
@@ -0,0 +1,32 @@
+package server
+
+type ReplayPacketCommand struct {
+	ws wrapSerial
+
+	oldStats *ServerStats
+}
+
+func newReplayPacketCommand(ws wrapSerial) *ReplayPacketCommand {
+	oldStats := &ServerStats{}
+
+	return &ReplayPacketCommand{
+		ws,
+		oldStats,
+	}
+}
+
+func (cmd *ReplayPacketCommand) Do(sm *StatsMap) error {
+	stats := sm.Get(cmd.ws.ClientID)
+	stats.Clone(cmd.oldStats)
+
+	stats.Replayed++
+
+	return nil
+}
+
+func (cmd *ReplayPacketCommand) Undo(sm *StatsMap) error {
+	stats := sm.Get(cmd.ws.ClientID)
+	cmd.oldStats.Clone(stats)
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"github.com/stormentt/packetloss/report"
+)
+
+// Report converts the stats into a report for clientID
+func (stats *ServerStats) Report(clientID string) *report.Report {
+	r := report.New(report.RoleServer, clientID)
+	r.Remote = stats.Remote
+
+	totalPackets := stats.Received + stats.Missed
+
+	var percentLoss, percentReordered, avgExtent float64
+	if totalPackets != 0 {
+		percentLoss = float64(stats.Missed) / float64(totalPackets) * 100.0
+		percentReordered = float64(stats.Reordered) / float64(totalPackets) * 100.0
+	}
+
+	if stats.Reordered != 0 {
+		avgExtent = float64(stats.ReorderExtentTotal) / float64(stats.Reordered)
+	}
+
+	r.Server = &report.ServerReport{
+		Received:   stats.Received,
+		Missed:     stats.Missed,
+		Reordered:  stats.Reordered,
+		Duplicates: stats.Duplicates,
+		Replayed:   stats.Replayed,
+		LastUpdate: stats.LastUpdated,
+		Session:    stats.Session,
+
+		LossPercent:      percentLoss,
+		ReorderPercent:   percentReordered,
+		ReorderExtentAvg: avgExtent,
+		ReorderExtentMax: stats.ReorderExtentMax,
+
+		Variation: report.Variation{
+			Jitter:      int64(stats.Jitter.Value()),
+			IPDVMin:     int64(stats.IPDV.Min()),
+			IPDVMax:     int64(stats.IPDV.Max()),
+			IPDVMeanAbs: int64(stats.IPDV.MeanAbs()),
+		},
+		LossRuns: report.NewLossRuns(&stats.Runs),
+	}
+
+	return r
+}
+
+// Reports returns a report for every client
+func (sm *StatsMap) Reports() []*report.Report {
+	var reports []*report.Report
+	sm.each(func(client string, stats *ServerStats) {
+		reports = append(reports, stats.Report(client))
+	})
+
+	return reports
+}
@@ -1,5 +1,9 @@
 package server
 
+import (
+	log "github.com/sirupsen/logrus"
+)
+
 type ResetPacketCommand struct {
 	ws wrapSerial
 
@@ -18,8 +22,20 @@ func newResetPacketCommand(ws wrapSerial) *ResetPacketCommand {
 func (cmd *ResetPacketCommand) Do(sm *StatsMap) error {
 	stats := sm.Get(cmd.ws.ClientID)
 	stats.Clone(cmd.oldStats)
+	stats.Remote = cmd.ws.From.String()
+
+	// the client resends its reset until it is acknowledged, and its first packets may have already started the session
+	if cmd.ws.Session != 0 && cmd.ws.Session == stats.Session {
+		log.WithFields(log.Fields{
+			"ClientID": cmd.ws.ClientID,
+			"Session":  cmd.ws.Session,
+		}).Debug("session already reset")
+
+		return nil
+	}
 
 	stats.Reset()
+	stats.Session = cmd.ws.Session
 
 	return nil
 }
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package server
+
+import (
+	"errors"
+	"net"
+)
+
+// listenReusePort is only supported on Linux
+func listenReusePort(laddr *net.UDPAddr) (*net.UDPConn, error) {
+	return nil, errors.New("more than one worker is only supported on Linux")
+}
@@ -1,12 +1,20 @@
 package server
 
 import (
+	"errors"
 	"net"
+	"net/netip"
+	"runtime"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"github.com/stormentt/packetloss/batch"
+	"github.com/stormentt/packetloss/keyring"
+	"github.com/stormentt/packetloss/metrics"
 	packet "github.com/stormentt/packetloss/packet"
+	"github.com/stormentt/packetloss/report"
 	wrapper "github.com/stormentt/packetloss/wrapper"
 )
 
@@ -14,60 +22,244 @@ type wrapSerial struct {
 	Serial   uint64
 	From     *net.UDPAddr
 	ClientID string
+
+	// RecvTime is when the server received the packet, ClientSendTime is when the client claims to have sent it
+	RecvTime       time.Time
+	ClientSendTime int64
+
+	// Session is the client's session, echoed back on acks
+	Session uint64
+
+	// Key is the key that validated the packet, acks are signed with it
+	// Mode is how the packet was protected, acks are protected the same way
+	Key  keyring.Key
+	Mode wrapper.Mode
+
+	// Size is how many bytes the packet was on the wire, EchoSize is set if the client wants its ack padded to Size
+	Size     int
+	EchoSize bool
+}
+
+// Handler is given the packets a server doesn't answer itself: acks, and the matrix rows of mesh nodes.
+// data is the packet as it was received, and is only valid until the Handler returns. p is nil if the packet couldn't
+// be validated with the server's keys, acks are protected with the key their request was sent with, which the
+// server may not have
+type Handler func(p *packet.Packet, data []byte, addr *net.UDPAddr)
+
+// Options changes how ServeWith serves
+type Options struct {
+	// Other is given the packets the server doesn't answer itself if it isn't nil, so the socket can be shared with a client
+	Other Handler
+
+	// Sinks are published every report, the sinks in the config are used if it is nil
+	Sinks []metrics.Sink
 }
 
 // Listen listens for new packets and acks them, as well as keeping records
-// hkey is used to validate incoming messages via message authentication codes
-func Listen(hkey []byte, laddr *net.UDPAddr) error {
+// keys holds the keys used to validate each client's messages via message authentication codes.
+// Packets are received by the configured number of workers, each with its own SO_REUSEPORT socket if there's more than one
+func Listen(keys keyring.Keyring, laddr *net.UDPAddr) error {
+	workers := viper.GetInt("workers")
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	conns, err := listenWorkers(laddr, workers)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	return ServeAll(conns, keys, nil)
+}
+
+// listenWorkers opens n sockets listening on laddr. More than one socket is only possible with SO_REUSEPORT
+func listenWorkers(laddr *net.UDPAddr, n int) ([]*net.UDPConn, error) {
+	if n == 1 {
+		conn, err := net.ListenUDP("udp", laddr)
+		if err != nil {
+			return nil, err
+		}
+
+		return []*net.UDPConn{conn}, nil
+	}
+
+	conns := make([]*net.UDPConn, 0, n)
+	for i := 0; i < n; i++ {
+		conn, err := listenReusePort(laddr)
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+
+			return nil, err
+		}
+
+		// the first socket picks the port if laddr doesn't, the rest have to share it
+		if i == 0 {
+			laddr = conn.LocalAddr().(*net.UDPAddr)
+		}
+
+		conns = append(conns, conn)
+	}
+
+	return conns, nil
+}
+
+// Serve acks the packets received on conn and keeps records of them like Listen, until conn is closed.
+// Packets the server doesn't answer itself are passed to other if it isn't nil, so conn can be shared with a client
+func Serve(conn *net.UDPConn, keys keyring.Keyring, other Handler) error {
+	return ServeAll([]*net.UDPConn{conn}, keys, other)
+}
+
+// ServeAll is Serve with a worker for each of conns, which all share the same records. It returns once every conn is closed
+func ServeAll(conns []*net.UDPConn, keys keyring.Keyring, other Handler) error {
+	return ServeWith(conns, keys, Options{Other: other})
+}
+
+// ServeWith is ServeAll, changed by opts
+func ServeWith(conns []*net.UDPConn, keys keyring.Keyring, opts Options) error {
+	cullTime := viper.GetDuration("cull_time")
+	updateTime := viper.GetDuration("update-time")
+
 	log.WithFields(log.Fields{
-		"UpdateTime": viper.GetDuration("update-time"),
-		"CullTime":   viper.GetDuration("cull_time"),
+		"UpdateTime": updateTime,
+		"CullTime":   cullTime,
+		"Workers":    len(conns),
 	}).Debug("server params")
-	conn, err := net.ListenUDP("udp", laddr)
+
+	log.Info("handling connections")
+
+	out, err := report.FromConfig()
 	if err != nil {
 		return err
 	}
 
-	log.Info("handling connections")
-	defer conn.Close()
+	sinks := opts.Sinks
+	if sinks == nil {
+		sinks, err = metrics.FromConfig()
+		if err != nil {
+			return err
+		}
 
-	sMap := NewStatsMap()
-	lastCull := time.Now()
-	lastStatsPrint := time.Now()
+		defer metrics.Close(sinks)
+	}
 
-	ch := make(chan StatsCommand, 10)
-	go handleRecv(conn, hkey, ch)
+	sMap := NewStatsMap()
+	guard := wrapper.NewReplayGuard(viper.GetDuration("max_skew"))
+	bufSize := viper.GetInt("socket_buffer")
+	size := viper.GetInt("batch")
 
-	for cmd := range ch {
-		err = cmd.Do(sMap)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"Error": err,
-			}).Error("unable to execute command")
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		if bufSize > 0 {
+			err = batch.SetBuffers(conn, bufSize)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"Error": err,
+				}).Warn("could not set socket buffer size")
+			}
+		}
 
-			continue
+		w := &worker{
+			conn:  conn,
+			bc:    batch.NewConn(conn, size),
+			keys:  keys,
+			guard: guard,
+			sMap:  sMap,
+			other: opts.Other,
+			hints: make(map[netip.AddrPort][]byte),
 		}
 
-		if time.Since(lastCull) > viper.GetDuration("cull_time") {
-			sMap.Cull()
-			lastCull = time.Now()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.run()
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	cull := time.NewTicker(cullTime)
+	defer cull.Stop()
+
+	update := time.NewTicker(updateTime)
+	defer update.Stop()
+
+	for {
+		select {
+		case <-cull.C:
+			for _, clientID := range sMap.Cull() {
+				metrics.Forget(sinks, clientID)
+			}
+		case <-update.C:
+			outputStats(out, sinks, sMap)
+		case <-done:
+			return nil
 		}
+	}
+}
+
+// outputStats writes every client's stats to out as reports, or logs them if out is nil
+// the reports are also published to every metrics sink
+func outputStats(out *report.Writer, sinks []metrics.Sink, sm *StatsMap) {
+	reports := sm.Reports()
+	for _, r := range reports {
+		metrics.Publish(sinks, r)
+	}
 
-		if time.Since(lastStatsPrint) > viper.GetDuration("update-time") {
-			sMap.Print()
-			lastStatsPrint = time.Now()
+	if out == nil {
+		sm.Print()
+		return
+	}
+
+	for _, r := range reports {
+		err := out.Write(r)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Error": err,
+			}).Error("could not write report")
 		}
 	}
+}
+
+// worker receives packets from one socket, a batch at a time, then records & acks them.
+// Packets from clients without a key in keys are dropped, and replays caught by guard are counted then dropped.
+// Packets the server doesn't answer are given to other
+type worker struct {
+	conn *net.UDPConn
+	bc   *batch.Conn
+
+	keys  keyring.Keyring
+	guard *wrapper.ReplayGuard
+	sMap  *StatsMap
+	other Handler
 
-	return nil
+	// hints are the legacy keys that last decrypted each sender's packets, tried before every other key
+	hints map[netip.AddrPort][]byte
 }
 
-// handleRecv receives packets from conn and acknowledges them
-// received serial numbers are sent over ch for record keeping
-func handleRecv(conn *net.UDPConn, hkey []byte, ch chan<- StatsCommand) {
+// maxKeyHints is how many senders a worker remembers the keys of before starting again
+const maxKeyHints = 65536
+
+// run handles packets until the worker's socket is closed. Acks are queued up while the batch is handled,
+// and written together
+func (w *worker) run() {
 	for {
-		buff := make([]byte, 1024)
-		n, addr, err := conn.ReadFromUDP(buff)
+		msgs, err := w.bc.Read()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+
 		if err != nil {
 			log.WithFields(log.Fields{
 				"error": err,
@@ -75,75 +267,303 @@ func handleRecv(conn *net.UDPConn, hkey []byte, ch chan<- StatsCommand) {
 			continue
 		}
 
+		ts := time.Now()
+
+		// decoded packets don't keep any reference to the batch's buffers, so they can be reused
+		for _, msg := range msgs {
+			addr, ok := msg.Addr.(*net.UDPAddr)
+			if !ok {
+				continue
+			}
+
+			w.handlePacket(msg.Buffers[0], msg.N, addr, ts)
+		}
+
+		flushAcks(w.bc)
+	}
+}
+
+// do applies cmd to ws's client's stats. Received packets are acked once they are recorded so the acks can carry the
+// server's received count
+func (w *worker) do(cmd StatsCommand, ws wrapSerial) {
+	unlock := w.sMap.Lock(ws.ClientID)
+	err := cmd.Do(w.sMap)
+
+	if _, ok := cmd.(*RecvPacketCommand); ok {
+		sendAck(w.bc, w.sMap, ws)
+	}
+
+	unlock()
+
+	if _, ok := cmd.(*ResetPacketCommand); ok {
+		sendResetAck(w.conn, ws)
+	}
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Error": err,
+		}).Error("unable to execute command")
+	}
+}
+
+// handlePacket handles a packet of n bytes in buff, received from addr at ts. See worker
+func (w *worker) handlePacket(buff []byte, n int, addr *net.UDPAddr, ts time.Time) {
+	if log.IsLevelEnabled(log.TraceLevel) {
 		log.WithFields(log.Fields{
 			"n":    n,
 			"addr": addr,
 		}).Trace("received packet")
+	}
+
+	ap := addr.AddrPort()
+
+	p := &packet.Packet{}
+	key, mode, err := wrapper.DecodeClientPacket(buff, n, w.keys, p, w.hints[ap])
+	if err != nil && w.other != nil {
+		// it may be an ack, which the handler can validate with the key its request was sent with
+		w.other(nil, buff[:n], addr)
+		return
+	}
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"addr":  addr,
+		}).Error("could not decode packet")
+
+		return
+	}
+
+	if mode == wrapper.ModeAEAD && key.ID == 0 {
+		if len(w.hints) >= maxKeyHints {
+			w.hints = make(map[netip.AddrPort][]byte)
+		}
+
+		w.hints[ap] = key.MAC
+	}
+
+	if p.PacketType == packet.PacketType_HELLOPACKET {
+		sendHelloAck(w.conn, key, mode, p, n, ts, addr)
+		return
+	}
+
+	// acks answer the requests of the client sharing the socket, and are protected however it chose to send them
+	if w.other != nil && isAck(p.PacketType) {
+		w.other(p, buff[:n], addr)
+		return
+	}
 
-		p := &packet.Packet{}
-		err = wrapper.DecodePacket(buff, n, hkey, p)
+	// hellos are answered however they are protected so the client can be told why it is rejected, nothing else is
+	// let through unencrypted, including the packets passed on to other
+	if mode != wrapper.ModeAEAD && viper.GetBool("require_encryption") {
+		log.WithFields(log.Fields{
+			"ClientID": p.ClientID,
+			"type":     p.PacketType.String(),
+			"addr":     addr,
+		}).Warn("rejected unencrypted packet")
+
+		return
+	}
+
+	if w.other != nil && !isRequest(p.PacketType) {
+		// packets outside of sessions, like matrix rows, can only be checked for replays by their timestamp
+		err = w.guard.CheckTime(p, ts)
 		if err != nil {
 			log.WithFields(log.Fields{
-				"error": err,
-			}).Error("could not decode packet")
+				"Error":    err,
+				"ClientID": p.ClientID,
+				"type":     p.PacketType.String(),
+				"addr":     addr,
+			}).Warn("rejected packet")
 
-			continue
+			return
 		}
 
+		w.other(p, buff[:n], addr)
+		return
+	}
+
+	if p.PayloadSize != 0 && p.PayloadSize != uint32(n) {
+		log.WithFields(log.Fields{
+			"ClientID":    p.ClientID,
+			"Serial":      p.Serial,
+			"PayloadSize": p.PayloadSize,
+			"Received":    n,
+		}).Warn("packet size does not match its payload size")
+	}
+
+	if log.IsLevelEnabled(log.TraceLevel) {
 		log.WithFields(log.Fields{
 			"PacketType": p.PacketType.String(),
 			"Serial":     p.Serial,
 			"ClientID":   p.ClientID,
 		}).Trace("decoded packet")
+	}
+
+	if p.Session != 0 || !viper.GetBool("allow_no_session") {
+		err = w.guard.Check(p, ts)
+
+		var replayErr *wrapper.ReplayError
+		if errors.As(err, &replayErr) && replayErr.Duplicate {
+			// a packet already seen in this session is far more likely duplicated by the network than replayed
+			log.WithFields(log.Fields{
+				"ClientID": p.ClientID,
+				"Serial":   p.Serial,
+				"addr":     addr,
+			}).Debug("duplicate packet")
 
-		switch p.PacketType {
-		case packet.PacketType_REQPACKET:
 			ws := wrapSerial{
 				Serial:   p.Serial,
 				From:     addr,
 				ClientID: p.ClientID,
+				RecvTime: ts,
 			}
 
-			reqCmd := newRecvPacketCommand(ws)
-			ch <- reqCmd
+			w.do(newDuplicatePacketCommand(ws), ws)
+
+			return
+		}
+
+		if errors.As(err, &replayErr) {
+			log.WithFields(log.Fields{
+				"Error":    err,
+				"ClientID": p.ClientID,
+				"Serial":   p.Serial,
+				"addr":     addr,
+			}).Warn("rejected packet")
 
-			sendAck(conn, hkey, ch, ws)
-		case packet.PacketType_RESETPACKET:
 			ws := wrapSerial{
 				Serial:   p.Serial,
 				From:     addr,
 				ClientID: p.ClientID,
+				RecvTime: ts,
 			}
 
-			resetCmd := newResetPacketCommand(ws)
-			ch <- resetCmd
-		default:
-			log.WithFields(log.Fields{
-				"type": p.PacketType.String(),
-			}).Warn("received an unexpected packet type")
+			w.do(newReplayPacketCommand(ws), ws)
 
-			continue
+			return
 		}
 	}
+
+	switch p.PacketType {
+	case packet.PacketType_REQPACKET:
+		ws := wrapSerial{
+			Serial:         p.Serial,
+			From:           addr,
+			ClientID:       p.ClientID,
+			RecvTime:       ts,
+			ClientSendTime: p.ClientSendTime,
+			Session:        p.Session,
+			Key:            key,
+			Mode:           mode,
+			Size:           n,
+			EchoSize:       p.EchoSize,
+		}
+
+		w.do(newRecvPacketCommand(ws), ws)
+	case packet.PacketType_RESETPACKET:
+		ws := wrapSerial{
+			Serial:         p.Serial,
+			From:           addr,
+			ClientID:       p.ClientID,
+			RecvTime:       ts,
+			ClientSendTime: p.ClientSendTime,
+			Session:        p.Session,
+			Key:            key,
+			Mode:           mode,
+		}
+
+		w.do(newResetPacketCommand(ws), ws)
+	default:
+		log.WithFields(log.Fields{
+			"type": p.PacketType.String(),
+		}).Warn("received an unexpected packet type")
+
+		return
+	}
+}
+
+// isRequest returns true for the packet types a server answers
+func isRequest(t packet.PacketType) bool {
+	switch t {
+	case packet.PacketType_REQPACKET, packet.PacketType_RESETPACKET, packet.PacketType_HELLOPACKET:
+		return true
+	}
+
+	return false
+}
+
+// isAck returns true for the packets the server sends in answer to requests
+func isAck(t packet.PacketType) bool {
+	switch t {
+	case packet.PacketType_ACKPACKET, packet.PacketType_RESETACKPACKET, packet.PacketType_HELLOACKPACKET:
+		return true
+	}
+
+	return false
 }
 
-func sendAck(conn *net.UDPConn, hkey []byte, ch chan<- StatsCommand, ws wrapSerial) {
+// sendResetAck acknowledges a RESETPACKET, the client keeps resending it until it sees this
+func sendResetAck(conn *net.UDPConn, ws wrapSerial) {
 	ackPacket := packet.Packet{
-		Serial:     ws.Serial,
-		PacketType: packet.PacketType_ACKPACKET,
-		ClientID:   ws.ClientID,
+		Serial:         ws.Serial,
+		PacketType:     packet.PacketType_RESETACKPACKET,
+		ClientID:       ws.ClientID,
+		ClientSendTime: ws.ClientSendTime,
+		ServerRecvTime: ws.RecvTime.UnixNano(),
+		ServerSendTime: time.Now().UnixNano(),
+		Session:        ws.Session,
 	}
 
-	data, err := wrapper.EncodePacket(&ackPacket, hkey)
+	data, err := wrapper.Encode(&ackPacket, ws.Key, ws.Mode)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"Error": err,
-		}).Error("could not encode ack packet")
+		}).Error("could not encode reset ack packet")
 
 		return
 	}
 
 	_, err = conn.WriteTo(data, ws.From)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Error": err,
+		}).Error("could not send reset ack packet")
+	}
+}
+
+// sendAck queues the ack of a received packet in bc & records the acknowledgement in sm, the client must be locked.
+// The ack is protected with the key & mode the packet was protected with
+func sendAck(bc *batch.Conn, sm *StatsMap, ws wrapSerial) {
+	stats := sm.Get(ws.ClientID)
+
+	ackPacket := packet.Packet{
+		Serial:         ws.Serial,
+		PacketType:     packet.PacketType_ACKPACKET,
+		ClientID:       ws.ClientID,
+		ClientSendTime: ws.ClientSendTime,
+		ServerRecvTime: ws.RecvTime.UnixNano(),
+		ServerSendTime: time.Now().UnixNano(),
+		ServerReceived: stats.Received,
+		Session:        ws.Session,
+		ReceivedSize:   uint32(ws.Size),
+	}
+
+	var echoSize int
+	if ws.EchoSize {
+		echoSize = ws.Size
+	}
+
+	data, err := wrapper.EncodePadded(&ackPacket, ws.Key, ws.Mode, echoSize)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Error": err,
+		}).Error("could not encode ack packet")
+
+		return
+	}
+
+	err = bc.Queue(data, ws.From)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"Error": err,
@@ -153,6 +573,20 @@ func sendAck(conn *net.UDPConn, hkey []byte, ch chan<- StatsCommand, ws wrapSeri
 	}
 
 	ackCmd := newAckPacketCommand(ws)
+	err = ackCmd.Do(sm)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Error": err,
+		}).Error("unable to execute command")
+	}
+}
 
-	ch <- ackCmd
+// flushAcks writes the acks queued in bc
+func flushAcks(bc *batch.Conn) {
+	err := bc.Flush()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Error": err,
+		}).Error("could not send ack packets")
+	}
 }
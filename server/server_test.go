@@ -0,0 +1,201 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stormentt/packetloss/batch"
+	"github.com/stormentt/packetloss/keyring"
+	packet "github.com/stormentt/packetloss/packet"
+	wrapper "github.com/stormentt/packetloss/wrapper"
+)
+
+// TestWorkers serves with several workers sharing a port, and sends to it from many clients at once.
+// Every client's packets have to be counted once whichever worker they reach
+func TestWorkers(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("more than one worker is only supported on Linux")
+	}
+
+	t.Cleanup(viper.Reset)
+	viper.Set("key", "secret")
+	viper.Set("cull_time", time.Minute)
+	viper.Set("update-time", time.Minute)
+	viper.Set("max_skew", time.Minute)
+	viper.Set("batch", 8)
+
+	keys, err := keyring.FromConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conns, err := listenWorkers(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+
+	go ServeAll(conns, keys, nil)
+
+	const clients = 16
+	const packets = 50
+
+	key := keyring.NewKey("secret", 0)
+	raddr := conns[0].LocalAddr().(*net.UDPAddr)
+
+	var wg sync.WaitGroup
+	for c := 0; c < clients; c++ {
+		clientID := fmt.Sprintf("client %d", c)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			received, err := sendAndAck(raddr, key, clientID, packets)
+			if err != nil {
+				t.Errorf("%s: %v", clientID, err)
+				return
+			}
+
+			if received != packets {
+				t.Errorf("%s: server received %d packets, expected %d", clientID, received, packets)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// sendAndAck sends n REQPACKETs to raddr as clientID, waiting for each one's ack, and returns how many packets the
+// last ack says the server received
+func sendAndAck(raddr *net.UDPAddr, key keyring.Key, clientID string, n int) (uint64, error) {
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return 0, err
+	}
+
+	defer conn.Close()
+
+	var received uint64
+	buff := make([]byte, packet.MaxPacketSize)
+
+	for serial := uint64(1); serial <= uint64(n); serial++ {
+		data, err := wrapper.Encode(&packet.Packet{
+			PacketType:     packet.PacketType_REQPACKET,
+			Serial:         serial,
+			ClientID:       clientID,
+			ClientSendTime: time.Now().UnixNano(),
+			Session:        1,
+		}, key, wrapper.ModeHMAC)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = conn.Write(data)
+		if err != nil {
+			return 0, err
+		}
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		read, err := conn.Read(buff)
+		if err != nil {
+			return 0, fmt.Errorf("no ack for serial %d: %w", serial, err)
+		}
+
+		ack := &packet.Packet{}
+		_, err = wrapper.DecodePacket(buff, read, key, ack)
+		if err != nil {
+			return 0, err
+		}
+
+		if ack.PacketType != packet.PacketType_ACKPACKET || ack.Serial != serial {
+			return 0, fmt.Errorf("got %v for serial %d, expected its ack", ack.PacketType, serial)
+		}
+
+		received = ack.ServerReceived
+	}
+
+	return received, nil
+}
+
+// TestOtherChecks checks the packets passed on to other, like mesh matrix rows, must be encrypted when the server
+// requires encryption & recent, acks are passed on however they are protected
+func TestOtherChecks(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	viper.Set("key", "secret")
+	viper.Set("require_encryption", true)
+
+	keys, err := keyring.FromConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	var passed bool
+
+	w := &worker{
+		conn:  conn,
+		bc:    batch.NewConn(conn, 1),
+		keys:  keys,
+		guard: wrapper.NewReplayGuard(time.Minute),
+		sMap:  NewStatsMap(),
+		other: func(p *packet.Packet, data []byte, addr *net.UDPAddr) {
+			passed = true
+		},
+		hints: make(map[netip.AddrPort][]byte),
+	}
+
+	key := keyring.NewKey("secret", 0)
+	from := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 7000}
+	now := time.Now()
+
+	cases := []struct {
+		name   string
+		t      packet.PacketType
+		mode   wrapper.Mode
+		sent   time.Time
+		passed bool
+	}{
+		{"encrypted row", packet.PacketType_MATRIXPACKET, wrapper.ModeAEAD, now, true},
+		{"unencrypted row", packet.PacketType_MATRIXPACKET, wrapper.ModeHMAC, now, false},
+		{"stale row", packet.PacketType_MATRIXPACKET, wrapper.ModeAEAD, now.Add(-time.Hour), false},
+		{"unencrypted ack", packet.PacketType_ACKPACKET, wrapper.ModeHMAC, now, true},
+	}
+
+	for _, tc := range cases {
+		data, err := wrapper.Encode(&packet.Packet{
+			PacketType:     tc.t,
+			ClientID:       "peer",
+			Serial:         1,
+			ClientSendTime: tc.sent.UnixNano(),
+		}, key, tc.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		passed = false
+		w.handlePacket(data, len(data), from, now)
+
+		if passed != tc.passed {
+			t.Errorf("%s: passed on %v, expected %v", tc.name, passed, tc.passed)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import "math/bits"
+
+// windowSize is how many serials behind the newest serial are tracked for reordering & duplicates
+const windowSize = 256
+
+// receiveWindow is a bitmap of recently received serials
+// bit n is set if serial LastSerial-n has been received
+type receiveWindow [windowSize / 64]uint64
+
+// advance moves the window forward by n serials
+func (w *receiveWindow) advance(n uint64) {
+	if n >= windowSize {
+		*w = receiveWindow{}
+		return
+	}
+
+	words := int(n / 64)
+	shift := n % 64
+
+	for i := len(w) - 1; i >= 0; i-- {
+		var v uint64
+
+		src := i - words
+		if src >= 0 {
+			v = w[src] << shift
+
+			if shift > 0 && src > 0 {
+				v |= w[src-1] >> (64 - shift)
+			}
+		}
+
+		w[i] = v
+	}
+}
+
+// has returns true if the serial distance behind the newest serial has been received
+func (w *receiveWindow) has(distance uint64) bool {
+	return w[distance/64]&(1<<(distance%64)) != 0
+}
+
+// set marks the serial distance behind the newest serial as received
+func (w *receiveWindow) set(distance uint64) {
+	w[distance/64] |= 1 << (distance % 64)
+}
+
+// countNewer returns how many serials newer than distance behind the newest serial have been received
+func (w *receiveWindow) countNewer(distance uint64) uint64 {
+	var count int
+
+	for i := uint64(0); i < distance/64; i++ {
+		count += bits.OnesCount64(w[i])
+	}
+
+	if rem := distance % 64; rem > 0 {
+		count += bits.OnesCount64(w[distance/64] & (1<<rem - 1))
+	}
+
+	return uint64(count)
+}
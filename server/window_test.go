@@ -0,0 +1,91 @@
+package server
+
+import "testing"
+
+// windowSerials returns the distances set in w
+func windowSerials(w *receiveWindow) []uint64 {
+	var set []uint64
+	for d := uint64(0); d < windowSize; d++ {
+		if w.has(d) {
+			set = append(set, d)
+		}
+	}
+
+	return set
+}
+
+func TestWindowAdvance(t *testing.T) {
+	var w receiveWindow
+	w.set(0)
+	w.set(63)
+
+	steps := []struct {
+		n        uint64
+		expected []uint64
+	}{
+		{0, []uint64{0, 63}},
+		{1, []uint64{1, 64}},
+		{67, []uint64{68, 131}},
+		{124, []uint64{192, 255}},
+		{1, []uint64{193}},
+		{63, nil},
+	}
+
+	for _, step := range steps {
+		w.advance(step.n)
+
+		if got := windowSerials(&w); !equalSerials(got, step.expected) {
+			t.Fatalf("advanced %d, set %v, expected %v", step.n, got, step.expected)
+		}
+	}
+
+	w.set(0)
+	w.set(10)
+	w.advance(windowSize)
+
+	if got := windowSerials(&w); got != nil {
+		t.Errorf("advanced a whole window, set %v", got)
+	}
+}
+
+func TestWindowCountNewer(t *testing.T) {
+	var w receiveWindow
+	for _, d := range []uint64{0, 5, 63, 64, 100} {
+		w.set(d)
+	}
+
+	cases := []struct {
+		distance uint64
+		expected uint64
+	}{
+		{0, 0},
+		{1, 1},
+		{6, 2},
+		{63, 2},
+		{64, 3},
+		{65, 4},
+		{100, 4},
+		{101, 5},
+		{255, 5},
+	}
+
+	for _, tc := range cases {
+		if got := w.countNewer(tc.distance); got != tc.expected {
+			t.Errorf("countNewer(%d) = %d, expected %d", tc.distance, got, tc.expected)
+		}
+	}
+}
+
+func equalSerials(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
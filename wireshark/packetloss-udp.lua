@@ -1,14 +1,60 @@
 packetloss_proto = Proto("packetloss","Packetloss Protocol")
 
+-- encrypted packets start with this byte, then a 24 byte nonce, then the ciphertext & 16 byte tag.
+-- 1 in 256 HMAC packets also start with it, packetloss tells them apart by trying to decrypt
+local AEAD_HEADER = 0xA1
+local AEAD_NONCE_SIZE = 24
+local AEAD_TAG_SIZE = 16
+
+-- packets protected by a key with an ID start with one of these bytes and the 4 byte key ID, then the same frame as above
+local HMAC_KEYED_HEADER = 0xA2
+local AEAD_KEYED_HEADER = 0xA3
+local KEY_ID_SIZE = 4
+local HMAC_SIZE = 32
+
+local function dissect_aead(buffer,subtree,offset)
+  local sealed = buffer:len() - offset - AEAD_NONCE_SIZE
+  subtree:add(buffer(offset,AEAD_NONCE_SIZE),"Nonce " .. buffer(offset,AEAD_NONCE_SIZE):bytes():tohex())
+  subtree:add(buffer(offset + AEAD_NONCE_SIZE,sealed),"Encrypted Packet (" .. sealed .. " bytes)")
+end
+
+local function dissect_hmac(buffer,pinfo,subtree,offset)
+  subtree:add(buffer(offset,HMAC_SIZE),"HMAC " .. buffer(offset,HMAC_SIZE):bytes():tohex())
+
+  local protobuf_dissector = Dissector.get("protobuf")
+  pinfo.private["pb_msg_type"] = "message,packet.Packet"
+
+  pcall(Dissector.call, protobuf_dissector, buffer(offset + HMAC_SIZE):tvb(), pinfo, subtree)
+end
+
 function packetloss_proto.dissector(buffer,pinfo,tree)
   pinfo.cols.protocol = "PACKETLOSS"
   local subtree = tree:add(packetloss_proto,buffer(),"Packetloss Protocol Data")
-  subtree:add(buffer(0,32),"HMAC " .. buffer(0,32):bytes():tohex())
 
-  local protobuf_dissector = Dissector.get("protobuf")
-  pinfo.private["pb_msg_type"] = "message,packet.Packet"
+  local header = buffer(0,1):uint()
+  local keyed = 1 + KEY_ID_SIZE
+
+  if buffer:len() >= keyed + AEAD_NONCE_SIZE + AEAD_TAG_SIZE and header == AEAD_KEYED_HEADER then
+    subtree:add(buffer(0,1),"Header (XChaCha20-Poly1305, key ID)")
+    subtree:add(buffer(1,KEY_ID_SIZE),"Key ID " .. buffer(1,KEY_ID_SIZE):uint())
+    dissect_aead(buffer,subtree,keyed)
+    return
+  end
+
+  if buffer:len() >= keyed + HMAC_SIZE and header == HMAC_KEYED_HEADER then
+    subtree:add(buffer(0,1),"Header (HMAC, key ID)")
+    subtree:add(buffer(1,KEY_ID_SIZE),"Key ID " .. buffer(1,KEY_ID_SIZE):uint())
+    dissect_hmac(buffer,pinfo,subtree,keyed)
+    return
+  end
+
+  if buffer:len() >= 1 + AEAD_NONCE_SIZE + AEAD_TAG_SIZE and header == AEAD_HEADER then
+    subtree:add(buffer(0,1),"Header (XChaCha20-Poly1305)")
+    dissect_aead(buffer,subtree,1)
+    return
+  end
 
-  pcall(Dissector.call, protobuf_dissector, buffer(32):tvb(), pinfo, subtree)
+  dissect_hmac(buffer,pinfo,subtree,0)
 end
 
 udp_table = DissectorTable.get("udp.port")
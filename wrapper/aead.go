@@ -0,0 +1,123 @@
+package wrappers
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+
+	packet "github.com/stormentt/packetloss/packet"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20poly1305"
+	"google.golang.org/protobuf/proto"
+)
+
+// AEAD_HEADER is the first byte of a legacy encrypted packet, it is followed by a random nonce and the sealed protobuf packet.
+// Legacy HMAC packets start with their MAC, so 1 in 256 of them start with this byte too. Decoding falls back to the
+// legacy HMAC framing if an encrypted packet can't be opened
+const AEAD_HEADER = 0xA1
+
+// AEAD_OVERHEAD is how many bytes longer a legacy encrypted packet is than its protobuf packet
+const AEAD_OVERHEAD = 1 + chacha20poly1305.NonceSizeX + chacha20poly1305.Overhead
+
+// Mode is how a packet is protected on the wire
+type Mode int
+
+const (
+	// ModeHMAC packets are authenticated with a keyed Blake2b hash, but readable by anyone
+	ModeHMAC Mode = iota
+	// ModeAEAD packets are encrypted & authenticated with XChaCha20-Poly1305
+	ModeAEAD
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeHMAC:
+		return "hmac"
+	case ModeAEAD:
+		return "aead"
+	default:
+		return "unknown"
+	}
+}
+
+// EncodePacketAEAD encrypts a protobuf packet with XChaCha20-Poly1305 in the legacy framing
+// the encryption key is derived from hkey, and the header byte is authenticated along with the packet
+func EncodePacketAEAD(p *packet.Packet, hkey []byte) ([]byte, error) {
+	data, err := proto.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return sealAEAD([]byte{AEAD_HEADER}, hkey, data)
+}
+
+// sealAEAD encrypts data, returning the header, a random nonce, and the sealed data. The header is authenticated too
+func sealAEAD(header, hkey, data []byte) ([]byte, error) {
+	aead, err := newAEAD(hkey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := randomNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(header) + len(nonce) + len(data) + aead.Overhead())
+	out.Write(header)
+	out.Write(nonce)
+	out.Write(aead.Seal(nil, nonce, data, header))
+
+	return out.Bytes(), nil
+}
+
+// openAEAD decrypts a packet sealed by sealAEAD with a headerLen byte header, using the key derived from hkey
+// it returns false if frame is too short or wasn't encrypted with hkey
+func openAEAD(frame []byte, headerLen int, hkey []byte) ([]byte, bool) {
+	if len(frame) < headerLen+chacha20poly1305.NonceSizeX+chacha20poly1305.Overhead {
+		return nil, false
+	}
+
+	aead, err := newAEAD(hkey)
+	if err != nil {
+		return nil, false
+	}
+
+	nonce := frame[headerLen : headerLen+chacha20poly1305.NonceSizeX]
+	plain, err := aead.Open(nil, nonce, frame[headerLen+chacha20poly1305.NonceSizeX:], frame[:headerLen])
+	if err != nil {
+		return nil, false
+	}
+
+	return plain, true
+}
+
+// openLegacyAEAD decrypts a legacy encrypted packet, returning false if it isn't one or wasn't encrypted with hkey
+func openLegacyAEAD(frame []byte, hkey []byte) ([]byte, bool) {
+	if len(frame) == 0 || frame[0] != AEAD_HEADER {
+		return nil, false
+	}
+
+	return openAEAD(frame, 1, hkey)
+}
+
+// newAEAD creates the cipher for hkey. The MAC key isn't used directly, a separate encryption key is derived from it
+func newAEAD(hkey []byte) (cipher.AEAD, error) {
+	hasher, err := blake2b.New256(hkey)
+	if err != nil {
+		return nil, err
+	}
+
+	hasher.Write([]byte("packetloss aead key"))
+
+	return chacha20poly1305.NewX(hasher.Sum(nil))
+}
+
+// randomNonce returns a random XChaCha20-Poly1305 nonce, which is large enough that random nonces won't repeat
+func randomNonce() ([]byte, error) {
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	_, err := rand.Read(nonce)
+
+	return nonce, err
+}
@@ -0,0 +1,110 @@
+package wrappers
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/binary"
+
+	"github.com/stormentt/packetloss/keyring"
+	packet "github.com/stormentt/packetloss/packet"
+	"google.golang.org/protobuf/proto"
+)
+
+// HMAC_KEYED_HEADER & AEAD_KEYED_HEADER start packets protected by a key with an ID.
+// The header byte is followed by the key ID, then the frame is the same as the legacy one for that mode.
+// The header & key ID are covered by the MAC or the AEAD tag
+const (
+	HMAC_KEYED_HEADER = 0xA2
+	AEAD_KEYED_HEADER = 0xA3
+)
+
+// KEYED_HEADER_SIZE is the length of the header byte & key ID
+const KEYED_HEADER_SIZE = 1 + 4
+
+// Encode protects p with key using mode. Keys with an ID are sent in the keyed framing, other keys in the legacy framing
+func Encode(p *packet.Packet, key keyring.Key, mode Mode) ([]byte, error) {
+	if key.ID == 0 {
+		if mode == ModeAEAD {
+			return EncodePacketAEAD(p, key.MAC)
+		}
+
+		return EncodePacket(p, key.MAC)
+	}
+
+	data, err := proto.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, KEYED_HEADER_SIZE)
+	binary.BigEndian.PutUint32(header[1:], key.ID)
+
+	if mode == ModeAEAD {
+		header[0] = AEAD_KEYED_HEADER
+		return sealAEAD(header, key.MAC, data)
+	}
+
+	header[0] = HMAC_KEYED_HEADER
+
+	calc_hmac := make([]byte, HMAC_SIZE)
+	err = hmac_data(calc_hmac, key.MAC, append(header, data...))
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(header) + len(calc_hmac) + len(data))
+	out.Write(header)
+	out.Write(calc_hmac)
+	out.Write(data)
+
+	return out.Bytes(), nil
+}
+
+// keyedHeader returns the key ID & mode of a packet in the keyed framing, or false if frame doesn't start with a keyed header
+// legacy HMAC packets can start with the same bytes, so a packet that fails to open may still be a legacy packet
+func keyedHeader(frame []byte) (uint32, Mode, bool) {
+	if len(frame) < KEYED_HEADER_SIZE {
+		return 0, ModeHMAC, false
+	}
+
+	id := binary.BigEndian.Uint32(frame[1:KEYED_HEADER_SIZE])
+
+	switch frame[0] {
+	case HMAC_KEYED_HEADER:
+		return id, ModeHMAC, true
+	case AEAD_KEYED_HEADER:
+		return id, ModeAEAD, true
+	default:
+		return 0, ModeHMAC, false
+	}
+}
+
+// openKeyed validates or decrypts a packet in the keyed framing, returning the protobuf packet
+// it returns false if the packet wasn't protected with mac
+func openKeyed(frame []byte, mode Mode, mac []byte) ([]byte, bool) {
+	if mode == ModeAEAD {
+		return openAEAD(frame, KEYED_HEADER_SIZE, mac)
+	}
+
+	if len(frame) < KEYED_HEADER_SIZE+HMAC_SIZE {
+		return nil, false
+	}
+
+	data := frame[KEYED_HEADER_SIZE+HMAC_SIZE:]
+	signed := make([]byte, 0, KEYED_HEADER_SIZE+len(data))
+	signed = append(signed, frame[:KEYED_HEADER_SIZE]...)
+	signed = append(signed, data...)
+
+	calc_hmac := make([]byte, HMAC_SIZE)
+	err := hmac_data(calc_hmac, mac, signed)
+	if err != nil {
+		return nil, false
+	}
+
+	if subtle.ConstantTimeCompare(calc_hmac, frame[KEYED_HEADER_SIZE:KEYED_HEADER_SIZE+HMAC_SIZE]) != 1 {
+		return nil, false
+	}
+
+	return data, true
+}
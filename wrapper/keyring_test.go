@@ -0,0 +1,82 @@
+package wrappers
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stormentt/packetloss/keyring"
+	packet "github.com/stormentt/packetloss/packet"
+)
+
+// countingKeyring is a Keyring of legacy per-client keys that counts how often every key is asked for
+type countingKeyring struct {
+	keys  map[string][]byte
+	scans int
+}
+
+func (ck *countingKeyring) Key(clientID string) ([]byte, bool) {
+	key, ok := ck.keys[clientID]
+	return key, ok
+}
+
+func (ck *countingKeyring) Keys() [][]byte {
+	ck.scans++
+
+	keys := make([][]byte, 0, len(ck.keys))
+	for _, key := range ck.keys {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+func (ck *countingKeyring) ByID(id uint32) (keyring.Key, bool) {
+	return keyring.Key{}, false
+}
+
+func TestDecodeClientPacketHint(t *testing.T) {
+	kr := &countingKeyring{
+		keys: map[string][]byte{
+			"a": keyring.Derive("key a"),
+			"b": keyring.Derive("key b"),
+			"c": keyring.Derive("key c"),
+		},
+	}
+
+	data, err := Encode(&packet.Packet{
+		PacketType: packet.PacketType_REQPACKET,
+		ClientID:   "b",
+		Serial:     7,
+	}, keyring.Key{MAC: kr.keys["b"]}, ModeAEAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name  string
+		hint  []byte
+		scans int
+	}{
+		{"no hint", nil, 1},
+		{"right hint", kr.keys["b"], 0},
+		{"wrong hint", kr.keys["a"], 1},
+	}
+
+	for _, c := range cases {
+		kr.scans = 0
+
+		p := &packet.Packet{}
+		key, mode, err := DecodeClientPacket(data, len(data), kr, p, c.hint)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+
+		if mode != ModeAEAD || !bytes.Equal(key.MAC, kr.keys["b"]) || p.ClientID != "b" || p.Serial != 7 {
+			t.Errorf("%s: decoded the wrong packet or key", c.name)
+		}
+
+		if kr.scans != c.scans {
+			t.Errorf("%s: tried every key %d times, want %d", c.name, kr.scans, c.scans)
+		}
+	}
+}
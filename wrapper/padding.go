@@ -0,0 +1,65 @@
+package wrappers
+
+import (
+	"github.com/stormentt/packetloss/keyring"
+	packet "github.com/stormentt/packetloss/packet"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// paddingField is the field number of packet.Packet.Padding
+const paddingField = 14
+
+// EncodePadded is like Encode, but pads p inside the protected payload so the encoded packet is size bytes long, and
+// sets p.PayloadSize to the encoded size. Padding can't always hit size exactly, a packet that would be a few bytes
+// short is padded a few bytes over instead. Packets already larger than size are sent unpadded.
+// A size of 0 sends p unpadded with no PayloadSize
+func EncodePadded(p *packet.Packet, key keyring.Key, mode Mode, size int) ([]byte, error) {
+	p.Padding = nil
+	p.PayloadSize = 0
+
+	if size == 0 {
+		return Encode(p, key, mode)
+	}
+
+	// PayloadSize is a fixed32, so the unpadded length is the same whatever its value
+	p.PayloadSize = uint32(size)
+	data, err := Encode(p, key, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	unpadded := len(data)
+	if unpadded >= size {
+		p.PayloadSize = uint32(unpadded)
+		return Encode(p, key, mode)
+	}
+
+	for ; ; size++ {
+		n, ok := paddingFor(size - unpadded)
+		if !ok {
+			continue
+		}
+
+		p.PayloadSize = uint32(size)
+		p.Padding = make([]byte, n)
+
+		return Encode(p, key, mode)
+	}
+}
+
+// paddingFor returns how many bytes of padding make the padding field need bytes long on the wire.
+// The field's length prefix grows with the padding, so some lengths can't be hit exactly
+func paddingFor(need int) (int, bool) {
+	for n := need - protowire.SizeTag(paddingField) - 1; n > 0; n-- {
+		size := protowire.SizeTag(paddingField) + protowire.SizeBytes(n)
+		if size == need {
+			return n, true
+		}
+
+		if size < need {
+			return 0, false
+		}
+	}
+
+	return 0, false
+}
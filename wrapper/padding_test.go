@@ -0,0 +1,106 @@
+package wrappers
+
+import (
+	"testing"
+
+	"github.com/stormentt/packetloss/keyring"
+	packet "github.com/stormentt/packetloss/packet"
+)
+
+// framings are the ways a packet can be protected: HMAC or AEAD, in the legacy framing or the keyed one
+var framings = []struct {
+	name string
+	key  keyring.Key
+	mode Mode
+}{
+	{"hmac", keyring.NewKey("secret", 0), ModeHMAC},
+	{"key id", keyring.NewKey("secret", 7), ModeHMAC},
+	{"encrypt", keyring.NewKey("secret", 0), ModeAEAD},
+	{"encrypt key id", keyring.NewKey("secret", 7), ModeAEAD},
+}
+
+func testPacket() *packet.Packet {
+	return &packet.Packet{
+		PacketType: packet.PacketType_REQPACKET,
+		ClientID:   "client",
+		Session:    12345,
+		EchoSize:   true,
+	}
+}
+
+func TestPaddingFor(t *testing.T) {
+	cases := []struct {
+		need int
+		n    int
+		ok   bool
+	}{
+		{1, 0, false},
+		{2, 0, false},
+		{3, 1, true},
+		{129, 127, true},
+		// 128 bytes of padding need a two byte length, so the field is 131 bytes
+		{130, 0, false},
+		{131, 128, true},
+		{1400, 1397, true},
+	}
+
+	for _, tc := range cases {
+		n, ok := paddingFor(tc.need)
+		if n != tc.n || ok != tc.ok {
+			t.Errorf("paddingFor(%d) = %d, %v, expected %d, %v", tc.need, n, ok, tc.n, tc.ok)
+		}
+	}
+}
+
+// TestEncodePadded checks padded packets are the size they claim to be in every framing, since the server warns about
+// packets whose PayloadSize doesn't match what it received
+func TestEncodePadded(t *testing.T) {
+	for _, tc := range framings {
+		t.Run(tc.name, func(t *testing.T) {
+			unpadded, err := EncodePadded(testPacket(), tc.key, tc.mode, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for size := 0; size <= 1500; size++ {
+				p := testPacket()
+				data, err := EncodePadded(p, tc.key, tc.mode, size)
+				if err != nil {
+					t.Fatalf("size %d: %v", size, err)
+				}
+
+				if size == 0 {
+					if p.PayloadSize != 0 {
+						t.Errorf("unpadded packet has payload size %d", p.PayloadSize)
+					}
+
+					continue
+				}
+
+				if int(p.PayloadSize) != len(data) {
+					t.Fatalf("size %d: %d bytes, but payload size %d", size, len(data), p.PayloadSize)
+				}
+
+				// sizes below the unpadded size are clamped to it, the rest are hit exactly or overshot by a byte or two
+				expected := size
+				if size < len(unpadded) {
+					expected = len(unpadded)
+				}
+
+				if len(data) < expected || len(data) > expected+2 {
+					t.Fatalf("size %d: %d bytes, expected %d", size, len(data), expected)
+				}
+
+				decoded := &packet.Packet{}
+				_, err = DecodePacket(data, len(data), tc.key, decoded)
+				if err != nil {
+					t.Fatalf("size %d: %v", size, err)
+				}
+
+				if decoded.PayloadSize != uint32(len(data)) {
+					t.Fatalf("size %d: decoded payload size %d, received %d bytes", size, decoded.PayloadSize, len(data))
+				}
+			}
+		})
+	}
+}
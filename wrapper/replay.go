@@ -0,0 +1,251 @@
+package wrappers
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	packet "github.com/stormentt/packetloss/packet"
+)
+
+// replayWindowSize is how many serials behind the newest serial of a session are remembered
+// packets older than that are rejected, since there is no way to tell if they've been seen before
+const replayWindowSize = 1024
+
+// ReplayError is returned for authentic packets that have been seen before, or that can't be shown to be new
+// Duplicate is set for packets whose serial was already seen in their session, which happens when the network
+// duplicates a packet as well as when one is replayed
+type ReplayError struct {
+	Reason    string
+	Duplicate bool
+}
+
+func (r *ReplayError) Error() string {
+	return fmt.Sprintf("replayed packet: %s", r.Reason)
+}
+
+// replayWindow is a bitmap of recently seen serials, bit n is set if serial top-n has been seen
+type replayWindow struct {
+	top  uint64
+	bits [replayWindowSize / 64]uint64
+}
+
+// check marks serial as seen. It returns false if serial was already seen or is too old to tell
+func (w *replayWindow) check(serial uint64) bool {
+	if serial > w.top {
+		w.shift(serial - w.top)
+		w.top = serial
+		w.bits[0] |= 1
+
+		return true
+	}
+
+	distance := w.top - serial
+	if distance >= replayWindowSize {
+		return false
+	}
+
+	word, bit := distance/64, uint64(1)<<(distance%64)
+	if w.bits[word]&bit != 0 {
+		return false
+	}
+
+	w.bits[word] |= bit
+
+	return true
+}
+
+// shift moves the window forward by n serials
+func (w *replayWindow) shift(n uint64) {
+	if n >= replayWindowSize {
+		w.bits = [replayWindowSize / 64]uint64{}
+		return
+	}
+
+	words := int(n / 64)
+	rem := n % 64
+
+	for i := len(w.bits) - 1; i >= 0; i-- {
+		var v uint64
+
+		if src := i - words; src >= 0 {
+			v = w.bits[src] << rem
+			if rem > 0 && src > 0 {
+				v |= w.bits[src-1] >> (64 - rem)
+			}
+		}
+
+		w.bits[i] = v
+	}
+}
+
+type replaySession struct {
+	window   replayWindow
+	lastSeen time.Time
+
+	// retired sessions have been replaced by a newer session from the same client
+	retired bool
+}
+
+type sessionKey struct {
+	ClientID string
+	Session  uint64
+}
+
+// replayShards is how many shards a ReplayGuard is split into, so clients in different shards can be checked at once
+const replayShards = 64
+
+// ReplayGuard rejects replayed packets on the server.
+// Every packet must carry a timestamp no more than MaxSkew away from the server's clock, so a captured packet can only be
+// replayed for MaxSkew. Within that time each session remembers which serials it has seen, and a RESETPACKET can only
+// start a session once. Repeats are let through while the session is current, since the client resends its RESETPACKET
+// until it is acknowledged, and it's up to the server not to reset a session's stats twice.
+// Sessions are forgotten once their packets would fail the timestamp check anyway.
+// A ReplayGuard is safe for concurrent use. It is split into shards by ClientID like the server's StatsMap,
+// each with its own lock
+type ReplayGuard struct {
+	MaxSkew time.Duration
+
+	shards [replayShards]replayShard
+}
+
+// replayShard is one shard of a ReplayGuard, holding every session of the clients in it
+type replayShard struct {
+	mu sync.Mutex
+
+	sessions map[sessionKey]*replaySession
+	current  map[string]uint64
+
+	lastPrune time.Time
+}
+
+// NewReplayGuard creates a new ReplayGuard object
+func NewReplayGuard(maxSkew time.Duration) *ReplayGuard {
+	g := &ReplayGuard{
+		MaxSkew: maxSkew,
+	}
+
+	for i := range g.shards {
+		g.shards[i].sessions = make(map[sessionKey]*replaySession)
+		g.shards[i].current = make(map[string]uint64)
+	}
+
+	return g
+}
+
+// shard returns the shard holding client's sessions, picked with FNV-1a
+func (g *ReplayGuard) shard(client string) *replayShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(client); i++ {
+		h ^= uint32(client[i])
+		h *= 16777619
+	}
+
+	return &g.shards[h%replayShards]
+}
+
+// Check returns a ReplayError if p, received at now, is a replay. p must already be authenticated.
+// A RESETPACKET starts a new session for its client, retiring the old one.
+// Packets from an unknown session start it too, so clients keep working when the server restarts
+func (g *ReplayGuard) Check(p *packet.Packet, now time.Time) error {
+	if p.Session == 0 {
+		return &ReplayError{Reason: "no session"}
+	}
+
+	err := g.CheckTime(p, now)
+	if err != nil {
+		return err
+	}
+
+	shard := g.shard(p.ClientID)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if now.Sub(shard.lastPrune) > g.MaxSkew {
+		shard.prune(now, g.MaxSkew)
+	}
+
+	key := sessionKey{ClientID: p.ClientID, Session: p.Session}
+	rs, ok := shard.sessions[key]
+
+	switch p.PacketType {
+	case packet.PacketType_RESETPACKET:
+		// resets for the current session are let through, even once its packets have started arriving, since the
+		// reset can be resent or reordered. Only a session that has been replaced can't be reset again
+		if ok && rs.retired {
+			return &ReplayError{Reason: "session was replaced by a newer one"}
+		}
+
+		if ok {
+			rs.lastSeen = now
+			break
+		}
+
+		shard.start(key, now)
+	case packet.PacketType_REQPACKET:
+		if !ok {
+			rs = shard.start(key, now)
+		}
+
+		if rs.retired {
+			return &ReplayError{Reason: "session was replaced by a newer one"}
+		}
+
+		if !rs.window.check(p.Serial) {
+			if rs.window.top-p.Serial >= replayWindowSize {
+				return &ReplayError{Reason: fmt.Sprintf("serial %d too old", p.Serial)}
+			}
+
+			return &ReplayError{Reason: fmt.Sprintf("serial %d already seen", p.Serial), Duplicate: true}
+		}
+
+		rs.lastSeen = now
+	}
+
+	return nil
+}
+
+// CheckTime returns a ReplayError if p's timestamp is more than MaxSkew from now. It is all the checking packets
+// outside of sessions get, like mesh matrix rows, which are only accepted if they're newer than the last anyway
+func (g *ReplayGuard) CheckTime(p *packet.Packet, now time.Time) error {
+	sent := time.Unix(0, p.ClientSendTime)
+	if p.ClientSendTime == 0 || now.Sub(sent) > g.MaxSkew || sent.Sub(now) > g.MaxSkew {
+		return &ReplayError{Reason: fmt.Sprintf("timestamp %v is more than %v from server time", sent, g.MaxSkew)}
+	}
+
+	return nil
+}
+
+// start starts a new session, retiring the client's current session
+func (rs *replayShard) start(key sessionKey, now time.Time) *replaySession {
+	if old, ok := rs.sessions[sessionKey{ClientID: key.ClientID, Session: rs.current[key.ClientID]}]; ok {
+		old.retired = true
+	}
+
+	session := &replaySession{
+		lastSeen: now,
+	}
+
+	rs.sessions[key] = session
+	rs.current[key.ClientID] = key.Session
+
+	return session
+}
+
+// prune forgets sessions that haven't been seen for twice maxSkew. A client clock running maxSkew ahead can stamp
+// a packet up to maxSkew in the future, and that packet stays fresh for another maxSkew
+func (rs *replayShard) prune(now time.Time, maxSkew time.Duration) {
+	for key, session := range rs.sessions {
+		if now.Sub(session.lastSeen) <= 2*maxSkew {
+			continue
+		}
+
+		delete(rs.sessions, key)
+		if rs.current[key.ClientID] == key.Session {
+			delete(rs.current, key.ClientID)
+		}
+	}
+
+	rs.lastPrune = now
+}
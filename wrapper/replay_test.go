@@ -0,0 +1,122 @@
+package wrappers
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	packet "github.com/stormentt/packetloss/packet"
+)
+
+func guardPacket(t packet.PacketType, session, serial uint64, sent time.Time) *packet.Packet {
+	return &packet.Packet{
+		PacketType:     t,
+		ClientID:       "client",
+		Session:        session,
+		Serial:         serial,
+		ClientSendTime: sent.UnixNano(),
+	}
+}
+
+func TestReplayGuardDuplicates(t *testing.T) {
+	now := time.Now()
+	g := NewReplayGuard(time.Minute)
+
+	err := g.Check(guardPacket(packet.PacketType_REQPACKET, 1, replayWindowSize+10, now), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var replayErr *ReplayError
+
+	err = g.Check(guardPacket(packet.PacketType_REQPACKET, 1, replayWindowSize+10, now), now)
+	if !errors.As(err, &replayErr) || !replayErr.Duplicate {
+		t.Errorf("repeated serial: got %v, want a duplicate", err)
+	}
+
+	err = g.Check(guardPacket(packet.PacketType_REQPACKET, 1, 5, now), now)
+	if !errors.As(err, &replayErr) || replayErr.Duplicate {
+		t.Errorf("serial behind the window: got %v, want a replay that isn't a duplicate", err)
+	}
+
+	err = g.Check(guardPacket(packet.PacketType_REQPACKET, 1, replayWindowSize+11, now.Add(-2*time.Minute)), now)
+	if !errors.As(err, &replayErr) || replayErr.Duplicate {
+		t.Errorf("stale timestamp: got %v, want a replay that isn't a duplicate", err)
+	}
+}
+
+func TestReplayGuardResets(t *testing.T) {
+	now := time.Now()
+	g := NewReplayGuard(time.Minute)
+
+	// the session's packets overtake its reset
+	for serial := uint64(1); serial <= 3; serial++ {
+		err := g.Check(guardPacket(packet.PacketType_REQPACKET, 1, serial, now), now)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := g.Check(guardPacket(packet.PacketType_RESETPACKET, 1, 1, now), now)
+	if err != nil {
+		t.Errorf("reordered reset for the current session: got %v, want it let through", err)
+	}
+
+	// and the reset is resent
+	err = g.Check(guardPacket(packet.PacketType_RESETPACKET, 1, 2, now), now)
+	if err != nil {
+		t.Errorf("resent reset for the current session: got %v, want it let through", err)
+	}
+
+	// a new session replaces the old one, whose reset can't be replayed
+	err = g.Check(guardPacket(packet.PacketType_RESETPACKET, 2, 1, now), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = g.Check(guardPacket(packet.PacketType_RESETPACKET, 1, 1, now), now)
+	if err == nil {
+		t.Error("reset for a replaced session was let through")
+	}
+}
+
+// TestReplayGuardConcurrent checks clients in different shards as well as the same shard, from many goroutines at once.
+// Run it with -race
+func TestReplayGuardConcurrent(t *testing.T) {
+	now := time.Now()
+	g := NewReplayGuard(time.Minute)
+
+	const clients = 64
+	const packets = 100
+
+	var wg sync.WaitGroup
+	for c := 0; c < clients; c++ {
+		clientID := fmt.Sprintf("client %d", c)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for serial := uint64(1); serial <= packets; serial++ {
+				p := guardPacket(packet.PacketType_REQPACKET, 1, serial, now)
+				p.ClientID = clientID
+
+				err := g.Check(p, now)
+				if err != nil {
+					t.Errorf("%s serial %d: %v", clientID, serial, err)
+					return
+				}
+
+				err = g.Check(p, now)
+				if err == nil {
+					t.Errorf("%s serial %d was let through twice", clientID, serial)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
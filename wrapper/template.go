@@ -0,0 +1,173 @@
+package wrappers
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"hash"
+
+	"github.com/stormentt/packetloss/keyring"
+	packet "github.com/stormentt/packetloss/packet"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20poly1305"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// field numbers of the packet.Packet fields that change from packet to packet in a Template
+const (
+	serialField         = 2
+	clientSendTimeField = 4
+	payloadSizeField    = 15
+)
+
+// Template encodes a stream of packets that only differ in serial & send time, like a client's REQPACKETs, much faster
+// than Encode. The other fields are marshalled once, and each packet's serial, send time, & padding are appended to
+// them, which is still a valid packet since protobuf fields can come in any order.
+// The hasher or cipher is kept between packets, so a Template must only be used by one goroutine at a time
+type Template struct {
+	key  keyring.Key
+	mode Mode
+
+	// header is the framing before the nonce or MAC, fixed is the marshalled fields every packet shares
+	header []byte
+	fixed  []byte
+
+	hasher hash.Hash
+	aead   cipher.AEAD
+
+	// payload is reused to build each packet's protobuf
+	payload []byte
+}
+
+// NewTemplate creates a Template for packets like p, protected with key using mode.
+// p's Serial, ClientSendTime, Padding, & PayloadSize are ignored
+func NewTemplate(p *packet.Packet, key keyring.Key, mode Mode) (*Template, error) {
+	fixed := proto.Clone(p).(*packet.Packet)
+	fixed.Serial = 0
+	fixed.ClientSendTime = 0
+	fixed.Padding = nil
+	fixed.PayloadSize = 0
+
+	data, err := proto.Marshal(fixed)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Template{
+		key:   key,
+		mode:  mode,
+		fixed: data,
+	}
+
+	switch {
+	case key.ID != 0:
+		t.header = make([]byte, KEYED_HEADER_SIZE)
+		t.header[0] = HMAC_KEYED_HEADER
+		if mode == ModeAEAD {
+			t.header[0] = AEAD_KEYED_HEADER
+		}
+
+		binary.BigEndian.PutUint32(t.header[1:], key.ID)
+	case mode == ModeAEAD:
+		t.header = []byte{AEAD_HEADER}
+	}
+
+	if mode == ModeAEAD {
+		t.aead, err = newAEAD(key.MAC)
+	} else {
+		t.hasher, err = blake2b.New256(key.MAC)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// overhead returns how many bytes the framing adds to a packet's protobuf
+func (t *Template) overhead() int {
+	if t.aead != nil {
+		return len(t.header) + chacha20poly1305.NonceSizeX + t.aead.Overhead()
+	}
+
+	return len(t.header) + HMAC_SIZE
+}
+
+// AppendEncode appends the packet with serial & sendTime to dst, padded to size bytes like EncodePadded
+func (t *Template) AppendEncode(dst []byte, serial uint64, sendTime int64, size int) ([]byte, error) {
+	pl := append(t.payload[:0], t.fixed...)
+
+	if serial != 0 {
+		pl = protowire.AppendTag(pl, serialField, protowire.VarintType)
+		pl = protowire.AppendVarint(pl, serial)
+	}
+
+	if sendTime != 0 {
+		pl = protowire.AppendTag(pl, clientSendTimeField, protowire.VarintType)
+		pl = protowire.AppendVarint(pl, uint64(sendTime))
+	}
+
+	if size != 0 {
+		// payload_size is a fixed32, so the unpadded length is the same whatever its value
+		unpadded := t.overhead() + len(pl) + protowire.SizeTag(payloadSizeField) + 4
+
+		padding := 0
+		if unpadded >= size {
+			size = unpadded
+		} else {
+			for ; ; size++ {
+				n, ok := paddingFor(size - unpadded)
+				if ok {
+					padding = n
+					break
+				}
+			}
+		}
+
+		pl = protowire.AppendTag(pl, payloadSizeField, protowire.Fixed32Type)
+		pl = protowire.AppendFixed32(pl, uint32(size))
+
+		if padding > 0 {
+			pl = protowire.AppendTag(pl, paddingField, protowire.BytesType)
+			pl = protowire.AppendVarint(pl, uint64(padding))
+			pl = appendZeros(pl, padding)
+		}
+	}
+
+	t.payload = pl
+
+	dst = append(dst, t.header...)
+
+	if t.aead != nil {
+		start := len(dst)
+		dst = appendZeros(dst, chacha20poly1305.NonceSizeX)
+
+		nonce := dst[start:]
+		_, err := rand.Read(nonce)
+		if err != nil {
+			return nil, err
+		}
+
+		return t.aead.Seal(dst, nonce, pl, t.header), nil
+	}
+
+	// the keyed framing authenticates the header too, the legacy framing has none
+	t.hasher.Reset()
+	t.hasher.Write(t.header)
+	t.hasher.Write(pl)
+
+	dst = t.hasher.Sum(dst)
+
+	return append(dst, pl...), nil
+}
+
+// appendZeros appends n zero bytes to b
+func appendZeros(b []byte, n int) []byte {
+	for ; n > 0; n-- {
+		b = append(b, 0)
+	}
+
+	return b
+}
@@ -0,0 +1,150 @@
+package wrappers
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stormentt/packetloss/keyring"
+	packet "github.com/stormentt/packetloss/packet"
+)
+
+func TestTemplateRoundTrip(t *testing.T) {
+	for _, tc := range framings {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpl, err := NewTemplate(testPacket(), tc.key, tc.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, size := range []int{0, 200, 1400} {
+				data, err := tmpl.AppendEncode(nil, 42, 1000000, size)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// padding can't hit every size exactly, it comes out a byte or two larger instead
+				if size != 0 && (len(data) < size || len(data) > size+2) {
+					t.Errorf("padded to %d bytes, expected %d", len(data), size)
+				}
+
+				p := &packet.Packet{}
+				mode, err := DecodePacket(data, len(data), tc.key, p)
+				if err != nil {
+					t.Fatalf("size %d: %v", size, err)
+				}
+
+				if mode != tc.mode {
+					t.Errorf("decoded as %v, expected %v", mode, tc.mode)
+				}
+
+				if p.Serial != 42 || p.ClientSendTime != 1000000 || p.ClientID != "client" || p.Session != 12345 || !p.EchoSize {
+					t.Errorf("size %d: decoded %v", size, p)
+				}
+
+				if size != 0 && p.PayloadSize != uint32(len(data)) {
+					t.Errorf("payload size %d, expected %d", p.PayloadSize, len(data))
+				}
+			}
+		})
+	}
+}
+
+// TestTemplateMatchesEncode checks a Template's packets decode the same as Encode's, with another key they don't decode
+func TestTemplateMatchesEncode(t *testing.T) {
+	for _, tc := range framings {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpl, err := NewTemplate(testPacket(), tc.key, tc.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := tmpl.AppendEncode([]byte("prefix"), 9, 99, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(data[:6]) != "prefix" {
+				t.Fatal("AppendEncode did not append to dst")
+			}
+
+			data = data[6:]
+
+			p := testPacket()
+			p.Serial = 9
+			p.ClientSendTime = 99
+
+			encoded, err := Encode(p, tc.key, tc.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(encoded) != len(data) {
+				t.Errorf("template packet is %d bytes, Encode's is %d", len(data), len(encoded))
+			}
+
+			other := keyring.NewKey("other secret", tc.key.ID)
+			_, err = DecodePacket(data, len(data), other, &packet.Packet{})
+			if err == nil {
+				t.Error("decoded with the wrong key")
+			}
+		})
+	}
+}
+
+func BenchmarkAppendEncode(b *testing.B) {
+	for _, tc := range framings {
+		for _, size := range []int{0, 1400} {
+			b.Run(fmt.Sprintf("%s/%d", tc.name, size), func(b *testing.B) {
+				tmpl, err := NewTemplate(testPacket(), tc.key, tc.mode)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				var buf []byte
+				b.ReportAllocs()
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					buf, err = tmpl.AppendEncode(buf[:0], uint64(i+1), int64(i+1), size)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+
+				b.SetBytes(int64(len(buf)))
+			})
+		}
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	for _, tc := range framings {
+		for _, size := range []int{0, 1400} {
+			b.Run(fmt.Sprintf("%s/%d", tc.name, size), func(b *testing.B) {
+				p := testPacket()
+
+				var data []byte
+				var err error
+				b.ReportAllocs()
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					p.Serial = uint64(i + 1)
+					p.ClientSendTime = int64(i + 1)
+
+					if size != 0 {
+						data, err = EncodePadded(p, tc.key, tc.mode, size)
+					} else {
+						data, err = Encode(p, tc.key, tc.mode)
+					}
+
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+
+				b.SetBytes(int64(len(data)))
+			})
+		}
+	}
+}
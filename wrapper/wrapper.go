@@ -6,6 +6,7 @@ import (
 	"fmt"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/stormentt/packetloss/keyring"
 	packet "github.com/stormentt/packetloss/packet"
 	"golang.org/x/crypto/blake2b"
 	"google.golang.org/protobuf/proto"
@@ -37,9 +38,11 @@ func EncodePacket(p *packet.Packet, hkey []byte) ([]byte, error) {
 	calc_hmac := make([]byte, HMAC_SIZE)
 	err = hmac_data(calc_hmac, hkey, data)
 
-	log.WithFields(log.Fields{
-		"HMAC": fmt.Sprintf("%X", calc_hmac),
-	}).Debug("Calculated HMAC for Packet")
+	if log.IsLevelEnabled(log.DebugLevel) {
+		log.WithFields(log.Fields{
+			"HMAC": fmt.Sprintf("%X", calc_hmac),
+		}).Debug("Calculated HMAC for Packet")
+	}
 
 	var out bytes.Buffer
 	out.Write(calc_hmac)
@@ -49,9 +52,48 @@ func EncodePacket(p *packet.Packet, hkey []byte) ([]byte, error) {
 }
 
 // DecodePacket takes a blob of data, validates it, and decodes it into a protobuf packet.
-// hkey is used to create a keyed Blake2b hash
+// Encrypted packets are decrypted with a key derived from key, other packets are validated with a keyed Blake2b hash
+// the returned Mode says which it was. Packets must be in the keyed framing if key has an ID, or the legacy framing if not
+func DecodePacket(data []byte, n int, key keyring.Key, p *packet.Packet) (Mode, error) {
+	frame := data[:n]
+
+	if key.ID != 0 {
+		id, mode, ok := keyedHeader(frame)
+		if !ok || id != key.ID {
+			return mode, &CryptoError{
+				Reason: "packet was not protected with our key id",
+				Err:    nil,
+			}
+		}
+
+		plain, ok := openKeyed(frame, mode, key.MAC)
+		if !ok {
+			return mode, &CryptoError{
+				Reason: "packet could not be validated",
+				Err:    nil,
+			}
+		}
+
+		return mode, proto.Unmarshal(plain, p)
+	}
+
+	if plain, ok := openLegacyAEAD(frame, key.MAC); ok {
+		return ModeAEAD, proto.Unmarshal(plain, p)
+	}
+
+	return ModeHMAC, decodeHMAC(data, n, key.MAC, p)
+}
+
+// decodeHMAC validates and decodes a packet with the legacy framing, a MAC followed by the protobuf packet
 // packets are rejected if their message authentication code is invalid
-func DecodePacket(data []byte, n int, hkey []byte, p *packet.Packet) error {
+func decodeHMAC(data []byte, n int, hkey []byte, p *packet.Packet) error {
+	if n < HMAC_SIZE {
+		return &CryptoError{
+			Reason: "packet too short",
+			Err:    nil,
+		}
+	}
+
 	data_hmac := make([]byte, HMAC_SIZE)
 	copy(data_hmac, data[:HMAC_SIZE])
 
@@ -84,6 +126,114 @@ func DecodePacket(data []byte, n int, hkey []byte, p *packet.Packet) error {
 	return nil
 }
 
+// DecodeClientPacket is like DecodePacket, but the key is picked from kr.
+// Packets in the keyed framing use the key with their key ID, which may be limited to one ClientID.
+// Legacy packets use the key for the ClientID the packet claims to be from. The ClientID is read before the packet is
+// validated, nothing else in the packet is trusted until the MAC matches. Legacy encrypted packets hide their ClientID,
+// so hint is tried first if it isn't nil, and every legacy key in kr only if it fails. The ClientID inside must belong
+// to the key that decrypted it. hint should be the legacy key that last decrypted a packet from the same sender.
+// It returns the key that validated the packet and how the packet was protected
+func DecodeClientPacket(data []byte, n int, kr keyring.Keyring, p *packet.Packet, hint []byte) (keyring.Key, Mode, error) {
+	frame := data[:n]
+
+	if id, mode, ok := keyedHeader(frame); ok {
+		if key, found := kr.ByID(id); found {
+			if plain, ok := openKeyed(frame, mode, key.MAC); ok {
+				err := proto.Unmarshal(plain, p)
+				if err != nil {
+					return keyring.Key{}, mode, err
+				}
+
+				if key.ClientID != "" && key.ClientID != p.ClientID {
+					return keyring.Key{}, mode, &CryptoError{
+						Reason: fmt.Sprintf("key id %d does not belong to client %q", id, p.ClientID),
+						Err:    nil,
+					}
+				}
+
+				return key, mode, nil
+			}
+		}
+
+		// no key with that ID opened it, it may still be a legacy packet whose MAC starts with the header bytes
+	}
+
+	if n > 0 && data[0] == AEAD_HEADER {
+		if hint != nil {
+			if key, opened, err := decodeLegacyAEAD(frame, hint, kr, p); opened {
+				return key, ModeAEAD, err
+			}
+		}
+
+		for _, hkey := range kr.Keys() {
+			if hint != nil && subtle.ConstantTimeCompare(hint, hkey) == 1 {
+				continue
+			}
+
+			if key, opened, err := decodeLegacyAEAD(frame, hkey, kr, p); opened {
+				return key, ModeAEAD, err
+			}
+		}
+
+		// not encrypted with any of our keys, it may still be a legacy packet whose MAC starts with the header byte
+	}
+
+	if n < HMAC_SIZE {
+		return keyring.Key{}, ModeHMAC, &CryptoError{
+			Reason: "packet too short",
+			Err:    nil,
+		}
+	}
+
+	claimed := &packet.Packet{}
+	err := proto.Unmarshal(data[HMAC_SIZE:n], claimed)
+	if err != nil {
+		return keyring.Key{}, ModeHMAC, &CryptoError{
+			Reason: "packet could not be decrypted or decoded",
+			Err:    err,
+		}
+	}
+
+	hkey, ok := kr.Key(claimed.ClientID)
+	if !ok {
+		return keyring.Key{}, ModeHMAC, &CryptoError{
+			Reason: fmt.Sprintf("no key for client %q", claimed.ClientID),
+			Err:    nil,
+		}
+	}
+
+	err = decodeHMAC(data, n, hkey, p)
+	if err != nil {
+		return keyring.Key{}, ModeHMAC, err
+	}
+
+	return keyring.Key{MAC: hkey}, ModeHMAC, nil
+}
+
+// decodeLegacyAEAD decrypts a legacy encrypted frame with hkey into p, returning the key. It returns false if hkey
+// didn't decrypt it, or an error if the packet inside is invalid or belongs to a client that doesn't own hkey in kr
+func decodeLegacyAEAD(frame []byte, hkey []byte, kr keyring.Keyring, p *packet.Packet) (keyring.Key, bool, error) {
+	plain, ok := openLegacyAEAD(frame, hkey)
+	if !ok {
+		return keyring.Key{}, false, nil
+	}
+
+	err := proto.Unmarshal(plain, p)
+	if err != nil {
+		return keyring.Key{}, true, err
+	}
+
+	owner, ok := kr.Key(p.ClientID)
+	if !ok || subtle.ConstantTimeCompare(owner, hkey) != 1 {
+		return keyring.Key{}, true, &CryptoError{
+			Reason: fmt.Sprintf("packet for client %q was encrypted with another client's key", p.ClientID),
+			Err:    nil,
+		}
+	}
+
+	return keyring.Key{MAC: hkey}, true, nil
+}
+
 // hmac_data calculates the message authentication code for a blob of data, using hkey as a key
 func hmac_data(out, hkey, data []byte) error {
 	hasher, err := blake2b.New256(hkey)